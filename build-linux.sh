@@ -6,7 +6,11 @@ echo "Building Zuon for Linux..."
 echo "Building server..."
 CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o build/zuon-server ./cmd/server
 
-# 2. Build UI Client
+# 2. Build CLI
+echo "Building CLI..."
+CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o build/zuon-cli ./cmd/zuon-cli
+
+# 3. Build UI Client
 # Check if fyne-cross is installed
 if ! command -v fyne-cross &> /dev/null
 then
@@ -0,0 +1,1109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aomori446/zuon/internal"
+	"github.com/aomori446/zuon/internal/analysis"
+)
+
+// layoutFlags are the embedding options shared by embed and capacity.
+type layoutFlags struct {
+	sequential bool
+	bits       int
+	alpha      bool
+	noCompress bool
+	ecc        string
+	adaptive   bool
+	hamming    int
+	matching   bool
+	region     string
+	mask       string
+	recipients listFlag
+	kdfTime    uint
+	kdfMemory  uint
+	kdfThreads uint
+}
+
+func (l *layoutFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&l.sequential, "sequential", false, "fill pixels in order instead of scattering them")
+	fs.IntVar(&l.bits, "bits", 2, "bits per channel or audio sample, 1 to 4, doubled on 16-bit images")
+	fs.BoolVar(&l.alpha, "alpha", false, "also use the alpha channel")
+	fs.BoolVar(&l.noCompress, "no-compress", false, "never deflate the payload")
+	fs.StringVar(&l.ecc, "ecc", "off", "error correction `level`: off, low, medium or high")
+	fs.BoolVar(&l.adaptive, "adaptive", false, "change as few pixels as possible, mostly in textured areas; holds half a bit per channel, ignores -bits")
+	fs.IntVar(&l.hamming, "hamming", 0, "hide `k` bits in every 2^k-1 with Hamming codes, k from 2 to 7, changing at most one of them; holds k/(2^k-1) as much")
+	fs.BoolVar(&l.matching, "matching", false, "reach the bits to hide by moving pixel values up or down instead of overwriting them, which chi-square and RS analysis look for")
+	fs.StringVar(&l.region, "region", "", "only hide data in the rectangle `x,y,w,h` of an image carrier")
+	fs.StringVar(&l.mask, "mask", "", "only hide data where the grayscale image at `path` is light, stretched over the carrier")
+	fs.Var(&l.recipients, "recipient", "encrypt to an age1... public key instead of a password (repeatable)")
+	fs.UintVar(&l.kdfTime, "kdf-time", uint(internal.DefaultKDF.Time), "Argon2id passes over memory")
+	fs.UintVar(&l.kdfMemory, "kdf-memory", uint(internal.DefaultKDF.Memory), "Argon2id memory in `KiB`")
+	fs.UintVar(&l.kdfThreads, "kdf-threads", uint(internal.DefaultKDF.Threads), "Argon2id parallelism")
+}
+
+func (l *layoutFlags) options() (internal.Options, error) {
+	opts := internal.Options{
+		Traversal: internal.TraversalScattered,
+		Layout:    internal.Layout{Bits: l.bits, Channels: internal.ChannelsRGB},
+	}
+	if l.sequential {
+		opts.Traversal = internal.TraversalSequential
+	}
+	if l.alpha {
+		opts.Layout.Channels = internal.ChannelsRGBA
+	}
+	if l.noCompress {
+		opts.Compression = internal.CompressionNone
+	}
+	opts.Matching = l.matching
+	if l.adaptive {
+		opts.Coding = internal.CodingAdaptive
+	}
+	if l.hamming != 0 {
+		if l.adaptive || l.hamming < 2 || l.hamming > 7 {
+			return opts, fmt.Errorf("-hamming takes k from 2 to 7, without -adaptive")
+		}
+		opts.Coding = internal.HammingCoding(l.hamming)
+	}
+
+	levels := map[string]internal.Redundancy{
+		"off":    internal.RedundancyNone,
+		"low":    internal.RedundancyLow,
+		"medium": internal.RedundancyMedium,
+		"high":   internal.RedundancyHigh,
+	}
+	redundancy, ok := levels[l.ecc]
+	if !ok {
+		return opts, fmt.Errorf("unknown -ecc level %q", l.ecc)
+	}
+	opts.Redundancy = redundancy
+
+	opts.KDF = internal.KDF{ID: internal.KDFArgon2id, Time: uint32(l.kdfTime), Memory: uint32(l.kdfMemory), Threads: uint8(l.kdfThreads)}
+	if l.kdfTime > 1<<32-1 || l.kdfMemory > 1<<32-1 || l.kdfThreads > 255 || !opts.KDF.Valid() {
+		return opts, fmt.Errorf("-kdf-time %d, -kdf-memory %d and -kdf-threads %d are not usable Argon2id parameters", l.kdfTime, l.kdfMemory, l.kdfThreads)
+	}
+
+	switch {
+	case l.region != "" && l.mask != "":
+		return opts, fmt.Errorf("-region and -mask cannot be combined")
+	case l.region != "":
+		var x, y, w, h int
+		if _, err := fmt.Sscanf(l.region, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil || w <= 0 || h <= 0 {
+			return opts, fmt.Errorf("-region takes x,y,w,h with a positive width and height")
+		}
+		opts.Region = internal.RectRegion(image.Rect(x, y, x+w, y+h))
+	case l.mask != "":
+		mask, err := readImage(l.mask)
+		if err != nil {
+			return opts, err
+		}
+		opts.Region = internal.MaskRegion(mask)
+	}
+
+	for _, s := range l.recipients {
+		r, err := internal.ParseRecipient(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Recipients = append(opts.Recipients, r)
+	}
+	return opts, nil
+}
+
+func readImage(path string) (image.Image, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.DecodeImage(bytes.NewReader(data))
+}
+
+func readCarrier(path string) (internal.Carrier, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.DecodeCarrier(bytes.NewReader(data))
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: zuon-cli %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runEmbed(args []string) error {
+	fs := newFlagSet("embed", "-in carrier.png [-in carrier2.png ...] (-text text | -file path [-file path2 ...] [-note text]) [-hidden-text text | -hidden-file path] [-out out.png]")
+	var in listFlag
+	fs.Var(&in, "in", "carrier image or WAV/FLAC audio `path`; repeat to split the payload across several carriers")
+	out := fs.String("out", "-", "where to write the result, or a directory for several carriers; results keep the format of JPEG, BMP, TIFF, lossless WebP, GIF, WAV and FLAC carriers, others give a PNG")
+	text := fs.String("text", "", "hide this text")
+	var files listFlag
+	fs.Var(&files, "file", "hide the file at `path`; repeat to hide several files with their names")
+	note := fs.String("note", "", "text note to keep with the files")
+	ext := fs.String("ext", "", "extension to record when -file is stdin")
+	sign := fs.String("sign", "", "sign with the key in this `file`")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	streamed := fs.Bool("stream", false, "encrypt the payload in chunks while reading it instead of loading it whole; never compressed, no -ecc")
+	hiddenText := fs.String("hidden-text", "", "also hide this text under the hidden password, behind the payload as a decoy")
+	hiddenFile := fs.String("hidden-file", "", "also hide the file at `path` under the hidden password, behind the payload as a decoy")
+	analyze := fs.Bool("analyze", false, "run steganalysis on the result and report how detectable it is")
+	deniable := fs.Bool("deniable", false, "write noise behind the payload that a hidden payload cannot be told apart from; implied by -hidden-text and -hidden-file")
+
+	var layout layoutFlags
+	var password passwordSource
+	hiddenPassword := passwordSource{hidden: true}
+	layout.register(fs)
+	password.register(fs)
+	hiddenPassword.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := report{asJSON: *asJSON, toStderr: *out == "-"}
+	if len(in) == 0 || (*text == "") == (len(files) == 0) || (*note != "" && *text != "") || (*hiddenText != "" && *hiddenFile != "") {
+		fs.Usage()
+		return errUsage
+	}
+	archive := len(files) > 1 || *note != ""
+	hasHidden := *hiddenText != "" || *hiddenFile != ""
+	if stdinCount(append(append(in, files...), *hiddenFile)) > 1 {
+		return r.fail(fmt.Errorf("only one of the carriers or the payload can come from stdin"))
+	}
+	if len(in) > 1 && *out == "-" {
+		return r.fail(fmt.Errorf("-out must be a directory when splitting across several carriers"))
+	}
+	if *streamed && (len(in) > 1 || layout.ecc != "off" || layout.adaptive || archive) {
+		return r.fail(fmt.Errorf("-stream works with a single carrier and file, and without -ecc or -adaptive"))
+	}
+	if (*deniable || hasHidden) && (len(in) > 1 || *streamed || layout.adaptive || len(layout.recipients) > 0) {
+		return r.fail(fmt.Errorf("hidden payloads work with a single carrier and a password, without -stream or -adaptive"))
+	}
+
+	opts, err := layout.options()
+	if err != nil {
+		return r.fail(err)
+	}
+
+	if *sign != "" {
+		if opts.SigningKey, err = readSigningKey(*sign); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	carriers := make([]internal.Carrier, len(in))
+	for i, path := range in {
+		if carriers[i], err = readCarrier(path); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	if *streamed {
+		pass, err := embedPassword(password, opts)
+		if err != nil {
+			return r.fail(err)
+		}
+		return embedStream(r, carriers[0], *out, *text, files.first(), *ext, pass, opts, *analyze)
+	}
+
+	data, extension := []byte(*text), ""
+	if len(files) > 0 {
+		if data, err = readArchive(files, *note, *ext); err != nil {
+			return r.fail(err)
+		}
+		extension = internal.ArchiveExtension
+	}
+
+	pass, err := embedPassword(password, opts)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	if *deniable || hasHidden {
+		hidden := internal.Payload{Data: []byte(*hiddenText)}
+		if *hiddenFile != "" {
+			if hidden.Data, err = readArchive([]string{*hiddenFile}, "", *ext); err != nil {
+				return r.fail(err)
+			}
+			hidden.Extension = internal.ArchiveExtension
+		}
+		if hasHidden {
+			if hidden.Password, err = hiddenPassword.read(true); err != nil {
+				return r.fail(err)
+			}
+		}
+		return embedDeniable(r, carriers[0], *out, internal.Payload{Data: data, Extension: extension, Password: pass}, hidden, opts, *analyze)
+	}
+
+	if len(carriers) > 1 {
+		return embedShards(r, carriers, in, *out, data, extension, pass, opts, *analyze)
+	}
+
+	result, err := internal.EmbedData(carriers[0], data, extension, 0, pass, opts)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	if err = writeOutput(*out, func(w io.Writer) error { return internal.EncodeCarrier(w, result) }); err != nil {
+		return r.fail(err)
+	}
+
+	payload, capacity := embedSizes(carriers, int64(internal.PayloadSize(data, extension, opts)), opts)
+	printEmbedded(r, map[string]interface{}{
+		"output":   *out,
+		"payload":  payload,
+		"capacity": capacity,
+		"signed":   opts.SigningKey != nil,
+	}, fmt.Sprintf("embedded %d of %d bytes into %s", payload, capacity, *out), *analyze, result)
+	return nil
+}
+
+// fileExtension is the extension recorded for the payload file at path, or
+// ext for stdin.
+func fileExtension(path, ext string) string {
+	if path != "-" && ext == "" {
+		ext = filepath.Ext(path)
+	}
+	if ext == "" {
+		ext = ".bin"
+	}
+	return ext
+}
+
+// readArchive bundles files and note into an archive payload like
+// internal.ReadArchive, with stdin named after ext.
+func readArchive(files []string, note, ext string) ([]byte, error) {
+	a := &internal.Archive{Note: note}
+	for _, path := range files {
+		if path != "-" {
+			e, err := internal.ReadEntry(path)
+			if err != nil {
+				return nil, err
+			}
+			a.Entries = append(a.Entries, e)
+			continue
+		}
+
+		data, err := readInput(path)
+		if err != nil {
+			return nil, err
+		}
+		name := "stdin" + fileExtension(path, ext)
+		info := internal.FileInfo{Name: name, Mode: 0o644, ModTime: time.Now(), MIME: internal.DetectMIME(name, data)}
+		a.Entries = append(a.Entries, internal.NewEntry(info, data))
+	}
+	return a.MarshalBinary()
+}
+
+func embedPassword(password passwordSource, opts internal.Options) (string, error) {
+	if len(opts.Recipients) > 0 {
+		return "", nil
+	}
+	return password.read(true)
+}
+
+// streamWriter is an internal.EmbedWriter or internal.FileWriter.
+type streamWriter interface {
+	io.WriteCloser
+	Carrier() internal.Carrier
+}
+
+// embedStream copies the payload into the carrier through an EmbedWriter, so
+// files larger than memory can be hidden in carriers that are not. Files keep
+// their name and metadata; stdin, whose size is unknown up front, only its
+// extension.
+func embedStream(r report, carrier internal.Carrier, out, text, file, ext, password string, opts internal.Options, analyze bool) error {
+	var src io.Reader = strings.NewReader(text)
+	var w streamWriter
+	var err error
+	size := func(n int64) int64 { return internal.StreamPayloadSize(n, "", opts) }
+
+	switch file {
+	case "":
+		w, err = internal.NewEmbedWriter(carrier, "", password, opts)
+	case "-":
+		extension := fileExtension(file, ext)
+		src = os.Stdin
+		size = func(n int64) int64 { return internal.StreamPayloadSize(n, extension, opts) }
+		w, err = internal.NewEmbedWriter(carrier, extension, password, opts)
+	default:
+		f, err := os.Open(file)
+		if err != nil {
+			return r.fail(err)
+		}
+		defer f.Close()
+
+		stat, err := f.Stat()
+		if err != nil {
+			return r.fail(err)
+		}
+		br := bufio.NewReader(f)
+		head, _ := br.Peek(512)
+		info := internal.NewFileInfo(stat, head)
+
+		src = br
+		size = func(int64) int64 { return internal.StreamFileSize(info, opts) }
+		if w, err = internal.NewFileWriter(carrier, info, password, opts); err != nil {
+			return r.fail(err)
+		}
+	}
+	if err != nil {
+		return r.fail(err)
+	}
+
+	n, err := io.Copy(w, src)
+	if err != nil {
+		return r.fail(err)
+	}
+	if err = w.Close(); err != nil {
+		return r.fail(err)
+	}
+
+	if err = writeOutput(out, func(dst io.Writer) error { return internal.EncodeCarrier(dst, w.Carrier()) }); err != nil {
+		return r.fail(err)
+	}
+
+	payload, capacity := embedSizes([]internal.Carrier{carrier}, size(n), opts)
+	printEmbedded(r, map[string]interface{}{
+		"output":   out,
+		"payload":  payload,
+		"capacity": capacity,
+		"signed":   opts.SigningKey != nil,
+	}, fmt.Sprintf("embedded %d of %d bytes into %s", payload, capacity, out), analyze, w.Carrier())
+	return nil
+}
+
+// embedDeniable writes payload as a decoy and hidden, if it has a password,
+// into the noise behind it.
+func embedDeniable(r report, carrier internal.Carrier, out string, payload, hidden internal.Payload, opts internal.Options, analyze bool) error {
+	result, err := internal.EmbedDeniable(carrier, payload, hidden, opts)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	if err = writeOutput(out, func(w io.Writer) error { return internal.EncodeCarrier(w, result) }); err != nil {
+		return r.fail(err)
+	}
+
+	size, capacity := embedSizes([]internal.Carrier{carrier}, int64(internal.PayloadSize(payload.Data, payload.Extension, opts)), opts)
+	hiddenSize := 0
+	if hidden.Password != "" {
+		hiddenSize = internal.HiddenSize(hidden.Data, hidden.Extension, opts)
+	}
+	printEmbedded(r, map[string]interface{}{
+		"output":   out,
+		"payload":  size,
+		"hidden":   hiddenSize,
+		"capacity": capacity,
+		"signed":   opts.SigningKey != nil,
+	}, fmt.Sprintf("embedded %d bytes and %d hidden bytes of %d into %s", size, hiddenSize, capacity, out), analyze, result)
+	return nil
+}
+
+// embedShards splits the payload across carriers and writes one numbered file
+// per carrier into dir.
+func embedShards(r report, carriers []internal.Carrier, paths []string, dir string, data []byte, extension, password string, opts internal.Options, analyze bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return r.fail(err)
+	}
+
+	results, err := internal.EmbedShards(carriers, data, extension, password, opts)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	outputs := make([]string, len(results))
+	for i, c := range results {
+		base := strings.TrimSuffix(filepath.Base(paths[i]), filepath.Ext(paths[i]))
+		if paths[i] == "-" {
+			base = "stdin"
+		}
+		outputs[i] = filepath.Join(dir, fmt.Sprintf("%s_zuon_%dof%d%s", base, i+1, len(results), internal.CarrierExtension(c)))
+
+		err = writeOutput(outputs[i], func(w io.Writer) error { return internal.EncodeCarrier(w, c) })
+		if err != nil {
+			return r.fail(err)
+		}
+	}
+
+	payload, capacity := embedSizes(carriers, int64(internal.PayloadSize(data, extension, opts)), opts)
+	printEmbedded(r, map[string]interface{}{
+		"outputs":  outputs,
+		"payload":  payload,
+		"capacity": capacity,
+		"signed":   opts.SigningKey != nil,
+	}, fmt.Sprintf("embedded %d of %d bytes into %d carriers in %s", payload, capacity, len(outputs), dir), analyze, results...)
+	return nil
+}
+
+// embedSizes returns the capacity embed reports for carriers, which is the
+// one the capacity command shows for text, and what a payload using size
+// bytes of internal.Capacity takes from it.
+func embedSizes(carriers []internal.Carrier, size int64, opts internal.Options) (int64, int) {
+	r := internal.CapacityFor(carriers[0], nil, opts)
+	if len(carriers) > 1 {
+		r = internal.ShardCapacityFor(carriers, nil, opts)
+	}
+	return size - int64(r.Extension), r.Payload
+}
+
+// printEmbedded prints the result of embed, followed by the steganalysis of
+// every written image when analyze is set. Audio is not analyzed.
+func printEmbedded(r report, info map[string]interface{}, text string, analyze bool, results ...internal.Carrier) {
+	if analyze {
+		var reports []map[string]interface{}
+		for _, c := range results {
+			img, ok := c.(image.Image)
+			if !ok {
+				continue
+			}
+			a, t := analysisInfo(analysis.Analyze(img))
+			reports = append(reports, a)
+			text += "\n\n" + t
+		}
+		if len(reports) == 1 {
+			info["analysis"] = reports[0]
+		} else if len(reports) > 1 {
+			info["analysis"] = reports
+		}
+	}
+	r.print(info, text)
+}
+
+func stdinCount(paths []string) int {
+	n := 0
+	for _, p := range paths {
+		if p == "-" {
+			n++
+		}
+	}
+	return n
+}
+
+func runExtract(args []string) error {
+	fs := newFlagSet("extract", "-in image.png [-in part2.png ...] [-out path]")
+	var in listFlag
+	fs.Var(&in, "in", "image or audio `path`; repeat for every carrier of a split payload, in any order")
+	out := fs.String("out", "-", "where to write the payload, or a directory to save the files of a payload in")
+	list := fs.Bool("list", false, "only list the files and note of the payload")
+	var entries listFlag
+	fs.Var(&entries, "entry", "save only the file `name` when -out is a directory; repeatable")
+	identity := fs.String("identity", "", "decrypt with the private key in this `file`")
+	trust := fs.String("trust", "", "`file` of zuonsig1... signers to trust, one per line")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+
+	var password passwordSource
+	password.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := report{asJSON: *asJSON, toStderr: *out == "-"}
+	if len(in) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if stdinCount(in) > 1 {
+		return r.fail(fmt.Errorf("only one image can come from stdin"))
+	}
+
+	opts := internal.Options{Traversal: internal.TraversalScattered}
+
+	if *identity != "" {
+		f, err := os.Open(*identity)
+		if err != nil {
+			return r.fail(err)
+		}
+		opts.Identities, err = internal.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return r.fail(err)
+		}
+	}
+
+	if *trust != "" {
+		data, err := os.ReadFile(*trust)
+		if err != nil {
+			return r.fail(err)
+		}
+		if opts.TrustedSigners, err = internal.ParseSigners(string(data)); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	imgs := make([]internal.Carrier, len(in))
+	for i, path := range in {
+		var err error
+		if imgs[i], err = readCarrier(path); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	var pass string
+	if len(opts.Identities) == 0 {
+		var err error
+		if pass, err = password.read(false); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	toDir := *list || isDir(*out)
+	if len(imgs) == 1 && *out != "-" && !toDir {
+		return extractStream(r, imgs[0], *out, pass, opts)
+	}
+
+	var data []byte
+	var extension string
+	var verification internal.Verification
+	var err error
+	if len(imgs) > 1 {
+		data, extension, verification, err = internal.ExtractShards(imgs, pass, opts)
+	} else {
+		data, extension, verification, err = internal.ExtractData(imgs[0], 0, pass, opts)
+	}
+	if err != nil {
+		return r.fail(err)
+	}
+
+	if toDir {
+		return extractArchive(r, data, extension, *out, *list, entries, verification)
+	}
+	e, err := internal.OpenFile(data, extension)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	// Text is printed as is; anything else still refuses a terminal.
+	if *out == "-" && extension == "" {
+		_, err = os.Stdout.Write(e.Data)
+	} else {
+		err = writeOutput(*out, func(w io.Writer) error {
+			_, err := w.Write(e.Data)
+			return err
+		})
+	}
+	if err == nil && *out != "-" {
+		err = restoreModTime(*out, e.FileInfo)
+	}
+	if err != nil {
+		return r.fail(err)
+	}
+
+	printExtracted(r, *out, e.FileInfo, verification)
+	return nil
+}
+
+// extractStream decrypts the payload into a temporary file next to out,
+// which only replaces out once extraction succeeded, so a wrong password
+// leaves an existing file alone.
+func extractStream(r report, img internal.Carrier, out, password string, opts internal.Options) error {
+	f, err := os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".*")
+	if err != nil {
+		return r.fail(err)
+	}
+	tmp := f.Name()
+
+	// Keep the permissions an existing file had, or give a new one those
+	// of os.Create.
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(out); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	info, verification, err := internal.ExtractTo(f, img, password, opts)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = restoreModTime(tmp, info)
+	}
+	if err == nil {
+		err = os.Rename(tmp, out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return r.fail(err)
+	}
+
+	printExtracted(r, out, info, verification)
+	return nil
+}
+
+func restoreModTime(path string, info internal.FileInfo) error {
+	if info.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, info.ModTime, info.ModTime)
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// extractArchive lists the entries of a payload or saves them into dir,
+// restoring their permissions and modification times. Only the entries named
+// in pick are saved when it is not empty.
+func extractArchive(r report, data []byte, extension, dir string, list bool, pick []string, verification internal.Verification) error {
+	a, err := internal.OpenArchive(data, extension)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	wanted := make(map[string]bool, len(pick))
+	for _, name := range pick {
+		wanted[name] = true
+	}
+	for _, e := range a.Entries {
+		delete(wanted, e.Name)
+	}
+	for _, name := range pick {
+		if wanted[name] {
+			return r.fail(fmt.Errorf("the payload has no file named %q", name))
+		}
+		wanted[name] = true
+	}
+
+	var saved []map[string]interface{}
+	var text strings.Builder
+	for _, e := range a.Entries {
+		if len(wanted) > 0 && !wanted[e.Name] {
+			continue
+		}
+
+		info := map[string]interface{}{"name": e.Name, "size": e.Size, "mode": e.Mode.String(), "mime": e.MIME}
+		if !e.ModTime.IsZero() {
+			info["mtime"] = e.ModTime.Format(time.RFC3339)
+		}
+		saved = append(saved, info)
+		fmt.Fprintf(&text, "%s  %10d  %-24s  %s\n", e.Mode, e.Size, e.MIME, e.Name)
+
+		if list {
+			continue
+		}
+		path := filepath.Join(dir, e.Name)
+		if err := os.WriteFile(path, e.Data, e.SaveMode()); err != nil {
+			return r.fail(err)
+		}
+		if err := restoreModTime(path, e.FileInfo); err != nil {
+			return r.fail(err)
+		}
+	}
+	if a.Note != "" {
+		fmt.Fprintf(&text, "note:\n%s\n", a.Note)
+	}
+	if list {
+		fmt.Fprintf(&text, "%d files", len(saved))
+	} else {
+		fmt.Fprintf(&text, "extracted %d files to %s", len(saved), dir)
+	}
+	if verification.Status != internal.SignatureNone {
+		fmt.Fprintf(&text, " (signature: %s)", signatureStatus(verification.Status))
+	}
+
+	result := map[string]interface{}{
+		"output":    dir,
+		"files":     saved,
+		"note":      a.Note,
+		"signature": signatureInfo(verification),
+	}
+	if list {
+		delete(result, "output")
+	}
+	r.print(result, text.String())
+	return nil
+}
+
+func signatureInfo(verification internal.Verification) map[string]string {
+	signature := map[string]string{"status": signatureStatus(verification.Status)}
+	if verification.Signer != nil {
+		signature["signer"] = verification.Signer.String()
+	}
+	return signature
+}
+
+func printExtracted(r report, out string, info internal.FileInfo, verification internal.Verification) {
+	signature := signatureInfo(verification)
+
+	text := fmt.Sprintf("extracted %d bytes to %s", info.Size, out)
+	if info.Name != "" {
+		text = fmt.Sprintf("extracted %s (%d bytes, %s) to %s", info.Name, info.Size, info.MIME, out)
+	}
+	if verification.Status != internal.SignatureNone {
+		text += fmt.Sprintf(" (signature: %s)", signature["status"])
+	}
+
+	result := map[string]interface{}{
+		"output":    out,
+		"size":      info.Size,
+		"name":      info.Name,
+		"extension": filepath.Ext(info.Name),
+		"mime":      info.MIME,
+		"signature": signature,
+	}
+	if !info.ModTime.IsZero() {
+		result["mtime"] = info.ModTime.Format(time.RFC3339)
+	}
+	r.print(result, text)
+}
+
+func runCapacity(args []string) error {
+	fs := newFlagSet("capacity", "-in carrier.png [-file path]")
+	in := fs.String("in", "", "carrier image or audio `path`")
+	file := fs.String("file", "", "count the room left for the file at `path` instead of for text")
+	sign := fs.String("sign", "", "count room for a signature with the key in this `file`")
+	exact := fs.Bool("exact", false, "with -region or -mask, read the password to count exactly which pixels the scattered header takes from the region")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+
+	var layout layoutFlags
+	layout.register(fs)
+
+	var password passwordSource
+	password.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := report{asJSON: *asJSON}
+	if *in == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	opts, err := layout.options()
+	if err != nil {
+		return r.fail(err)
+	}
+	if !opts.Layout.Valid() {
+		return r.fail(internal.ErrInvalidLayout)
+	}
+
+	if *sign != "" {
+		if opts.SigningKey, err = readSigningKey(*sign); err != nil {
+			return r.fail(err)
+		}
+	}
+	if *exact {
+		if opts.ScatterKey, err = password.read(false); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	carrier, err := readCarrier(*in)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	var payload *internal.FileInfo
+	if *file != "" {
+		info, err := internal.StatFile(*file)
+		if err != nil {
+			return r.fail(err)
+		}
+		payload = &info
+	}
+
+	capacity := internal.CapacityFor(carrier, payload, opts)
+	info := map[string]interface{}{
+		"capacity":  capacity.Payload,
+		"estimated": capacity.Estimated,
+		"total":     capacity.Total,
+		"overhead": map[string]interface{}{
+			"header":     capacity.Header,
+			"parity":     capacity.Parity,
+			"encryption": capacity.Encryption,
+			"extension":  capacity.Extension,
+			"archive":    capacity.Archive,
+			"signature":  capacity.Signature,
+		},
+		"bits": opts.Layout.Bits,
+	}
+	switch c := carrier.(type) {
+	case *internal.Audio:
+		info["samples"] = len(c.Samples)
+		info["sample_rate"] = c.SampleRate
+	case image.Image:
+		bounds := c.Bounds()
+		info["width"] = bounds.Dx()
+		info["height"] = bounds.Dy()
+		info["channels"] = channelNames(opts.Layout.Channels)
+	}
+
+	var text strings.Builder
+	if capacity.Estimated {
+		text.WriteString("at least ")
+	}
+	fmt.Fprintf(&text, "%d bytes", capacity.Payload)
+	if payload != nil {
+		fmt.Fprintf(&text, " of %s", filepath.Base(*file))
+	}
+	fmt.Fprintf(&text, " in %d\n", capacity.Total)
+	fmt.Fprintf(&text, "header      %d\n", capacity.Header)
+	fmt.Fprintf(&text, "parity      %d\n", capacity.Parity)
+	fmt.Fprintf(&text, "encryption  %d\n", capacity.Encryption)
+	fmt.Fprintf(&text, "extension   %d\n", capacity.Extension)
+	fmt.Fprintf(&text, "archive     %d\n", capacity.Archive)
+	fmt.Fprintf(&text, "signature   %d", capacity.Signature)
+	r.print(info, text.String())
+	return nil
+}
+
+func readSigningKey(path string) (*internal.SigningKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return internal.ParseSigningKeyFile(f)
+}
+
+func runInspect(args []string) error {
+	fs := newFlagSet("inspect", "-in image.png")
+	in := fs.String("in", "", "image or audio `path`")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	scatter := fs.Bool("scattered", false, "also look for a scattered header, which needs the password")
+
+	var password passwordSource
+	password.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := report{asJSON: *asJSON}
+	if *in == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	carrier, err := readCarrier(*in)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	var key string
+	if *scatter {
+		if key, err = password.read(false); err != nil {
+			return r.fail(err)
+		}
+	}
+
+	header, kdf, err := internal.Inspect(carrier, 0, key)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	info := map[string]interface{}{
+		"version":  header.Version,
+		"flags":    flagNames(header.Flags),
+		"bits":     header.Layout.Bits,
+		"channels": channelNames(header.Layout.Channels),
+		"kdf":      kdfInfo(kdf),
+		"cipher":   cipherName(header.Cipher),
+		"kind":     kindName(header.Kind),
+		"length":   header.Length,
+	}
+
+	layout := fmt.Sprintf("%d bits, %s", header.Layout.Bits, channelNames(header.Layout.Channels))
+	if p, ok := carrier.(*internal.Picture); ok {
+		carrier = p.Image
+	}
+	switch carrier.(type) {
+	case *image.Paletted:
+		delete(info, "bits")
+		delete(info, "channels")
+		layout = "palette indices"
+	case *internal.Audio:
+		delete(info, "channels")
+		layout = fmt.Sprintf("%d bits per sample", header.Layout.Bits)
+	}
+	if header.Flags&internal.FlagDCT != 0 {
+		delete(info, "bits")
+		delete(info, "channels")
+		layout = "jpeg dct coefficients"
+	}
+	if header.Coding == internal.CodingAdaptive {
+		info["coding"] = "adaptive"
+		layout += ", adaptive"
+	}
+	if k := header.Coding.Hamming(); k != 0 {
+		info["coding"] = fmt.Sprintf("hamming-%d", k)
+		layout += fmt.Sprintf(", %d in %d bits", k, 1<<k-1)
+	}
+	if header.Masked {
+		info["region"] = true
+		layout += ", region"
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "version   %d\n", header.Version)
+	fmt.Fprintf(&text, "flags     %s\n", strings.Join(flagNames(header.Flags), ", "))
+	fmt.Fprintf(&text, "layout    %s\n", layout)
+	fmt.Fprintf(&text, "kdf       %v\n", kdfInfo(kdf))
+	fmt.Fprintf(&text, "cipher    %s\n", cipherName(header.Cipher))
+	fmt.Fprintf(&text, "kind      %s\n", kindName(header.Kind))
+	fmt.Fprintf(&text, "length    %d bytes", header.Length)
+	r.print(info, text.String())
+	return nil
+}
+
+func runAnalyze(args []string) error {
+	fs := newFlagSet("analyze", "-in image.png")
+	in := fs.String("in", "", "image `path`")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := report{asJSON: *asJSON}
+	if *in == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	img, err := readImage(*in)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	info, text := analysisInfo(analysis.Analyze(img))
+	r.print(info, text)
+	return nil
+}
+
+// analysisInfo describes a steganalysis report as JSON and as a table.
+func analysisInfo(a analysis.Report) (map[string]interface{}, string) {
+	var channels []map[string]interface{}
+	var text strings.Builder
+	fmt.Fprintf(&text, "channel  chi-square  rs      spa\n")
+	for _, c := range a.Channels {
+		channels = append(channels, map[string]interface{}{
+			"name":       c.Name,
+			"chi_square": c.ChiSquare,
+			"rs":         c.RS,
+			"spa":        c.SPA,
+		})
+		if a.DCT {
+			fmt.Fprintf(&text, "%-7s  %.3f       -       -\n", c.Name, c.ChiSquare)
+		} else {
+			fmt.Fprintf(&text, "%-7s  %.3f       %.3f   %.3f\n", c.Name, c.ChiSquare, c.RS, c.SPA)
+		}
+	}
+
+	verdict := "not flagged"
+	if a.Detectable() {
+		verdict = "flagged"
+	}
+	if a.DCT {
+		fmt.Fprintf(&text, "%s; RS and SPA do not apply to JPEG coefficients", verdict)
+	} else {
+		fmt.Fprintf(&text, "estimated embedding rate %.1f%%, %s", a.Rate*100, verdict)
+	}
+
+	return map[string]interface{}{
+		"channels":   channels,
+		"chi_square": a.ChiSquare,
+		"rs":         a.RS,
+		"spa":        a.SPA,
+		"rate":       a.Rate,
+		"dct":        a.DCT,
+		"detectable": a.Detectable(),
+	}, text.String()
+}
+
+func signatureStatus(s internal.SignatureStatus) string {
+	switch s {
+	case internal.SignatureVerified:
+		return "verified"
+	case internal.SignatureUnknownSigner:
+		return "unknown_signer"
+	case internal.SignatureInvalid:
+		return "invalid"
+	}
+	return "none"
+}
+
+func channelNames(mask internal.ChannelMask) string {
+	var out string
+	for i, name := range []string{"R", "G", "B", "A"} {
+		if mask&(internal.ChannelR<<i) != 0 {
+			out += name
+		}
+	}
+	return out
+}
+
+func flagNames(flags internal.HeaderFlags) []string {
+	out := []string{}
+	names := []struct {
+		flag internal.HeaderFlags
+		name string
+	}{
+		{internal.FlagScattered, "scattered"},
+		{internal.FlagSkipTransparent, "skip_transparent"},
+		{internal.FlagCompressed, "compressed"},
+		{internal.FlagSigned, "signed"},
+		{internal.FlagSharded, "sharded"},
+		{internal.FlagECC, "ecc"},
+		{internal.FlagDCT, "dct"},
+		{internal.FlagCoded, "coded"},
+	}
+	for _, n := range names {
+		if flags&n.flag != 0 {
+			out = append(out, n.name)
+		}
+	}
+	return out
+}
+
+func cipherName(c internal.CipherID) string {
+	if c == internal.CipherAES256GCMStream {
+		return "aes-256-gcm-stream"
+	}
+	return "aes-256-gcm"
+}
+
+func kindName(k internal.PayloadKind) string {
+	switch k {
+	case internal.KindText:
+		return "text"
+	case internal.KindArchive:
+		return "archive"
+	}
+	return "file"
+}
+
+func kdfInfo(k internal.KDF) map[string]interface{} {
+	switch k.ID {
+	case internal.KDFPBKDF2SHA256:
+		return map[string]interface{}{"name": "pbkdf2-sha256", "iterations": k.Iterations}
+	case internal.KDFArgon2id:
+		return map[string]interface{}{"name": "argon2id", "time": k.Time, "memory_kib": k.Memory, "threads": k.Threads}
+	case internal.KDFX25519:
+		return map[string]interface{}{"name": "x25519", "recipients": k.Recipients}
+	}
+	return map[string]interface{}{"name": "unknown"}
+}
@@ -0,0 +1,279 @@
+// Command zuon-cli embeds and extracts Zuon payloads without a display, for
+// scripts and servers.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aomori446/zuon/internal"
+	"golang.org/x/term"
+)
+
+const usage = `usage: zuon-cli <command> [flags]
+
+commands:
+  embed     hide text or a file inside a carrier image or audio file
+  extract   recover a payload from a carrier
+  capacity  show how many bytes a carrier can hold
+  inspect   print the container header of a carrier
+  analyze   estimate how detectable hidden data in an image is
+
+Run "zuon-cli <command> -h" for the flags of a command.
+Use "-" as a path to read from stdin or write to stdout.
+`
+
+// messages mirrors the English locale for errors the CLI can run into.
+var messages = map[error]string{
+	internal.ErrImageNotSupported:  "This image format is not supported.",
+	internal.ErrAudioNotSupported:  "Only uncompressed PCM WAV and FLAC audio is supported.",
+	internal.ErrImageTooSmall:      "The carrier image is too small for this payload.",
+	internal.ErrDataNotFound:       "No hidden data was found in this image.",
+	internal.ErrDecryptionFailed:   "Decryption failed: wrong password or key, or corrupted data.",
+	internal.ErrExtensionTooLong:   "The file extension is too long.",
+	internal.ErrUnsupportedFormat:  "This image was written by a newer or unknown version of Zuon.",
+	internal.ErrInvalidLayout:      "Choose 1 to 4 bits and at least one color channel.",
+	internal.ErrInvalidRecipient:   "One of the public keys is not a valid age1... key.",
+	internal.ErrInvalidIdentity:    "The key file does not contain a valid private key.",
+	internal.ErrIdentityRequired:   "This image was encrypted to public keys; pass -identity.",
+	internal.ErrInvalidSigner:      "The signer key is not a valid zuonsig1... key.",
+	internal.ErrInvalidSigningKey:  "The file does not contain a valid signing key.",
+	internal.ErrShardMismatch:      "These images do not belong to the same hidden payload.",
+	internal.ErrPayloadDamaged:     "The image is too damaged to recover the hidden data.",
+	internal.ErrInvalidEntryName:   "Every file needs a distinct name without path separators.",
+	internal.ErrSamePassword:       "The hidden payload needs another password than the decoy.",
+	internal.ErrHiddenTooLarge:     "The hidden payload must not be larger than the decoy.",
+	internal.ErrSeveralFiles:       "This payload holds several files or a note; pass a directory as -out, or -list.",
+	internal.ErrPasswordShort:      "The password must be at least 6 characters long.",
+	internal.ErrRegionNotSupported: "Regions only work on PNG, BMP, TIFF and lossless WebP images.",
+	internal.ErrUnsupportedCarrier: "Data can only be hidden in images and WAV or FLAC audio.",
+	internal.ErrInternal:           "An internal error occurred.",
+}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) error{
+		"embed":    runEmbed,
+		"extract":  runExtract,
+		"capacity": runCapacity,
+		"inspect":  runInspect,
+		"analyze":  runAnalyze,
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := run(os.Args[2:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// report prints a command result, as JSON when asJSON is set. The report
+// goes to stderr when stdout carries the payload or image itself.
+type report struct {
+	asJSON   bool
+	toStderr bool
+}
+
+func (r report) out() io.Writer {
+	if r.toStderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func (r report) print(v interface{}, text string) {
+	if r.asJSON {
+		enc := json.NewEncoder(r.out())
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(v)
+		return
+	}
+	if text != "" {
+		fmt.Fprintln(r.out(), text)
+	}
+}
+
+// fail reports err and returns it, so commands can end with return r.fail(err).
+func (r report) fail(err error) error {
+	code, msg := "err_internal", err.Error()
+	for known, text := range messages {
+		if errors.Is(err, known) {
+			code, msg = known.Error(), text
+			break
+		}
+	}
+
+	result := map[string]interface{}{"error": code, "message": msg}
+
+	var missing *internal.MissingShardsError
+	if errors.As(err, &missing) {
+		code = internal.ErrMissingShards.Error()
+		msg = fmt.Sprintf("This payload is split across %d images; missing parts: %s.", missing.Total, strings.Trim(fmt.Sprint(missing.Missing), "[]"))
+		result = map[string]interface{}{"error": code, "message": msg, "total": missing.Total, "missing": missing.Missing}
+	}
+
+	if r.asJSON {
+		enc := json.NewEncoder(r.out())
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(result)
+	} else {
+		fmt.Fprintln(os.Stderr, "zuon-cli:", msg)
+	}
+	return err
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return errors.New("refusing to write binary data to a terminal; use -out")
+		}
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// passwordSource resolves the password from a file, an environment variable
+// or, as a last resort, an interactive prompt.
+type passwordSource struct {
+	file string
+	env  string
+
+	// hidden selects the flags, variable and prompt of the password of a
+	// hidden payload.
+	hidden bool
+}
+
+func (p *passwordSource) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.file, p.flag("password-file"), "", "read the "+p.name()+" from the first line of `path`")
+	fs.StringVar(&p.env, p.flag("password-env"), p.defaultEnv(), "read the "+p.name()+" from the environment variable `name`")
+}
+
+func (p *passwordSource) flag(name string) string {
+	if p.hidden {
+		return "hidden-" + name
+	}
+	return name
+}
+
+func (p *passwordSource) name() string {
+	if p.hidden {
+		return "hidden password"
+	}
+	return "password"
+}
+
+func (p *passwordSource) defaultEnv() string {
+	if p.hidden {
+		return "ZUON_HIDDEN_PASSWORD"
+	}
+	return "ZUON_PASSWORD"
+}
+
+func (p *passwordSource) read(confirm bool) (string, error) {
+	if p.file != "" {
+		data, err := os.ReadFile(p.file)
+		if err != nil {
+			return "", err
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		return checkPassword(strings.TrimRight(line, "\r"))
+	}
+
+	if password, ok := os.LookupEnv(p.env); ok && p.env != "" {
+		return checkPassword(password)
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no " + p.name() + " given; use -" + p.flag("password-file") + " or $" + p.env)
+	}
+	defer tty.Close()
+
+	label := "Password: "
+	if p.hidden {
+		label = "Hidden password: "
+	}
+	password, err := prompt(tty, label)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := prompt(tty, "Confirm "+strings.ToLower(label))
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return checkPassword(password)
+}
+
+func prompt(tty *os.File, label string) (string, error) {
+	fmt.Fprint(tty, label)
+	defer fmt.Fprintln(tty)
+
+	password, err := term.ReadPassword(int(tty.Fd()))
+	return string(password), err
+}
+
+func checkPassword(password string) (string, error) {
+	if internal.ValidatePassword(password) != nil {
+		return "", internal.ErrPasswordShort
+	}
+	return password, nil
+}
+
+// listFlag collects a flag that may be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// first returns the first value, or "" when the flag was not given.
+func (l listFlag) first() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return l[0]
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
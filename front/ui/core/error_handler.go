@@ -2,6 +2,8 @@ package core
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 	
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/dialog"
@@ -15,9 +17,21 @@ func ShowLocalizedError(err error, parent fyne.Window) {
 	}
 	
 	var msg string
+	var missing *internal.MissingShardsError
 	switch {
+	case errors.As(err, &missing):
+		parts := make([]string, len(missing.Missing))
+		for i, n := range missing.Missing {
+			parts[i] = strconv.Itoa(n)
+		}
+		msg = i18n.Tf("err_missing_shards", map[string]interface{}{
+			"Missing": strings.Join(parts, ", "),
+			"Total":   missing.Total,
+		})
 	case errors.Is(err, internal.ErrImageNotSupported):
 		msg = i18n.T("err_image_not_supported")
+	case errors.Is(err, internal.ErrAudioNotSupported):
+		msg = i18n.T("err_audio_not_supported")
 	case errors.Is(err, internal.ErrImageTooSmall):
 		msg = i18n.T("err_image_too_small")
 	case errors.Is(err, internal.ErrDataNotFound):
@@ -32,6 +46,36 @@ func ShowLocalizedError(err error, parent fyne.Window) {
 		msg = i18n.T("err_rate_limited")
 	case errors.Is(err, internal.ErrExtensionTooLong):
 		msg = i18n.T("err_extension_too_long")
+	case errors.Is(err, internal.ErrUnsupportedFormat):
+		msg = i18n.T("err_unsupported_format")
+	case errors.Is(err, internal.ErrInvalidLayout):
+		msg = i18n.T("err_invalid_layout")
+	case errors.Is(err, internal.ErrInvalidRecipient):
+		msg = i18n.T("err_invalid_recipient")
+	case errors.Is(err, internal.ErrInvalidIdentity):
+		msg = i18n.T("err_invalid_identity")
+	case errors.Is(err, internal.ErrIdentityRequired):
+		msg = i18n.T("err_identity_required")
+	case errors.Is(err, internal.ErrInvalidSigner):
+		msg = i18n.T("err_invalid_signer")
+	case errors.Is(err, internal.ErrInvalidSigningKey):
+		msg = i18n.T("err_invalid_signing_key")
+	case errors.Is(err, internal.ErrShardMismatch):
+		msg = i18n.T("err_shard_mismatch")
+	case errors.Is(err, internal.ErrPayloadDamaged):
+		msg = i18n.T("err_payload_damaged")
+	case errors.Is(err, internal.ErrInvalidEntryName):
+		msg = i18n.T("err_invalid_entry_name")
+	case errors.Is(err, internal.ErrSamePassword):
+		msg = i18n.T("err_same_password")
+	case errors.Is(err, internal.ErrHiddenTooLarge):
+		msg = i18n.T("err_hidden_too_large")
+	case errors.Is(err, internal.ErrRegionNotSupported):
+		msg = i18n.T("err_region_not_supported")
+	case errors.Is(err, internal.ErrUnsupportedCarrier):
+		msg = i18n.T("err_unsupported_carrier")
+	case errors.Is(err, internal.ErrSeveralFiles):
+		msg = i18n.T("err_several_files")
 	case errors.Is(err, internal.ErrInternal):
 		msg = i18n.T("err_internal")
 	case errors.Is(err, internal.ErrNoCarrier):
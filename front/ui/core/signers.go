@@ -0,0 +1,29 @@
+package core
+
+import (
+	"fyne.io/fyne/v2"
+	"github.com/aomori446/zuon/internal"
+)
+
+// TrustedSigners returns the signer keys the user chose to trust, skipping
+// any entry that no longer parses.
+func TrustedSigners() []*internal.Signer {
+	var out []*internal.Signer
+	for _, s := range fyne.CurrentApp().Preferences().StringList("trusted_signers") {
+		if signer, err := internal.ParseSigner(s); err == nil {
+			out = append(out, signer)
+		}
+	}
+	return out
+}
+
+func TrustSigner(signer *internal.Signer) {
+	prefs := fyne.CurrentApp().Preferences()
+	list := prefs.StringList("trusted_signers")
+	for _, s := range list {
+		if s == signer.String() {
+			return
+		}
+	}
+	prefs.SetStringList("trusted_signers", append(list, signer.String()))
+}
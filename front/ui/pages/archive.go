@@ -0,0 +1,162 @@
+package pages
+
+import (
+	"io"
+	"os"
+	
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/aomori446/zuon/front/i18n"
+	"github.com/aomori446/zuon/front/ui/core"
+	"github.com/aomori446/zuon/front/ui/widgets"
+	"github.com/aomori446/zuon/internal"
+)
+
+// filePicker selects the files to hide. Extra files and a note turn the
+// payload into an archive that keeps every file under its own name.
+type filePicker struct {
+	fileBtn *widgets.CarryButton
+	extra   []fyne.URI
+	
+	label    *widget.Label
+	clearBtn *widget.Button
+	note     *widget.Entry
+	
+	// Box holds all of the picker.
+	Box *fyne.Container
+}
+
+func newFilePicker(parent fyne.Window, onChanged func()) *filePicker {
+	f := &filePicker{label: widget.NewLabel("")}
+	f.label.Alignment = fyne.TextAlignCenter
+	f.label.Hide()
+	
+	f.fileBtn = widgets.NewCarryButton(i18n.T("btn_select_file"), theme.FolderOpenIcon())
+	f.fileBtn.OnTapped = func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			defer reader.Close()
+			
+			if uri := reader.URI(); uri != nil {
+				if parentURI, err := storage.Parent(uri); err == nil {
+					fyne.CurrentApp().Preferences().SetString("last_opened_dir", parentURI.String())
+				}
+			}
+			
+			f.fileBtn.Carry = reader.URI()
+			f.fileBtn.SetText(reader.URI().Name())
+			f.fileBtn.SetIcon(theme.ConfirmIcon())
+			onChanged()
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_hidden_file"))
+		
+		if lastDir := fyne.CurrentApp().Preferences().String("last_opened_dir"); lastDir != "" {
+			if listURI, err := storage.ParseURI(lastDir); err == nil {
+				if listable, err := storage.ListerForURI(listURI); err == nil {
+					d.SetLocation(listable)
+				}
+			}
+		}
+		d.Show()
+	}
+	
+	addBtn := widget.NewButtonWithIcon(i18n.T("btn_add_file"), theme.ContentAddIcon(), func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			reader.Close()
+			
+			f.extra = append(f.extra, reader.URI())
+			f.label.SetText(i18n.Tf("label_extra_files", map[string]interface{}{"Count": len(f.extra) + 1}))
+			f.label.Show()
+			f.clearBtn.Show()
+			onChanged()
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_hidden_file"))
+		d.Show()
+	})
+	
+	f.clearBtn = widget.NewButtonWithIcon(i18n.T("btn_clear_files"), theme.ContentClearIcon(), func() {
+		f.extra = nil
+		f.label.Hide()
+		f.clearBtn.Hide()
+		onChanged()
+	})
+	f.clearBtn.Hide()
+	
+	f.note = widget.NewMultiLineEntry()
+	f.note.SetPlaceHolder(i18n.T("placeholder_note"))
+	f.note.SetMinRowsVisible(2)
+	
+	f.Box = container.NewVBox(f.fileBtn, container.NewGridWithColumns(2, addBtn, f.clearBtn), f.label, f.note)
+	return f
+}
+
+// Files returns every selected file, the main one first, or nothing before
+// the main one is picked.
+func (f *filePicker) Files() []fyne.URI {
+	uri, ok := f.fileBtn.Carry.(fyne.URI)
+	if !ok {
+		return nil
+	}
+	return append([]fyne.URI{uri}, f.extra...)
+}
+
+func (f *filePicker) Note() string {
+	return f.note.Text
+}
+
+// IsArchive reports whether the payload holds more than the main file.
+func (f *filePicker) IsArchive() bool {
+	return len(f.extra) > 0 || f.note.Text != ""
+}
+
+// readArchive is internal.ReadArchive for the files picked in the embed tab.
+func readArchive(files []fyne.URI, note string) ([]byte, error) {
+	names := make([]string, len(files))
+	for i, uri := range files {
+		names[i] = uri.Path()
+	}
+	return internal.ReadArchive(names, note)
+}
+
+// embedFile streams the file at name, with its name and metadata, into
+// carrier without loading it whole.
+func embedFile(carrier internal.Carrier, name, password string, opts internal.Options) (internal.Carrier, error) {
+	info, err := internal.StatFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	
+	w, err := internal.NewFileWriter(carrier, info, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Carrier(), nil
+}
@@ -0,0 +1,93 @@
+package pages
+
+import (
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/aomori446/zuon/front/i18n"
+	"github.com/aomori446/zuon/internal"
+)
+
+// hiddenCard hides a second payload behind the main one, which becomes its
+// decoy, see internal.EmbedDeniable. Without one it writes noise there, so
+// that carriers with and without a hidden payload look the same.
+type hiddenCard struct {
+	noise    *widget.Check
+	check    *widget.Check
+	entry    *widget.Entry
+	password *widget.Entry
+	
+	Card *widget.Card
+}
+
+// newHiddenCard calls onToggled when the main payload becomes a decoy or stops
+// being one, and onChanged as the hidden text is typed. The noise is on from
+// the start, so the caller has to treat the main payload as a decoy.
+func newHiddenCard(onToggled func(on bool), onChanged func()) *hiddenCard {
+	h := &hiddenCard{}
+	
+	h.entry = widget.NewMultiLineEntry()
+	h.entry.SetPlaceHolder(i18n.T("placeholder_hidden_text"))
+	h.entry.SetMinRowsVisible(3)
+	h.entry.OnChanged = func(string) {
+		onChanged()
+	}
+	h.entry.Hide()
+	
+	h.password = widget.NewEntry()
+	h.password.SetPlaceHolder(i18n.T("placeholder_hidden_password"))
+	h.password.Password = true
+	h.password.Validator = internal.ValidatePassword
+	h.password.Hide()
+	
+	h.check = widget.NewCheck(i18n.T("check_hidden_payload"), func(on bool) {
+		if on {
+			h.entry.Show()
+			h.password.Show()
+		} else {
+			h.entry.Hide()
+			h.password.Hide()
+		}
+		onToggled(h.Decoy())
+	})
+	
+	h.noise = widget.NewCheck(i18n.T("check_hidden_noise"), nil)
+	h.noise.SetChecked(true)
+	h.noise.OnChanged = func(bool) {
+		onToggled(h.Decoy())
+	}
+	
+	h.Card = widget.NewCard(i18n.T("card_hidden_title"), i18n.T("card_hidden_subtitle"),
+		container.NewVBox(h.noise, h.check, h.entry, h.password),
+	)
+	return h
+}
+
+// Decoy reports whether the main payload is embedded as a decoy, with either
+// the hidden payload or noise behind it.
+func (h *hiddenCard) Decoy() bool {
+	return h.check.Checked || h.noise.Checked
+}
+
+func (h *hiddenCard) Checked() bool {
+	return h.check.Checked
+}
+
+// Size is what the hidden payload takes of the capacity besides the decoy.
+func (h *hiddenCard) Size(opts internal.Options) int {
+	return internal.HiddenSize([]byte(h.entry.Text), "", opts)
+}
+
+// Payload returns the hidden payload, or the error to show when its text or
+// password is missing. It has no password when noise takes its place.
+func (h *hiddenCard) Payload() (internal.Payload, error) {
+	if !h.check.Checked {
+		return internal.Payload{}, nil
+	}
+	if h.entry.Text == "" {
+		return internal.Payload{}, internal.ErrNoText
+	}
+	if h.password.Validate() != nil {
+		return internal.Payload{}, internal.ErrPasswordShort
+	}
+	return internal.Payload{Data: []byte(h.entry.Text), Password: h.password.Text}, nil
+}
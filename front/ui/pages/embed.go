@@ -3,11 +3,8 @@ package pages
 import (
 	"fmt"
 	"image"
-	"image/png"
-	"io"
 	"net/url"
 	"os"
-	"path"
 	"time"
 	
 	"fyne.io/fyne/v2"
@@ -23,31 +20,41 @@ import (
 	"github.com/aomori446/zuon/internal"
 )
 
+// Files larger than streamThreshold are encrypted chunk by chunk while they
+// are read instead of being loaded whole, when a single carrier without error
+// correction allows it.
+const streamThreshold = 16 << 20
+
 func NewEmbedTab(parent fyne.Window) *container.TabItem {
 	
 	var btnImage *widgets.CarryButton
 	var labelCapacity *widget.Label
 	var cardImage *widget.Card
+	var showFileSize func()
+	
+	// The radio groups call these while they are set up, before they can do
+	// anything.
+	showCapacity := func() {}
+	showRemaining := func() {}
+	
+	var region *regionPicker
 	
 	cardImage, btnImage, labelCapacity = widgets.NewFileSelector(
 		parent,
 		i18n.T("embed_carrier_title"),
 		i18n.T("embed_carrier_subtitle"),
 		i18n.T("dialog_select_carrier"),
-		[]string{".png", ".jpg", ".jpeg"},
+		internal.CarrierExtensions,
 		func(reader fyne.URIReadCloser) {
-			img, _, err := image.Decode(reader)
+			carrier, err := internal.DecodeCarrier(reader)
 			if err != nil {
 				core.ShowLocalizedError(err, parent)
 				return
 			}
 			
-			btnImage.Carry = img
-			
-			capacity := core.FormatBytes(internal.Capacity(img))
-			labelCapacity.SetText(i18n.Tf("label_capacity", map[string]interface{}{"Capacity": capacity}))
-			labelCapacity.TextStyle = fyne.TextStyle{Bold: true}
-			labelCapacity.Show()
+			btnImage.Carry = carrier
+			region.Clear()
+			showCapacity()
 		},
 	)
 	
@@ -56,16 +63,25 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 			btnImage.Carry = img
 			btnImage.SetText(name)
 			btnImage.SetIcon(theme.ConfirmIcon())
-			
-			capacity := core.FormatBytes(internal.Capacity(img))
-			labelCapacity.SetText(i18n.Tf("label_capacity", map[string]interface{}{"Capacity": capacity}))
-			labelCapacity.TextStyle = fyne.TextStyle{Bold: true}
-			labelCapacity.Show()
+			region.Clear()
+			showCapacity()
 		})
 	})
 	
+	shards := newShardPicker(parent, func() {
+		showCapacity()
+	})
+	
+	region = newRegionPicker(parent, func() internal.Carrier {
+		return btnImage.Carry
+	}, func() {
+		showCapacity()
+	})
+	
 	cardImage.Content = container.NewVBox(
 		container.NewGridWithColumns(2, btnImage, unsplashBtn),
+		shards.Box,
+		region.Button,
 		labelCapacity,
 	)
 	
@@ -78,78 +94,264 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 	fileSizeLabel.Alignment = fyne.TextAlignCenter
 	fileSizeLabel.TextStyle = fyne.TextStyle{Italic: true}
 	
-	fileBtn := widgets.NewCarryButton(i18n.T("btn_select_file"), theme.FolderOpenIcon())
-	fileBtn.OnTapped = func() {
-		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
-			if reader == nil {
-				return
-			}
-			if err != nil {
-				core.ShowLocalizedError(err, parent)
-				return
-			}
-			defer reader.Close()
-			
-			if uri := reader.URI(); uri != nil {
-				if parentURI, err := storage.Parent(uri); err == nil {
-					fyne.CurrentApp().Preferences().SetString("last_opened_dir", parentURI.String())
-				}
-			}
-			
-			var sizeStr string
-			if uri := reader.URI(); uri != nil && uri.Scheme() == "file" {
-				if info, err := os.Stat(uri.Path()); err == nil {
-					sizeStr = core.FormatBytes(int(info.Size()))
-				}
-			}
-			
-			if sizeStr != "" {
-				fileSizeLabel.SetText(i18n.Tf("label_file_size", map[string]interface{}{"Size": sizeStr}))
-				fileSizeLabel.Show()
-			} else {
-				fileSizeLabel.Hide()
-			}
-			
-			fileBtn.Carry = reader.URI()
-			fileBtn.SetText(reader.URI().Name())
-			fileBtn.SetIcon(theme.ConfirmIcon())
-		}, parent)
-		d.SetTitleText(i18n.T("dialog_select_hidden_file"))
-		
-		if lastDir := fyne.CurrentApp().Preferences().String("last_opened_dir"); lastDir != "" {
-			if listURI, err := storage.ParseURI(lastDir); err == nil {
-				if listable, err := storage.ListerForURI(listURI); err == nil {
-					d.SetLocation(listable)
-				}
-			}
-		}
-		d.Show()
-	}
-	fileBtn.Hide()
+	// labelRemaining shows what is left of the capacity after the payload, as
+	// it is typed or picked.
+	labelRemaining := widget.NewLabel("")
+	labelRemaining.Hide()
+	labelRemaining.Alignment = fyne.TextAlignCenter
+	
+	// filePacked is what the selected files take of the capacity, -1 until
+	// showFileSize knows.
+	filePacked := -1
+	
+	files := newFilePicker(parent, func() {
+		showFileSize()
+	})
+	files.Box.Hide()
 	textEntry.Hide()
 	
 	radioGroup := widget.NewRadioGroup([]string{i18n.T("radio_text"), i18n.T("radio_file")}, func(s string) {
 		if s == i18n.T("radio_text") {
 			textEntry.Show()
-			fileBtn.Hide()
+			files.Box.Hide()
 			fileSizeLabel.Hide()
 		} else {
 			textEntry.Hide()
-			fileBtn.Show()
-			if fileBtn.Carry != nil {
+			files.Box.Show()
+			if files.Files() != nil {
 				fileSizeLabel.Show()
 			}
 		}
+		showRemaining()
 	})
 	radioGroup.Horizontal = true
 	radioGroup.SetSelected(i18n.T("radio_text"))
 	
 	cardData := widget.NewCard(i18n.T("card_data_title"), i18n.T("card_data_subtitle"),
-		container.NewVBox(radioGroup, textEntry, fileBtn, fileSizeLabel),
+		container.NewVBox(radioGroup, textEntry, files.Box, fileSizeLabel, labelRemaining),
 	)
 	
 	cardPassword, entryPassword := widgets.NewPasswordCard()
 	
+	recipientsEntry := widget.NewMultiLineEntry()
+	recipientsEntry.SetPlaceHolder(i18n.T("placeholder_recipients"))
+	recipientsEntry.SetMinRowsVisible(3)
+	recipientsEntry.OnChanged = func(string) {
+		showCapacity()
+	}
+	
+	generateBtn := widget.NewButtonWithIcon(i18n.T("btn_generate_key"), theme.ContentAddIcon(), func() {
+		identity, err := internal.GenerateIdentity()
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		widgets.SaveKeyFile(parent, identity.Recipient(), identity, func() {
+			text := recipientsEntry.Text
+			if text != "" && text[len(text)-1] != '\n' {
+				text += "\n"
+			}
+			recipientsEntry.SetText(text + identity.Recipient().String())
+		})
+	})
+	
+	recipientsEntry.Hide()
+	generateBtn.Hide()
+	
+	radioKeyMode := widget.NewRadioGroup([]string{i18n.T("radio_password"), i18n.T("radio_recipients")}, func(s string) {
+		if s == i18n.T("radio_recipients") {
+			entryPassword.Hide()
+			recipientsEntry.Show()
+			generateBtn.Show()
+		} else {
+			entryPassword.Show()
+			recipientsEntry.Hide()
+			generateBtn.Hide()
+		}
+		showCapacity()
+	})
+	radioKeyMode.Horizontal = true
+	radioKeyMode.SetSelected(i18n.T("radio_password"))
+	
+	cardPassword.SetContent(container.NewVBox(radioKeyMode, entryPassword, recipientsEntry, generateBtn))
+	
+	options := newOptionsCard(parent, func() {
+		showCapacity()
+		showFileSize()
+	})
+	
+	embedOptions := func() internal.Options {
+		opts := options.Options()
+		opts.Region = region.Region()
+		return opts
+	}
+	
+	// carriers returns every selected carrier, the main one first.
+	carriers := func() []internal.Carrier {
+		if btnImage.Carry == nil {
+			return nil
+		}
+		return append([]internal.Carrier{btnImage.Carry}, shards.carriers...)
+	}
+	
+	// A hidden payload or noise turns the main one into a decoy. It needs the
+	// decoy in pixel order without adaptive coding, under a password, in a
+	// single carrier.
+	setDecoy := func(on bool) {
+		if on {
+			radioKeyMode.SetSelected(i18n.T("radio_password"))
+			radioKeyMode.Disable()
+		} else {
+			radioKeyMode.Enable()
+		}
+		options.SetDecoy(on)
+		shards.SetEnabled(!on)
+	}
+	hidden := newHiddenCard(func(on bool) {
+		setDecoy(on)
+		showCapacity()
+		showFileSize()
+		showRemaining()
+	}, func() {
+		showRemaining()
+	})
+	setDecoy(hidden.Decoy())
+	
+	// capacityOptions are the embedding options with the recipients, which
+	// take more room than a password.
+	capacityOptions := func() internal.Options {
+		opts := embedOptions()
+		if radioKeyMode.Selected == i18n.T("radio_recipients") {
+			if recipients, err := internal.ParseRecipients(recipientsEntry.Text); err == nil {
+				opts.Recipients = recipients
+			}
+		}
+		return opts
+	}
+	
+	carrierCapacity := func(opts internal.Options) internal.CapacityReport {
+		imgs := carriers()
+		if len(imgs) > 1 {
+			return internal.ShardCapacityFor(imgs, nil, opts)
+		}
+		return internal.CapacityFor(imgs[0], nil, opts)
+	}
+	
+	// capacity is kept from the last showCapacity, so typing does not measure
+	// the carriers again.
+	var capacity internal.CapacityReport
+	
+	streamable := func(size int64, opts internal.Options) bool {
+		return size > streamThreshold && len(carriers()) == 1 && opts.Redundancy == internal.RedundancyNone && opts.Coding == internal.CodingPlain && !files.IsArchive() && !hidden.Decoy()
+	}
+	
+	showCapacity = func() {
+		if btnImage.Carry == nil {
+			return
+		}
+		
+		capacity = carrierCapacity(capacityOptions())
+		label := "label_capacity"
+		if capacity.Estimated {
+			// The scattered header takes pixels from the region that only the
+			// password decides.
+			label = "label_capacity_at_least"
+		}
+		labelCapacity.SetText(i18n.Tf(label, map[string]interface{}{"Capacity": core.FormatBytes(capacity.Payload)}) + "\n" +
+			i18n.Tf("label_capacity_overhead", map[string]interface{}{
+				"Overhead": core.FormatBytes(capacity.Total - capacity.Payload),
+				"Total":    core.FormatBytes(capacity.Total),
+			}))
+		labelCapacity.TextStyle = fyne.TextStyle{Bold: true}
+		labelCapacity.Show()
+		showRemaining()
+	}
+	
+	showRemaining = func() {
+		packed := filePacked
+		if radioGroup.Selected == i18n.T("radio_text") {
+			packed = -1
+			if textEntry.Text != "" {
+				packed = internal.PayloadSize([]byte(textEntry.Text), "", embedOptions())
+			}
+		}
+		if btnImage.Carry == nil || packed < 0 {
+			labelRemaining.Hide()
+			return
+		}
+		
+		free := capacity.Free(packed)
+		if hidden.Checked() {
+			free -= hidden.Size(embedOptions())
+		}
+		if free < 0 {
+			labelRemaining.SetText(i18n.Tf("label_over_capacity", map[string]interface{}{"Over": core.FormatBytes(-free)}))
+		} else {
+			labelRemaining.SetText(i18n.Tf("label_remaining", map[string]interface{}{"Free": core.FormatBytes(free)}))
+		}
+		labelRemaining.Show()
+	}
+	
+	textEntry.OnChanged = func(string) {
+		showRemaining()
+	}
+	
+	// fileSizeGen counts the calls to showFileSize, so that sizes measured
+	// for an older selection are dropped.
+	fileSizeGen := 0
+	
+	showFileSize = func() {
+		filePacked = -1
+		fileSizeGen++
+		uris := files.Files()
+		if uris == nil {
+			return
+		}
+		
+		gen := fileSizeGen
+		note, opts := files.Note(), embedOptions()
+		go func() {
+			infos := make([]internal.FileInfo, 0, len(uris))
+			var err error
+			for _, uri := range uris {
+				var info internal.FileInfo
+				if info, err = internal.StatFile(uri.Path()); err != nil {
+					break
+				}
+				infos = append(infos, info)
+			}
+			
+			fyne.Do(func() {
+				if gen != fileSizeGen {
+					return
+				}
+				if err != nil {
+					fileSizeLabel.Hide()
+					showRemaining()
+					return
+				}
+				
+				// Compression is only known once the files are read, and can
+				// only make them take less.
+				var size int64
+				for _, info := range infos {
+					size += info.Size
+				}
+				packed := internal.UncompressedPayloadSize(internal.ArchiveSize(infos, note), internal.ArchiveExtension, opts)
+				if len(infos) == 1 && streamable(size, opts) {
+					packed = int(internal.StreamFileSize(infos[0], opts))
+				}
+				
+				fileSizeLabel.SetText(i18n.Tf("label_file_size", map[string]interface{}{"Size": core.FormatBytes(int(size))}))
+				if radioGroup.Selected == i18n.T("radio_file") {
+					fileSizeLabel.Show()
+				}
+				filePacked = packed
+				showRemaining()
+			})
+		}()
+	}
+	
 	progressBar := widget.NewProgressBarInfinite()
 	progressBar.Hide()
 	
@@ -165,6 +367,7 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 		
 		var data []byte
 		var ext string
+		var streamPath string
 		
 		if radioGroup.Selected == i18n.T("radio_text") {
 			text := textEntry.Text
@@ -175,39 +378,81 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 			data = []byte(text)
 			ext = ""
 		} else {
-			if fileBtn.Carry == nil {
+			uris := files.Files()
+			if uris == nil {
 				core.ShowLocalizedError(internal.ErrNoFile, parent)
 				return
 			}
-			uri := fileBtn.Carry.(fyne.URI)
-			f, err := os.Open(uri.Path())
+			
+			if info, err := os.Stat(uris[0].Path()); err == nil && streamable(info.Size(), embedOptions()) {
+				streamPath = uris[0].Path()
+			} else {
+				if data, err = readArchive(uris, files.Note()); err != nil {
+					core.ShowLocalizedError(err, parent)
+					return
+				}
+				ext = internal.ArchiveExtension
+			}
+		}
+		
+		opts := embedOptions()
+		
+		var password string
+		if radioKeyMode.Selected == i18n.T("radio_recipients") {
+			recipients, err := internal.ParseRecipients(recipientsEntry.Text)
 			if err != nil {
 				core.ShowLocalizedError(err, parent)
 				return
 			}
-			defer f.Close()
-			
-			data, err = io.ReadAll(f)
-			if err != nil {
-				core.ShowLocalizedError(err, parent)
+			if len(recipients) == 0 {
+				core.ShowLocalizedError(internal.ErrInvalidRecipient, parent)
 				return
 			}
-			ext = path.Ext(uri.Path())
+			opts.Recipients = recipients
+		} else {
+			if entryPassword.Validate() != nil {
+				core.ShowLocalizedError(internal.ErrPasswordShort, parent)
+				return
+			}
+			password = entryPassword.Text
 		}
 		
-		if entryPassword.Validate() != nil {
-			core.ShowLocalizedError(internal.ErrPasswordShort, parent)
-			return
-		}
-		password := entryPassword.Text
+		imgs := carriers()
 		
-		baseImage := btnImage.Carry.(image.Image)
+		// embed returns one carrier, or one per shard.
+		var embed func() ([]internal.Carrier, error)
+		switch {
+		case hidden.Decoy():
+			payload, err := hidden.Payload()
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			embed = func() ([]internal.Carrier, error) {
+				c, err := internal.EmbedDeniable(imgs[0], internal.Payload{Data: data, Extension: ext, Password: password}, payload, opts)
+				return []internal.Carrier{c}, err
+			}
+		case streamPath != "":
+			embed = func() ([]internal.Carrier, error) {
+				c, err := embedFile(imgs[0], streamPath, password, opts)
+				return []internal.Carrier{c}, err
+			}
+		case len(imgs) > 1:
+			embed = func() ([]internal.Carrier, error) {
+				return internal.EmbedShards(imgs, data, ext, password, opts)
+			}
+		default:
+			embed = func() ([]internal.Carrier, error) {
+				c, err := internal.EmbedData(imgs[0], data, ext, 0, password, opts)
+				return []internal.Carrier{c}, err
+			}
+		}
 		
 		embedButton.Disable()
 		progressBar.Show()
 		
 		go func() {
-			embedImg, err := internal.EmbedData(baseImage, data, ext, 0, password)
+			results, err := embed()
 			
 			fyne.Do(func() {
 				embedButton.Enable()
@@ -218,7 +463,11 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 					return
 				}
 				
-				saveEmbedResult(parent, embedImg)
+				if len(results) > 1 {
+					saveShardResults(parent, results)
+				} else {
+					saveEmbedResult(parent, results[0])
+				}
 			})
 		}()
 	}
@@ -231,6 +480,10 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 		layout.NewSpacer(),
 		cardPassword,
 		layout.NewSpacer(),
+		hidden.Card,
+		layout.NewSpacer(),
+		options.Card,
+		layout.NewSpacer(),
 		progressBar,
 		embedButton,
 	)
@@ -238,7 +491,31 @@ func NewEmbedTab(parent fyne.Window) *container.TabItem {
 	return container.NewTabItemWithIcon(i18n.T("tab_embed"), theme.DocumentCreateIcon(), container.NewScroll(container.NewPadded(contentVBox)))
 }
 
-func saveEmbedResult(parent fyne.Window, img image.Image) {
+// images returns the image carriers among results, which are the ones that
+// can be analyzed.
+func images(results ...internal.Carrier) []image.Image {
+	var imgs []image.Image
+	for _, c := range results {
+		if img, ok := c.(image.Image); ok {
+			imgs = append(imgs, img)
+		}
+	}
+	return imgs
+}
+
+// analyzeButton offers the steganalysis of the image carriers among results,
+// or nothing when there are none.
+func analyzeButton(parent fyne.Window, results ...internal.Carrier) fyne.CanvasObject {
+	imgs := images(results...)
+	if len(imgs) == 0 {
+		return layout.NewSpacer()
+	}
+	return widget.NewButtonWithIcon(i18n.T("btn_analyze"), theme.SearchIcon(), func() {
+		widgets.ShowAnalysis(parent, imgs...)
+	})
+}
+
+func saveEmbedResult(parent fyne.Window, result internal.Carrier) {
 	
 	fsDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
 		if writer == nil {
@@ -250,7 +527,7 @@ func saveEmbedResult(parent fyne.Window, img image.Image) {
 		}
 		defer writer.Close()
 		
-		err = png.Encode(writer, img)
+		err = internal.EncodeCarrier(writer, result)
 		if err != nil {
 			core.ShowLocalizedError(err, parent)
 			return
@@ -266,12 +543,14 @@ func saveEmbedResult(parent fyne.Window, img image.Image) {
 			container.NewVBox(
 				widget.NewLabel(i18n.T("dialog_file_saved_to")),
 				hyperlink,
+				analyzeButton(parent, result),
 			), parent).Show()
 		
 	}, parent)
 	
 	fsDialog.SetTitleText(i18n.T("dialog_save_embed_title"))
-	fsDialog.SetFileName(fmt.Sprintf("%d_zuon.png", time.Now().Unix()))
-	fsDialog.SetFilter(storage.NewExtensionFileFilter([]string{".png"}))
+	ext := internal.CarrierExtension(result)
+	fsDialog.SetFileName(fmt.Sprintf("%d_zuon%s", time.Now().Unix(), ext))
+	fsDialog.SetFilter(storage.NewExtensionFileFilter([]string{ext}))
 	fsDialog.Show()
 }
@@ -1,12 +1,14 @@
 package pages
 
 import (
-	"image/png"
+	"image"
 	"os"
 	
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/aomori446/zuon/front/i18n"
@@ -24,14 +26,105 @@ func NewExtractTab(parent fyne.Window) *container.TabItem {
 		i18n.T("extract_source_title"),
 		i18n.T("extract_source_subtitle"),
 		i18n.T("dialog_select_extract_source"),
-		[]string{".png"},
+		internal.CarrierExtensions,
 		func(reader fyne.URIReadCloser) {
 			btnImage.Carry = reader.URI()
 		},
 	)
 	
+	// Further images of a multi-image payload, in any order.
+	var extraSources []fyne.URI
+	
+	labelSources := widget.NewLabel("")
+	labelSources.Alignment = fyne.TextAlignCenter
+	labelSources.Hide()
+	
+	var clearSourcesBtn *widget.Button
+	addSourceBtn := widget.NewButtonWithIcon(i18n.T("btn_add_shard"), theme.ContentAddIcon(), func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			reader.Close()
+			
+			extraSources = append(extraSources, reader.URI())
+			labelSources.SetText(i18n.Tf("label_extra_carriers", map[string]interface{}{"Count": len(extraSources) + 1}))
+			labelSources.Show()
+			clearSourcesBtn.Show()
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_extract_source"))
+		d.SetFilter(storage.NewExtensionFileFilter(internal.CarrierExtensions))
+		d.Show()
+	})
+	
+	clearSourcesBtn = widget.NewButtonWithIcon(i18n.T("btn_clear_carriers"), theme.ContentClearIcon(), func() {
+		extraSources = nil
+		labelSources.Hide()
+		clearSourcesBtn.Hide()
+	})
+	clearSourcesBtn.Hide()
+	
+	// Any image can be checked for hidden data, not only ones zuon made.
+	// Audio cannot.
+	analyzeBtn := widget.NewButtonWithIcon(i18n.T("btn_analyze"), theme.SearchIcon(), func() {
+		if btnImage.Carry == nil {
+			core.ShowLocalizedError(internal.ErrNoSource, parent)
+			return
+		}
+		carrier, err := decodeCarrier(btnImage.Carry.(fyne.URI))
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		img, ok := carrier.(image.Image)
+		if !ok {
+			core.ShowLocalizedError(internal.ErrImageNotSupported, parent)
+			return
+		}
+		widgets.ShowAnalysis(parent, img)
+	})
+	
+	cardImage.Content = container.NewVBox(
+		cardImage.Content,
+		container.NewGridWithColumns(2, addSourceBtn, clearSourcesBtn),
+		labelSources,
+		analyzeBtn,
+	)
+	
 	cardPassword, entryPassword := widgets.NewPasswordCard()
 	
+	keyBtn := widgets.NewCarryButton(i18n.T("btn_select_key_file"), theme.LoginIcon())
+	keyBtn.OnTapped = func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			defer reader.Close()
+			
+			identities, err := internal.ParseIdentities(reader)
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			
+			keyBtn.Carry = identities
+			keyBtn.SetText(reader.URI().Name())
+			keyBtn.SetIcon(theme.ConfirmIcon())
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_key_file"))
+		d.Show()
+	}
+	
+	cardPassword.SetContent(container.NewVBox(entryPassword, keyBtn))
+	
 	progressBar := widget.NewProgressBarInfinite()
 	progressBar.Hide()
 	
@@ -44,41 +137,48 @@ func NewExtractTab(parent fyne.Window) *container.TabItem {
 			return
 		}
 		
-		if entryPassword.Validate() != nil {
+		opts := internal.Options{
+			Traversal:      internal.TraversalScattered,
+			TrustedSigners: core.TrustedSigners(),
+		}
+		if identities, ok := keyBtn.Carry.([]*internal.Identity); ok {
+			opts.Identities = identities
+		} else if entryPassword.Validate() != nil {
 			core.ShowLocalizedError(internal.ErrPasswordShort, parent)
 			return
 		}
 		
-		uri := btnImage.Carry.(fyne.URI)
+		uris := append([]fyne.URI{btnImage.Carry.(fyne.URI)}, extraSources...)
 		password := entryPassword.Text
 		
 		extractButton.Disable()
 		progressBar.Show()
 		
 		go func() {
-			f, err := os.Open(uri.Path())
-			if err != nil {
-				fyne.Do(func() {
-					extractButton.Enable()
-					progressBar.Hide()
-					core.ShowLocalizedError(err, parent)
-				})
-				return
+			imgs := make([]internal.Carrier, 0, len(uris))
+			for _, uri := range uris {
+				img, err := decodeCarrier(uri)
+				if err != nil {
+					fyne.Do(func() {
+						extractButton.Enable()
+						progressBar.Hide()
+						core.ShowLocalizedError(err, parent)
+					})
+					return
+				}
+				imgs = append(imgs, img)
 			}
-			defer f.Close()
 			
-			img, err := png.Decode(f)
-			if err != nil {
-				fyne.Do(func() {
-					extractButton.Enable()
-					progressBar.Hide()
-					core.ShowLocalizedError(err, parent)
-				})
-				return
+			var data []byte
+			var ext string
+			var verification internal.Verification
+			var err error
+			if len(imgs) > 1 {
+				data, ext, verification, err = internal.ExtractShards(imgs, password, opts)
+			} else {
+				data, ext, verification, err = internal.ExtractData(imgs[0], 0, password, opts)
 			}
 			
-			data, ext, err := internal.ExtractData(img, 0, password)
-			
 			fyne.Do(func() {
 				extractButton.Enable()
 				progressBar.Hide()
@@ -88,7 +188,7 @@ func NewExtractTab(parent fyne.Window) *container.TabItem {
 					return
 				}
 				
-				widgets.ShowResultDialog(parent, data, ext)
+				widgets.ShowResultDialog(parent, data, ext, verification)
 			})
 		}()
 	}
@@ -105,3 +205,13 @@ func NewExtractTab(parent fyne.Window) *container.TabItem {
 	
 	return container.NewTabItemWithIcon(i18n.T("tab_extract"), theme.VisibilityIcon(), container.NewScroll(container.NewPadded(contentVBox)))
 }
+
+func decodeCarrier(uri fyne.URI) (internal.Carrier, error) {
+	f, err := os.Open(uri.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	
+	return internal.DecodeCarrier(f)
+}
@@ -0,0 +1,240 @@
+package pages
+
+import (
+	"slices"
+	"strconv"
+	
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/aomori446/zuon/front/i18n"
+	"github.com/aomori446/zuon/front/ui/core"
+	"github.com/aomori446/zuon/front/ui/widgets"
+	"github.com/aomori446/zuon/internal"
+)
+
+var redundancyLevels = []internal.Redundancy{internal.RedundancyNone, internal.RedundancyLow, internal.RedundancyMedium, internal.RedundancyHigh}
+
+// Argon2id choices, every combination of which is a valid KDF.
+var (
+	kdfTimes   = []uint32{1, 2, 3, 4, 6, 8, 10}
+	kdfMemory  = []uint32{16 << 10, 32 << 10, 64 << 10, 128 << 10, 256 << 10, 512 << 10, 1 << 20}
+	kdfThreads = []uint8{1, 2, 4, 8, 16}
+)
+
+// optionsCard picks how the payload is written into the carriers.
+type optionsCard struct {
+	checkScatter  *widget.Check
+	selectBits    *widget.Select
+	checkAlpha    *widget.Check
+	selectECC     *widget.Select
+	selectMatrix  *widget.Select
+	checkCompress *widget.Check
+	checkMatching *widget.Check
+	checkAdaptive *widget.Check
+	signBtn       *widgets.CarryButton
+	selectTime    *widget.Select
+	selectMemory  *widget.Select
+	selectThreads *widget.Select
+	
+	Card *widget.Card
+}
+
+// newOptionsCard calls onChanged whenever an option that changes what fits
+// is changed.
+func newOptionsCard(parent fyne.Window, onChanged func()) *optionsCard {
+	o := &optionsCard{}
+	
+	o.checkScatter = widget.NewCheck(i18n.T("check_scatter"), nil)
+	o.checkScatter.SetChecked(true)
+	
+	bitOptions := []string{"1", "2", "3", "4"}
+	o.selectBits = widget.NewSelect(bitOptions, nil)
+	o.selectBits.SetSelected("2")
+	o.selectBits.OnChanged = func(string) {
+		onChanged()
+	}
+	
+	o.checkAlpha = widget.NewCheck(i18n.T("check_alpha_channel"), func(bool) {
+		onChanged()
+	})
+	
+	o.signBtn = widgets.NewCarryButton(i18n.T("btn_select_signing_key"), theme.DocumentIcon())
+	o.signBtn.OnTapped = func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			defer reader.Close()
+			
+			key, err := internal.ParseSigningKeyFile(reader)
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			
+			o.signBtn.Carry = key
+			o.signBtn.SetText(reader.URI().Name())
+			o.signBtn.SetIcon(theme.ConfirmIcon())
+			onChanged()
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_signing_key"))
+		d.Show()
+	}
+	
+	generateSignBtn := widget.NewButtonWithIcon(i18n.T("btn_generate_signing_key"), theme.ContentAddIcon(), func() {
+		key, err := internal.GenerateSigningKey()
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		widgets.SaveKeyFile(parent, key.Signer(), key, func() {
+			o.signBtn.Carry = key
+			o.signBtn.SetText(key.Signer().String())
+			o.signBtn.SetIcon(theme.ConfirmIcon())
+			onChanged()
+		})
+	})
+	
+	o.selectECC = widget.NewSelect([]string{
+		i18n.T("option_ecc_off"),
+		i18n.T("option_ecc_low"),
+		i18n.T("option_ecc_medium"),
+		i18n.T("option_ecc_high"),
+	}, nil)
+	o.selectECC.SetSelectedIndex(0)
+	o.selectECC.OnChanged = func(string) {
+		onChanged()
+	}
+	
+	// Matrix coding hides k bits in 2^k-1 of the selected ones.
+	matrixOptions := []string{i18n.T("option_matrix_off")}
+	for k := 2; k <= 7; k++ {
+		matrixOptions = append(matrixOptions, i18n.Tf("option_matrix_k", map[string]interface{}{"K": k, "N": 1<<k - 1}))
+	}
+	o.selectMatrix = widget.NewSelect(matrixOptions, nil)
+	o.selectMatrix.SetSelectedIndex(0)
+	o.selectMatrix.OnChanged = func(string) {
+		onChanged()
+	}
+	
+	o.checkCompress = widget.NewCheck(i18n.T("check_compress"), nil)
+	o.checkCompress.SetChecked(true)
+	o.checkCompress.OnChanged = func(bool) {
+		onChanged()
+	}
+	
+	o.checkMatching = widget.NewCheck(i18n.T("check_matching"), nil)
+	
+	// Adaptive coding always uses the lowest bit.
+	o.checkAdaptive = widget.NewCheck(i18n.T("check_adaptive"), func(on bool) {
+		if on {
+			o.selectBits.Disable()
+			o.selectMatrix.SetSelectedIndex(0)
+			o.selectMatrix.Disable()
+		} else {
+			o.selectBits.Enable()
+			o.selectMatrix.Enable()
+		}
+		onChanged()
+	})
+	
+	// Key derivation only changes how long a password takes to try, not
+	// what fits.
+	var times, memory, threads []string
+	for _, t := range kdfTimes {
+		times = append(times, strconv.Itoa(int(t)))
+	}
+	for _, m := range kdfMemory {
+		memory = append(memory, i18n.Tf("option_kdf_memory", map[string]interface{}{"MiB": m >> 10}))
+	}
+	for _, t := range kdfThreads {
+		threads = append(threads, strconv.Itoa(int(t)))
+	}
+	o.selectTime = widget.NewSelect(times, nil)
+	o.selectTime.SetSelectedIndex(slices.Index(kdfTimes, internal.DefaultKDF.Time))
+	o.selectMemory = widget.NewSelect(memory, nil)
+	o.selectMemory.SetSelectedIndex(slices.Index(kdfMemory, internal.DefaultKDF.Memory))
+	o.selectThreads = widget.NewSelect(threads, nil)
+	o.selectThreads.SetSelectedIndex(slices.Index(kdfThreads, internal.DefaultKDF.Threads))
+	
+	o.Card = widget.NewCard(i18n.T("card_options_title"), i18n.T("card_options_subtitle"),
+		container.NewVBox(
+			o.checkScatter,
+			widget.NewForm(
+				widget.NewFormItem(i18n.T("label_bits_per_channel"), o.selectBits),
+				widget.NewFormItem(i18n.T("label_error_correction"), o.selectECC),
+				widget.NewFormItem(i18n.T("label_matrix_coding"), o.selectMatrix),
+				widget.NewFormItem(i18n.T("label_kdf_time"), o.selectTime),
+				widget.NewFormItem(i18n.T("label_kdf_memory"), o.selectMemory),
+				widget.NewFormItem(i18n.T("label_kdf_threads"), o.selectThreads),
+			),
+			o.checkAlpha,
+			o.checkCompress,
+			o.checkMatching,
+			o.checkAdaptive,
+			container.NewGridWithColumns(2, o.signBtn, generateSignBtn),
+		),
+	)
+	return o
+}
+
+// Options returns the options picked, for the whole image.
+func (o *optionsCard) Options() internal.Options {
+	var opts internal.Options
+	if o.checkScatter.Checked {
+		opts.Traversal = internal.TraversalScattered
+	}
+	
+	opts.Layout = internal.Layout{Bits: o.selectBits.SelectedIndex() + 1, Channels: internal.ChannelsRGB}
+	if o.checkAlpha.Checked {
+		opts.Layout.Channels = internal.ChannelsRGBA
+	}
+	
+	if !o.checkCompress.Checked {
+		opts.Compression = internal.CompressionNone
+	}
+	
+	opts.Redundancy = redundancyLevels[o.selectECC.SelectedIndex()]
+	
+	opts.Matching = o.checkMatching.Checked
+	
+	if k := o.selectMatrix.SelectedIndex(); k > 0 {
+		opts.Coding = internal.HammingCoding(k + 1)
+	}
+	if o.checkAdaptive.Checked {
+		opts.Coding = internal.CodingAdaptive
+	}
+	
+	if key, ok := o.signBtn.Carry.(*internal.SigningKey); ok {
+		opts.SigningKey = key
+	}
+	
+	opts.KDF = internal.KDF{
+		ID:      internal.KDFArgon2id,
+		Time:    kdfTimes[o.selectTime.SelectedIndex()],
+		Memory:  kdfMemory[o.selectMemory.SelectedIndex()],
+		Threads: kdfThreads[o.selectThreads.SelectedIndex()],
+	}
+	return opts
+}
+
+// SetDecoy keeps to the pixel order without adaptive coding, as a decoy
+// needs, or lets them be picked again.
+func (o *optionsCard) SetDecoy(on bool) {
+	if on {
+		o.checkScatter.SetChecked(false)
+		o.checkScatter.Disable()
+		o.checkAdaptive.SetChecked(false)
+		o.checkAdaptive.Disable()
+		return
+	}
+	o.checkScatter.Enable()
+	o.checkAdaptive.Enable()
+}
@@ -0,0 +1,77 @@
+package pages
+
+import (
+	"image"
+	
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/aomori446/zuon/front/i18n"
+	"github.com/aomori446/zuon/front/ui/core"
+	"github.com/aomori446/zuon/front/ui/widgets"
+	"github.com/aomori446/zuon/internal"
+)
+
+// regionPicker keeps the body inside a rectangle, or where a mask is light,
+// of every carrier; neither means the whole image.
+type regionPicker struct {
+	rect image.Rectangle
+	mask image.Image
+	
+	Button *widget.Button
+}
+
+// newRegionPicker shows the main carrier that carrier returns to pick a
+// region on.
+func newRegionPicker(parent fyne.Window, carrier func() internal.Carrier, onChanged func()) *regionPicker {
+	r := &regionPicker{}
+	r.Button = widget.NewButtonWithIcon(i18n.T("btn_region"), theme.ViewFullScreenIcon(), func() {
+		c := carrier()
+		if c == nil {
+			core.ShowLocalizedError(internal.ErrNoCarrier, parent)
+			return
+		}
+		
+		if p, ok := c.(*internal.Picture); ok {
+			c = p.Image
+		}
+		img, ok := c.(image.Image)
+		switch c.(type) {
+		case *internal.JPEG, *image.Paletted:
+			ok = false
+		}
+		if !ok {
+			core.ShowLocalizedError(internal.ErrRegionNotSupported, parent)
+			return
+		}
+		
+		widgets.ShowRegionDialog(parent, img, r.rect, r.mask, func(rect image.Rectangle, mask image.Image) {
+			r.rect, r.mask = rect, mask
+			if mask != nil || !rect.Empty() {
+				r.Button.SetIcon(theme.ConfirmIcon())
+			} else {
+				r.Button.SetIcon(theme.ViewFullScreenIcon())
+			}
+			onChanged()
+		})
+	})
+	return r
+}
+
+// Clear forgets the region of the previous main carrier.
+func (r *regionPicker) Clear() {
+	r.rect, r.mask = image.Rectangle{}, nil
+	r.Button.SetIcon(theme.ViewFullScreenIcon())
+}
+
+// Region is the picked region as internal.Options take it, nil for the whole
+// image.
+func (r *regionPicker) Region() *internal.Region {
+	switch {
+	case r.mask != nil:
+		return internal.MaskRegion(r.mask)
+	case !r.rect.Empty():
+		return internal.RectRegion(r.rect)
+	}
+	return nil
+}
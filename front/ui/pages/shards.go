@@ -0,0 +1,141 @@
+package pages
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+	
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/aomori446/zuon/front/i18n"
+	"github.com/aomori446/zuon/front/ui/core"
+	"github.com/aomori446/zuon/internal"
+)
+
+// shardPicker collects extra carriers, which turn on multi-image mode: the
+// payload is split across the main carrier and all of these.
+type shardPicker struct {
+	carriers []internal.Carrier
+	
+	label    *widget.Label
+	addBtn   *widget.Button
+	clearBtn *widget.Button
+	
+	// Box holds all of the picker.
+	Box *fyne.Container
+}
+
+func newShardPicker(parent fyne.Window, onChanged func()) *shardPicker {
+	s := &shardPicker{label: widget.NewLabel("")}
+	s.label.Alignment = fyne.TextAlignCenter
+	s.label.Hide()
+	
+	s.addBtn = widget.NewButtonWithIcon(i18n.T("btn_add_carrier"), theme.ContentAddIcon(), func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			defer reader.Close()
+			
+			carrier, err := internal.DecodeCarrier(reader)
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			
+			s.carriers = append(s.carriers, carrier)
+			s.label.SetText(i18n.Tf("label_extra_carriers", map[string]interface{}{"Count": len(s.carriers) + 1}))
+			s.label.Show()
+			s.clearBtn.Show()
+			onChanged()
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_carrier"))
+		d.SetFilter(storage.NewExtensionFileFilter(internal.CarrierExtensions))
+		d.Show()
+	})
+	
+	s.clearBtn = widget.NewButtonWithIcon(i18n.T("btn_clear_carriers"), theme.ContentClearIcon(), func() {
+		s.clear()
+		onChanged()
+	})
+	s.clearBtn.Hide()
+	
+	s.Box = container.NewVBox(container.NewGridWithColumns(2, s.addBtn, s.clearBtn), s.label)
+	return s
+}
+
+func (s *shardPicker) clear() {
+	s.carriers = nil
+	s.label.Hide()
+	s.clearBtn.Hide()
+}
+
+// SetEnabled allows adding carriers, or drops them and stops it.
+func (s *shardPicker) SetEnabled(on bool) {
+	if on {
+		s.addBtn.Enable()
+		return
+	}
+	s.clear()
+	s.addBtn.Disable()
+}
+
+// saveShardResults writes every carrier of a multi-carrier payload into one
+// folder, numbered so the set is easy to keep together.
+func saveShardResults(parent fyne.Window, shards []internal.Carrier) {
+	folderDialog := dialog.NewFolderOpen(func(dir fyne.ListableURI, err error) {
+		if dir == nil {
+			return
+		}
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		
+		stamp := time.Now().Unix()
+		for i, c := range shards {
+			uri, err := storage.Child(dir, fmt.Sprintf("%d_zuon_%dof%d%s", stamp, i+1, len(shards), internal.CarrierExtension(c)))
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			
+			writer, err := storage.Writer(uri)
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			
+			err = internal.EncodeCarrier(writer, c)
+			writer.Close()
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+		}
+		
+		savedTo := dir.Path()
+		hyperlink := widget.NewHyperlink(savedTo, &url.URL{
+			Scheme: "file",
+			Path:   savedTo,
+		})
+		
+		dialog.NewCustom(i18n.T("dialog_embed_success"), "OK",
+			container.NewVBox(
+				widget.NewLabel(i18n.Tf("dialog_shards_saved_to", map[string]interface{}{"Count": len(shards)})),
+				hyperlink,
+				analyzeButton(parent, shards...),
+			), parent).Show()
+	}, parent)
+	
+	folderDialog.SetTitleText(i18n.T("dialog_save_shards_title"))
+	folderDialog.Show()
+}
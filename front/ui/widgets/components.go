@@ -2,6 +2,10 @@ package widgets
 
 import (
 	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 	
 	"bytes"
@@ -16,6 +20,7 @@ import (
 	"github.com/aomori446/zuon/front/i18n"
 	"github.com/aomori446/zuon/front/ui/core"
 	"github.com/aomori446/zuon/internal"
+	"github.com/aomori446/zuon/internal/analysis"
 )
 
 type CarryButton struct {
@@ -103,7 +108,9 @@ func NewPasswordCard() (*widget.Card, *widget.Entry) {
 	return card, entry
 }
 
-func ShowResultDialog(parent fyne.Window, data []byte, ext string) {
+func ShowResultDialog(parent fyne.Window, data []byte, ext string, verification internal.Verification) {
+	signature := newSignatureBox(verification)
+	
 	if ext == "" {
 		entry := widget.NewMultiLineEntry()
 		entry.SetText(string(data))
@@ -113,42 +120,224 @@ func ShowResultDialog(parent fyne.Window, data []byte, ext string) {
 			parent.Clipboard().SetContent(string(data))
 		})
 		
-		content := container.NewBorder(nil, copyBtn, nil, nil, entry)
+		content := container.NewBorder(signature, copyBtn, nil, nil, entry)
 		custom := dialog.NewCustom(i18n.T("result_title_text"), i18n.T("btn_close"), content, parent)
 		custom.Resize(fyne.NewSize(400, 300))
 		custom.Show()
-		
-	} else if ext == ".png" || ext == ".jpeg" || ext == ".jpg" {
-		imgContent := canvas.NewImageFromReader(bytes.NewReader(data), fmt.Sprintf("ext_%d", time.Now().Unix()))
+		return
+	}
+	
+	// Files hidden without their metadata are saved under a made-up name.
+	file := internal.NewEntry(internal.FileInfo{
+		Name: fmt.Sprintf("%d_extracted%s", time.Now().Unix(), ext),
+		MIME: internal.DetectMIME(ext, data),
+	}, data)
+	if ext == internal.ArchiveExtension {
+		archive, err := internal.ParseArchive(data)
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		if len(archive.Entries) != 1 || archive.Note != "" {
+			showArchiveDialog(parent, archive, signature)
+			return
+		}
+		file = archive.Entries[0]
+	}
+	
+	info := widget.NewLabel(fileDetails(file.FileInfo))
+	info.Alignment = fyne.TextAlignCenter
+	
+	if file.MIME == "image/png" || file.MIME == "image/jpeg" {
+		imgContent := canvas.NewImageFromReader(bytes.NewReader(file.Data), fmt.Sprintf("ext_%d", time.Now().Unix()))
 		imgContent.FillMode = canvas.ImageFillContain
 		imgContent.SetMinSize(fyne.NewSize(300, 300))
 		
 		saveBtn := widget.NewButtonWithIcon(i18n.T("btn_save_image"), theme.DocumentSaveIcon(), func() {
-			saveFile(parent, data, ext)
+			saveFile(parent, file)
 		})
 		
-		content := container.NewBorder(nil, saveBtn, nil, nil, imgContent)
+		content := container.NewBorder(container.NewVBox(signature, info), saveBtn, nil, nil, imgContent)
 		custom := dialog.NewCustom(i18n.T("result_title_image"), i18n.T("btn_close"), content, parent)
-		custom.Resize(fyne.NewSize(400, 400))
+		custom.Resize(fyne.NewSize(400, 450))
 		custom.Show()
 		
 	} else {
-		info := widget.NewLabel(i18n.Tf("label_format_size", map[string]interface{}{
-			"Format": ext,
-			"Size":   core.FormatBytes(len(data)),
-		}))
-		info.Alignment = fyne.TextAlignCenter
-		
 		saveBtn := widget.NewButtonWithIcon(i18n.T("btn_save_file"), theme.DocumentSaveIcon(), func() {
-			saveFile(parent, data, ext)
+			saveFile(parent, file)
 		})
 		
-		content := container.NewVBox(info, saveBtn)
+		content := container.NewVBox(signature, info, saveBtn)
 		dialog.NewCustom(i18n.T("result_title_file"), i18n.T("btn_close"), content, parent).Show()
 	}
 }
 
-func saveFile(parent fyne.Window, data []byte, ext string) {
+func fileDetails(info internal.FileInfo) string {
+	text := i18n.Tf("label_file_details", map[string]interface{}{
+		"Name": info.Name,
+		"Type": info.MIME,
+		"Size": core.FormatBytes(int(info.Size)),
+	})
+	if !info.ModTime.IsZero() {
+		text += "\n" + i18n.Tf("label_file_modified", map[string]interface{}{
+			"Modified": info.ModTime.Local().Format("2006-01-02 15:04:05"),
+		})
+	}
+	return text
+}
+
+// showArchiveDialog lists the files of an archive payload, with its note, and
+// saves the checked ones into a folder under their own names.
+func showArchiveDialog(parent fyne.Window, archive *internal.Archive, signature fyne.CanvasObject) {
+	top := container.NewVBox(signature)
+	if archive.Note != "" {
+		note := widget.NewMultiLineEntry()
+		note.SetText(archive.Note)
+		note.Wrapping = fyne.TextWrapWord
+		note.SetMinRowsVisible(3)
+		
+		copyBtn := widget.NewButtonWithIcon(i18n.T("btn_copy_text"), theme.ContentCopyIcon(), func() {
+			parent.Clipboard().SetContent(archive.Note)
+		})
+		top.Add(widget.NewLabelWithStyle(i18n.T("label_archive_note"), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+		top.Add(container.NewBorder(nil, copyBtn, nil, nil, note))
+	}
+	
+	selected := make([]bool, len(archive.Entries))
+	
+	var saveBtn *widget.Button
+	list := container.NewVBox()
+	for i, e := range archive.Entries {
+		selected[i] = true
+		check := widget.NewCheck(i18n.Tf("label_archive_entry", map[string]interface{}{
+			"Name": e.Name,
+			"Size": core.FormatBytes(int(e.Size)),
+		}), nil)
+		check.SetChecked(true)
+		check.OnChanged = func(on bool) {
+			selected[i] = on
+			for _, s := range selected {
+				if s {
+					saveBtn.Enable()
+					return
+				}
+			}
+			saveBtn.Disable()
+		}
+		list.Add(check)
+	}
+	
+	saveBtn = widget.NewButtonWithIcon(i18n.T("btn_save_selected"), theme.DocumentSaveIcon(), func() {
+		var entries []internal.Entry
+		for i, e := range archive.Entries {
+			if selected[i] {
+				entries = append(entries, e)
+			}
+		}
+		saveEntries(parent, entries)
+	})
+	if len(archive.Entries) == 0 {
+		saveBtn.Hide()
+	}
+	
+	content := container.NewBorder(top, saveBtn, nil, nil, container.NewVScroll(list))
+	custom := dialog.NewCustom(i18n.T("result_title_archive"), i18n.T("btn_close"), content, parent)
+	custom.Resize(fyne.NewSize(450, 450))
+	custom.Show()
+}
+
+// saveEntries asks for a folder and writes entries into it, restoring their
+// permissions and modification times. Replacing existing files needs a
+// confirmation first.
+func saveEntries(parent fyne.Window, entries []internal.Entry) {
+	dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+		if dir == nil {
+			return
+		}
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		
+		write := func() {
+			for _, e := range entries {
+				name := filepath.Join(dir.Path(), e.Name)
+				if err := os.WriteFile(name, e.Data, e.SaveMode()); err != nil {
+					core.ShowLocalizedError(err, parent)
+					return
+				}
+				if err := restoreModTime(name, e.FileInfo); err != nil {
+					core.ShowLocalizedError(err, parent)
+					return
+				}
+			}
+			dialog.ShowInformation(i18n.T("dialog_save_success_title"), i18n.T("dialog_files_saved_to")+"\n"+dir.Path(), parent)
+		}
+		
+		var existing []string
+		for _, e := range entries {
+			if _, err := os.Stat(filepath.Join(dir.Path(), e.Name)); err == nil {
+				existing = append(existing, e.Name)
+			}
+		}
+		if len(existing) == 0 {
+			write()
+			return
+		}
+		
+		dialog.ShowConfirm(i18n.T("dialog_overwrite_title"), i18n.Tf("dialog_overwrite_files", map[string]interface{}{
+			"Files": strings.Join(existing, "\n"),
+		}), func(ok bool) {
+			if ok {
+				write()
+			}
+		}, parent)
+	}, parent)
+}
+
+// newSignatureBox describes the signature on an extracted payload and offers
+// to trust an unknown signer. It is empty for unsigned payloads.
+func newSignatureBox(v internal.Verification) fyne.CanvasObject {
+	box := container.NewVBox()
+	
+	var key string
+	var icon fyne.Resource
+	switch v.Status {
+	case internal.SignatureNone:
+		return box
+	case internal.SignatureVerified:
+		key, icon = "label_signature_verified", theme.ConfirmIcon()
+	case internal.SignatureUnknownSigner:
+		key, icon = "label_signature_unknown", theme.QuestionIcon()
+	default:
+		key, icon = "label_signature_invalid", theme.ErrorIcon()
+	}
+	
+	status := widget.NewLabelWithStyle(i18n.T(key), fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	box.Add(container.NewBorder(nil, nil, widget.NewIcon(icon), nil, status))
+	
+	if v.Signer != nil {
+		signer := widget.NewLabel(v.Signer.String())
+		signer.Wrapping = fyne.TextWrapBreak
+		signer.TextStyle = fyne.TextStyle{Monospace: true}
+		box.Add(signer)
+	}
+	
+	if v.Status == internal.SignatureUnknownSigner {
+		var trustBtn *widget.Button
+		trustBtn = widget.NewButtonWithIcon(i18n.T("btn_trust_signer"), theme.ContentAddIcon(), func() {
+			core.TrustSigner(v.Signer)
+			status.SetText(i18n.T("label_signature_verified"))
+			trustBtn.Hide()
+		})
+		box.Add(trustBtn)
+	}
+	return box
+}
+
+// saveFile proposes the original name of file and restores its modification
+// time once saved.
+func saveFile(parent fyne.Window, file internal.Entry) {
 	fsDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
 		if writer == nil {
 			return
@@ -157,17 +346,121 @@ func saveFile(parent fyne.Window, data []byte, ext string) {
 			core.ShowLocalizedError(err, parent)
 			return
 		}
-		defer writer.Close()
 		
-		_, err = writer.Write(data)
+		_, err = writer.Write(file.Data)
+		if cerr := writer.Close(); err == nil {
+			err = cerr
+		}
 		if err != nil {
 			core.ShowLocalizedError(err, parent)
 			return
 		}
+		if err := restoreModTime(writer.URI().Path(), file.FileInfo); err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
 		
 		dialog.ShowInformation(i18n.T("dialog_save_success_title"), i18n.T("dialog_file_saved_to")+"\n"+writer.URI().Path(), parent)
 	}, parent)
 	
-	fsDialog.SetFileName(fmt.Sprintf("%d_extracted%s", time.Now().Unix(), ext))
+	fsDialog.SetFileName(file.Name)
+	fsDialog.Show()
+}
+
+func restoreModTime(path string, info internal.FileInfo) error {
+	if info.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, info.ModTime, info.ModTime)
+}
+
+// SaveKeyFile writes secret as an age-keygen style key file, with public in
+// a comment, and calls onSaved once it is on disk.
+func SaveKeyFile(parent fyne.Window, public, secret fmt.Stringer, onSaved func()) {
+	fsDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if writer == nil {
+			return
+		}
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		defer writer.Close()
+		
+		_, err = fmt.Fprintf(writer, "# created: %s\n# public key: %s\n%s\n",
+			time.Now().Format(time.RFC3339), public, secret)
+		if err != nil {
+			core.ShowLocalizedError(err, parent)
+			return
+		}
+		
+		dialog.ShowInformation(i18n.T("dialog_save_success_title"), i18n.T("dialog_key_saved_to")+"\n"+writer.URI().Path(), parent)
+		onSaved()
+	}, parent)
+	
+	fsDialog.SetTitleText(i18n.T("dialog_save_key_title"))
+	fsDialog.SetFileName(fmt.Sprintf("%d_zuon_key.txt", time.Now().Unix()))
 	fsDialog.Show()
 }
+
+// ShowAnalysis runs the steganalysis detectors on every image and shows how
+// likely they are to give the hidden data away.
+func ShowAnalysis(parent fyne.Window, imgs ...image.Image) {
+	progress := dialog.NewCustomWithoutButtons(i18n.T("dialog_analysis_title"), widget.NewProgressBarInfinite(), parent)
+	progress.Show()
+	
+	go func() {
+		reports := make([]analysis.Report, len(imgs))
+		for i, img := range imgs {
+			reports[i] = analysis.Analyze(img)
+		}
+		
+		fyne.Do(func() {
+			progress.Hide()
+			
+			content := container.NewVBox()
+			for i, r := range reports {
+				if len(reports) > 1 {
+					content.Add(widget.NewLabelWithStyle(i18n.Tf("label_analysis_image", map[string]interface{}{"Index": i + 1}), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+				}
+				content.Add(newAnalysisBox(r))
+			}
+			dialog.NewCustom(i18n.T("dialog_analysis_title"), i18n.T("btn_close"), container.NewVScroll(content), parent).Show()
+		})
+	}()
+}
+
+func newAnalysisBox(r analysis.Report) fyne.CanvasObject {
+	key, icon := "label_analysis_clean", theme.ConfirmIcon()
+	if r.Detectable() {
+		key, icon = "label_analysis_detectable", theme.WarningIcon()
+	}
+	verdict := widget.NewLabelWithStyle(i18n.T(key), fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	box := container.NewVBox(container.NewBorder(nil, nil, widget.NewIcon(icon), nil, verdict))
+	
+	if r.DCT {
+		box.Add(widget.NewLabel(i18n.Tf("label_analysis_dct", map[string]interface{}{
+			"ChiSquare": fmt.Sprintf("%.1f%%", r.ChiSquare*100),
+		})))
+		return box
+	}
+	
+	box.Add(widget.NewLabel(i18n.Tf("label_analysis_rate", map[string]interface{}{
+		"Rate": fmt.Sprintf("%.1f%%", r.Rate*100),
+	})))
+	
+	grid := container.NewGridWithColumns(4,
+		widget.NewLabel(""),
+		widget.NewLabelWithStyle("χ²", fyne.TextAlignTrailing, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("RS", fyne.TextAlignTrailing, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("SPA", fyne.TextAlignTrailing, fyne.TextStyle{Bold: true}),
+	)
+	for _, c := range r.Channels {
+		grid.Add(widget.NewLabel(c.Name))
+		for _, v := range []float64{c.ChiSquare, c.RS, c.SPA} {
+			grid.Add(widget.NewLabelWithStyle(fmt.Sprintf("%.3f", v), fyne.TextAlignTrailing, fyne.TextStyle{Monospace: true}))
+		}
+	}
+	box.Add(grid)
+	return box
+}
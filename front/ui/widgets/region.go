@@ -0,0 +1,224 @@
+package widgets
+
+import (
+	"image"
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/aomori446/zuon/front/i18n"
+	"github.com/aomori446/zuon/front/ui/core"
+	"github.com/aomori446/zuon/internal"
+)
+
+// maskExtensions are the images a mask can be loaded from.
+var maskExtensions = []string{".png", ".jpg", ".jpeg", ".bmp", ".tif", ".tiff", ".webp", ".gif"}
+
+// RegionEditor previews a carrier image with the region that embedding is
+// kept to: a rectangle dragged over it, or a mask loaded with SetMask, whose
+// dark parts are shaded.
+type RegionEditor struct {
+	widget.BaseWidget
+	
+	img  image.Image
+	rect image.Rectangle
+	mask image.Image
+	
+	// from is where the current drag started, in image pixels.
+	from     image.Point
+	dragging bool
+}
+
+func NewRegionEditor(img image.Image) *RegionEditor {
+	e := &RegionEditor{img: img}
+	e.ExtendBaseWidget(e)
+	return e
+}
+
+// Rect is the dragged rectangle in image pixels, empty when there is none.
+func (e *RegionEditor) Rect() image.Rectangle {
+	return e.rect
+}
+
+func (e *RegionEditor) Mask() image.Image {
+	return e.mask
+}
+
+// SetRect selects a rectangle and drops the mask.
+func (e *RegionEditor) SetRect(r image.Rectangle) {
+	e.rect, e.mask = r.Intersect(e.img.Bounds()), nil
+	e.Refresh()
+}
+
+// SetMask selects a mask and drops the rectangle.
+func (e *RegionEditor) SetMask(mask image.Image) {
+	e.rect, e.mask = image.Rectangle{}, mask
+	e.Refresh()
+}
+
+// frame returns where the image is drawn in a widget of the given size, and
+// its scale.
+func (e *RegionEditor) frame(size fyne.Size) (fyne.Position, float32) {
+	b := e.img.Bounds()
+	scale := min(size.Width/float32(b.Dx()), size.Height/float32(b.Dy()))
+	return fyne.NewPos((size.Width-float32(b.Dx())*scale)/2, (size.Height-float32(b.Dy())*scale)/2), scale
+}
+
+// pixel returns the image pixel under pos, clamped to the image.
+func (e *RegionEditor) pixel(pos fyne.Position) image.Point {
+	b := e.img.Bounds()
+	origin, scale := e.frame(e.Size())
+	x := int((pos.X - origin.X) / scale)
+	y := int((pos.Y - origin.Y) / scale)
+	return image.Pt(b.Min.X+min(max(x, 0), b.Dx()), b.Min.Y+min(max(y, 0), b.Dy()))
+}
+
+func (e *RegionEditor) Dragged(ev *fyne.DragEvent) {
+	if !e.dragging {
+		e.from = e.pixel(ev.Position.Subtract(ev.Dragged))
+		e.dragging = true
+	}
+	e.SetRect(image.Rectangle{Min: e.from, Max: e.pixel(ev.Position)}.Canon())
+}
+
+func (e *RegionEditor) DragEnd() {
+	e.dragging = false
+}
+
+func (e *RegionEditor) CreateRenderer() fyne.WidgetRenderer {
+	picture := canvas.NewImageFromImage(e.img)
+	picture.FillMode = canvas.ImageFillContain
+	
+	shade := canvas.NewImageFromImage(nil)
+	shade.FillMode = canvas.ImageFillStretch
+	shade.Hide()
+	
+	box := canvas.NewRectangle(color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0x30})
+	box.StrokeColor = theme.Color(theme.ColorNamePrimary)
+	box.StrokeWidth = 2
+	box.Hide()
+	
+	return &regionRenderer{editor: e, picture: picture, shade: shade, box: box}
+}
+
+type regionRenderer struct {
+	editor  *RegionEditor
+	picture *canvas.Image
+	shade   *canvas.Image
+	box     *canvas.Rectangle
+	
+	// shaded is the mask that shade was made from.
+	shaded image.Image
+}
+
+func (r *regionRenderer) Layout(size fyne.Size) {
+	r.picture.Resize(size)
+	
+	e := r.editor
+	origin, scale := e.frame(size)
+	b := e.img.Bounds()
+	r.shade.Move(origin)
+	r.shade.Resize(fyne.NewSize(float32(b.Dx())*scale, float32(b.Dy())*scale))
+	
+	rect := e.rect.Sub(b.Min)
+	r.box.Move(origin.Add(fyne.NewPos(float32(rect.Min.X)*scale, float32(rect.Min.Y)*scale)))
+	r.box.Resize(fyne.NewSize(float32(rect.Dx())*scale, float32(rect.Dy())*scale))
+}
+
+func (r *regionRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(300, 300)
+}
+
+func (r *regionRenderer) Refresh() {
+	e := r.editor
+	if e.mask != r.shaded {
+		r.shaded = e.mask
+		r.shade.Image = shadeMask(e.mask)
+	}
+	r.shade.Hidden = e.mask == nil
+	r.box.Hidden = e.rect.Empty()
+	
+	r.Layout(e.Size())
+	canvas.Refresh(r.shade)
+	canvas.Refresh(r.box)
+}
+
+func (r *regionRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.picture, r.shade, r.box}
+}
+
+func (r *regionRenderer) Destroy() {}
+
+// shadeMask darkens the parts of mask that MaskRegion leaves out.
+func shadeMask(mask image.Image) image.Image {
+	if mask == nil {
+		return nil
+	}
+	
+	b := mask.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if color.GrayModel.Convert(mask.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y < 0x80 {
+				out.SetNRGBA(x, y, color.NRGBA{A: 0xA0})
+			}
+		}
+	}
+	return out
+}
+
+// ShowRegionDialog lets the user drag a rectangle over img or load a mask for
+// it, starting from rect or mask, and hands the result to onChosen. Nothing
+// selected means the whole image.
+func ShowRegionDialog(parent fyne.Window, img image.Image, rect image.Rectangle, mask image.Image, onChosen func(image.Rectangle, image.Image)) {
+	editor := NewRegionEditor(img)
+	if mask != nil {
+		editor.SetMask(mask)
+	} else {
+		editor.SetRect(rect)
+	}
+	
+	hint := widget.NewLabel(i18n.T("label_region_hint"))
+	hint.Wrapping = fyne.TextWrapWord
+	
+	maskBtn := widget.NewButtonWithIcon(i18n.T("btn_load_mask"), theme.FolderOpenIcon(), func() {
+		d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if reader == nil {
+				return
+			}
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			defer reader.Close()
+			
+			mask, err := internal.DecodeImage(reader)
+			if err != nil {
+				core.ShowLocalizedError(err, parent)
+				return
+			}
+			editor.SetMask(mask)
+		}, parent)
+		d.SetTitleText(i18n.T("dialog_select_mask"))
+		d.SetFilter(storage.NewExtensionFileFilter(maskExtensions))
+		d.Show()
+	})
+	
+	clearBtn := widget.NewButtonWithIcon(i18n.T("btn_clear_region"), theme.ContentClearIcon(), func() {
+		editor.SetRect(image.Rectangle{})
+	})
+	
+	content := container.NewBorder(hint, container.NewGridWithColumns(2, maskBtn, clearBtn), nil, nil, editor)
+	d := dialog.NewCustomConfirm(i18n.T("region_title"), i18n.T("btn_apply"), i18n.T("btn_cancel"), content, func(ok bool) {
+		if ok {
+			onChosen(editor.Rect(), editor.Mask())
+		}
+	}, parent)
+	d.Resize(fyne.NewSize(500, 560))
+	d.Show()
+}
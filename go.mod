@@ -8,6 +8,9 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/google/uuid v1.6.0
 	github.com/nicksnyder/go-i18n/v2 v2.5.1
+	golang.org/x/crypto v0.40.0
+	golang.org/x/image v0.24.0
+	golang.org/x/term v0.33.0
 	golang.org/x/text v0.27.0
 )
 
@@ -61,8 +64,6 @@ require (
 	github.com/yuin/goldmark v1.7.8 // indirect
 	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/arch v0.20.0 // indirect
-	golang.org/x/crypto v0.40.0 // indirect
-	golang.org/x/image v0.24.0 // indirect
 	golang.org/x/mod v0.25.0 // indirect
 	golang.org/x/net v0.42.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
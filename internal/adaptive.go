@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// Syndrome-trellis codes: body bit i is the parity of the cover bits of
+// blocks i-stcHeight+1 to i picked by the columns of a stcHeight-row matrix,
+// one block of width cover bits per body bit.
+const (
+	stcHeight = 7
+
+	// stcSegment body bits are coded together; segments are independent,
+	// which bounds the memory of the trellis.
+	stcSegment = 1024
+
+	// stcMaxWidth caps the cover bits per body bit, so small bodies do not
+	// pay for the whole image.
+	stcMaxWidth = 32
+)
+
+// stcStream holds the body as the syndrome of the lowest bit of the samples of
+// a PixOperator with a one-bit layout. Extraction only reads those bits in
+// stream order; embedding picks, out of every bit pattern with the right
+// syndrome, the one that is cheapest to reach under the cost map of the image.
+//
+// The code depends on the body length, so the body is written in one piece
+// and read with the length from its header.
+type stcStream struct {
+	img    pixImage
+	body   *PixOperator
+	length int
+
+	// decoded caches the body once read.
+	decoded []byte
+}
+
+func newSTCStream(img pixImage, body *PixOperator, length int) *stcStream {
+	return &stcStream{img: img, body: body, length: length}
+}
+
+// Capacity keeps at least two cover bits per body bit.
+func (s *stcStream) Capacity() int {
+	return s.body.Capacity() / 2
+}
+
+// width is the number of cover bits per body bit for a body of n bytes.
+func (s *stcStream) width(n int) int {
+	return min(s.body.Capacity()/n, stcMaxWidth)
+}
+
+// columns returns the columns of the code for the given width. Their first
+// and last rows are set, which every good code needs; the rest is a fixed
+// pseudo-random pattern.
+func stcColumns(width int) []int {
+	cols := make([]int, width)
+	x := uint64(0x9E3779B97F4A7C15) ^ uint64(width)
+	for i := range cols {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		cols[i] = int(x)&(1<<stcHeight-1) | 1 | 1<<(stcHeight-1)
+	}
+	return cols
+}
+
+func (s *stcStream) Embed(data []byte, off int) error {
+	if off != 0 || len(data) == 0 || len(data) > s.Capacity() {
+		return errors.New("adaptive bodies are written whole")
+	}
+
+	width := s.width(len(data))
+	cols := stcColumns(width)
+	costs := newCostMap(s.img)
+	pix := s.body.Pix
+	c := s.body.cursor()
+
+	states := 1 << stcHeight
+	inf := float32(math.Inf(1))
+	weight := make([]float32, states)
+	next := make([]float32, states)
+	words := states / 64
+	path := make([]uint64, stcSegment*width*words)
+	cover := make([]int, stcSegment*width)
+
+	total := len(data) * 8
+	for from := 0; from < total; from += stcSegment {
+		n := min(stcSegment, total-from)
+		cover := cover[:n*width]
+		for j := range cover {
+			cover[j], _ = c.next()
+		}
+		path := path[:len(cover)*words]
+		clear(path)
+
+		for st := range weight {
+			weight[st] = inf
+		}
+		weight[0] = 0
+
+		// Forward: weight[st] is the cheapest change reaching partial
+		// syndrome st, whose bit 0 is the next body bit.
+		for i := 0; i < n; i++ {
+			for k, col := range cols {
+				j := i*width + k
+				rho := costs.at(cover[j])
+				keep, flip := float32(0), rho
+				if pix[cover[j]]&1 == 1 {
+					keep, flip = rho, 0
+				}
+
+				row := path[j*words : (j+1)*words]
+				for st := 0; st < states; st++ {
+					w0, w1 := weight[st]+keep, weight[st^col]+flip
+					if w1 < w0 {
+						next[st] = w1
+						row[st>>6] |= 1 << (st & 63)
+					} else {
+						next[st] = w0
+					}
+				}
+				weight, next = next, weight
+			}
+
+			bit := int(dataBit(data, from+i))
+			for st := 0; st < states/2; st++ {
+				next[st] = weight[st<<1|bit]
+			}
+			for st := states / 2; st < states; st++ {
+				next[st] = inf
+			}
+			weight, next = next, weight
+		}
+
+		st := 0
+		for i := range weight[:states/2] {
+			if weight[i] < weight[st] {
+				st = i
+			}
+		}
+
+		// Backward: set every cover bit to the choice on the cheapest path.
+		for i := n - 1; i >= 0; i-- {
+			st = st<<1 | int(dataBit(data, from+i))
+			for k := width - 1; k >= 0; k-- {
+				j := i*width + k
+				y := uint8(path[j*words+st>>6] >> (st & 63) & 1)
+				if pix[cover[j]]&1 != y {
+					s.body.flip(cover[j], 0)
+				}
+				if y == 1 {
+					st ^= cols[k]
+				}
+			}
+		}
+	}
+
+	s.length, s.decoded = len(data), nil
+	return nil
+}
+
+func (s *stcStream) UnEmbed(n int, off int) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > s.length {
+		return nil, errors.New("out of bounds")
+	}
+	if s.decoded == nil {
+		s.decoded = s.decode()
+	}
+	return append([]byte(nil), s.decoded[off:off+n]...), nil
+}
+
+// decode computes the syndrome of the cover bits.
+func (s *stcStream) decode() []byte {
+	width := s.width(s.length)
+	cols := stcColumns(width)
+	pix := s.body.Pix
+	c := s.body.cursor()
+
+	out := make([]byte, s.length)
+	total := s.length * 8
+	for from := 0; from < total; from += stcSegment {
+		syndrome := 0
+		for i := from; i < min(from+stcSegment, total); i++ {
+			for _, col := range cols {
+				if idx, _ := c.next(); pix[idx]&1 == 1 {
+					syndrome ^= col
+				}
+			}
+			out[i/8] |= uint8(syndrome&1) << (7 - i%8)
+			syndrome >>= 1
+		}
+	}
+	return out
+}
+
+// Writer and Reader only work on a body that is already in place, see
+// stcStream.
+func (s *stcStream) Writer(off int) io.Writer {
+	return &offsetStream{s: s, off: off}
+}
+
+func (s *stcStream) Reader(off int) io.Reader {
+	return &offsetStream{s: s, off: off}
+}
+
+func dataBit(data []byte, i int) uint8 {
+	return data[i/8] >> (7 - i%8) & 1
+}
+
+// costMap rates how detectable a change to each sample of an image is, after
+// WOW: directional residuals around a sample are small in smooth areas, where
+// any change stands out, and large on edges and texture. The lowest bit, which
+// is what embedding changes, is left out.
+type costMap struct {
+	pix    []uint8
+	stride int
+	size   int // bytes per pixel
+	depth  int // bytes per sample
+	width  int
+	height int
+}
+
+func newCostMap(img pixImage) *costMap {
+	pix, wide := img.samples()
+	m := &costMap{pix: pix, stride: img.stride(), size: 4, depth: 1}
+	if wide {
+		m.size, m.depth = 8, 2
+	}
+	m.width, m.height = img.Bounds().Dx(), img.Bounds().Dy()
+	return m
+}
+
+// costDirections are the directions of the residual filters.
+var costDirections = [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}}
+
+// at returns the cost of changing the sample at index idx of Pix.
+func (m *costMap) at(idx int) float32 {
+	y, x := idx/m.stride, idx%m.stride/m.size
+	off := idx % m.stride % m.size / m.depth * m.depth
+
+	var cost float32
+	for _, d := range costDirections {
+		// A change at (x, y) moves the residuals of it and both neighbours
+		// along d, weighted as in the filter.
+		xi := m.residual(x-d[0], y-d[1], off, d) + 2*m.residual(x, y, off, d) + m.residual(x+d[0], y+d[1], off, d)
+		cost += 1 / float32(xi+1)
+	}
+	return cost
+}
+
+// residual is the absolute second difference along d at (x, y).
+func (m *costMap) residual(x, y, off int, d [2]int) int {
+	r := 2*m.value(x, y, off) - m.value(x-d[0], y-d[1], off) - m.value(x+d[0], y+d[1], off)
+	if r < 0 {
+		return -r
+	}
+	return r
+}
+
+func (m *costMap) value(x, y, off int) int {
+	x = min(max(x, 0), m.width-1)
+	y = min(max(y, 0), m.height-1)
+	return int(m.pix[y*m.stride+x*m.size+off] >> 1)
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"image/color"
+	mrand "math/rand"
+	"testing"
+)
+
+// halfTextured is flat grey on the left half and noise on the right.
+func halfTextured(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	r := mrand.New(mrand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{120, 130, 140, 255}
+			if x >= w/2 {
+				c = color.NRGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// changes counts the bytes that differ between a and b in either half.
+func changes(a, b *image.NRGBA) (left, right int) {
+	w := a.Bounds().Dx()
+	for i := range a.Pix {
+		if a.Pix[i] != b.Pix[i] {
+			if i%a.Stride/4 < w/2 {
+				left++
+			} else {
+				right++
+			}
+		}
+	}
+	return
+}
+
+func TestAdaptive(t *testing.T) {
+	src := halfTextured(200, 150)
+	opts := Options{Traversal: TraversalScattered, Layout: Layout{Bits: 1, Channels: ChannelsRGB}, Compression: CompressionNone}
+	adaptive := opts
+	adaptive.Coding = CodingAdaptive
+
+	capPlain, capAdaptive := Capacity(src, opts), Capacity(src, adaptive)
+	if capAdaptive <= 0 || capAdaptive > capPlain/2+1 {
+		t.Fatalf("capacity %d adaptive, %d plain", capAdaptive, capPlain)
+	}
+
+	data := make([]byte, 2000)
+	rand.Read(data)
+
+	out, err := EmbedData(src, data, ".bin", 0, "secret1", adaptive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ext, _, err := ExtractData(out, 0, "secret1", Options{Traversal: TraversalScattered})
+	if err != nil || ext != ".bin" || !bytes.Equal(got, data) {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ExtractData(out, 0, "wrongpw", Options{Traversal: TraversalScattered}); err == nil {
+		t.Fatal("opened with the wrong password")
+	}
+
+	plain, err := EmbedData(src, data, ".bin", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, ar := changes(src, out.(*image.NRGBA))
+	pl, pr := changes(src, plain.(*image.NRGBA))
+	if al+ar >= pl+pr || al*5 > ar {
+		t.Fatalf("adaptive changed %d flat and %d textured bytes, plain %d and %d", al, ar, pl, pr)
+	}
+
+	h, _, err := Inspect(out, 0, "secret1")
+	if err != nil || h.Coding != CodingAdaptive || h.Flags&FlagCoded == 0 || h.Layout.Bits != 1 {
+		t.Fatalf("Inspect: %+v %v", h, err)
+	}
+
+	// The capacity is exact: one byte more does not fit.
+	big := make([]byte, capAdaptive-4)
+	rand.Read(big)
+	if _, err := EmbedData(src, big, ".bin", 0, "secret1", adaptive); err != ErrImageTooSmall {
+		t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+	}
+	full := make([]byte, capAdaptive-5)
+	rand.Read(full)
+	out, err = EmbedData(src, full, ".bin", 0, "secret1", adaptive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, full) {
+		t.Fatal(err)
+	}
+}
+
+func TestAdaptiveOptions(t *testing.T) {
+	src := halfTextured(160, 120)
+	data := []byte("adaptive coding with everything turned on, more or less")
+	for _, opts := range []Options{
+		{Coding: CodingAdaptive},
+		{Coding: CodingAdaptive, Traversal: TraversalScattered, Redundancy: RedundancyHigh},
+		{Coding: CodingAdaptive, Layout: Layout{Bits: 3, Channels: ChannelsRGBA}, Alpha: AlphaRaw},
+		{Coding: CodingAdaptive, Traversal: TraversalScattered, Redundancy: RedundancyLow, KDF: KDF{ID: KDFPBKDF2SHA256, Iterations: 1000}},
+	} {
+		out, err := EmbedData(src, data, "", 0, "secret1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, _, err := ExtractData(out, 0, "secret1", Options{})
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+	}
+
+	// ECC repairs a damaged header and body.
+	out, err := EmbedData(src, data, "", 0, "secret1", Options{Coding: CodingAdaptive, Redundancy: RedundancyHigh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := out.(*image.NRGBA)
+	for i := 0; i < 6; i++ {
+		img.Pix[i*4] ^= 3
+	}
+	if got, _, _, err := ExtractData(img, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("damaged: %v", err)
+	}
+
+	// 16-bit images and transparency.
+	wide := image.NewNRGBA64(image.Rect(0, 0, 80, 80))
+	r := mrand.New(mrand.NewSource(2))
+	for i := range wide.Pix {
+		wide.Pix[i] = uint8(r.Intn(256))
+	}
+	for i := 6; i < len(wide.Pix); i += 8 {
+		if i%64 == 6 {
+			wide.Pix[i], wide.Pix[i+1] = 0, 0
+		}
+	}
+	out, err = EmbedData(wide, data, "", 0, "secret1", Options{Coding: CodingAdaptive, Layout: Layout{Bits: 1, Channels: ChannelsRGBA}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("16-bit: %v", err)
+	}
+
+	// Shards, streams and deniable carriers take the option too.
+	shards, err := EmbedShards([]Carrier{src, halfTextured(100, 100)}, data, "", "secret1", Options{Coding: CodingAdaptive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractShards(shards, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("shards: %v", err)
+	}
+	w, err := NewEmbedWriter(src, ".txt", "secret1", Options{Coding: CodingAdaptive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(w.Carrier(), 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("stream: %v", err)
+	}
+	dn, err := EmbedDeniable(src, Payload{Data: data, Password: "secret1"}, Payload{Data: []byte("x"), Password: "other1"}, Options{Coding: CodingAdaptive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(dn, 0, "other1", Options{}); err != nil || string(got) != "x" {
+		t.Fatalf("deniable: %v", err)
+	}
+
+	// An unknown coding is refused.
+	if _, err := EmbedData(src, data, "", 0, "secret1", Options{Coding: 9}); err != ErrInternal {
+		t.Fatalf("unknown coding: got %v, want %v", err, ErrInternal)
+	}
+}
@@ -0,0 +1,106 @@
+// Package analysis runs standard LSB steganalysis on images, so a carrier and
+// payload size can be checked against what common detectors would flag.
+package analysis
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/aomori446/zuon/internal"
+)
+
+// Thresholds above which a detector counts as having found hidden data. An
+// estimated rate of a few percent is within the noise of clean images.
+const (
+	ChiSquareThreshold = 0.95
+	RateThreshold      = 0.05
+)
+
+// Channel holds the results of every detector on one color channel.
+// ChiSquare is the probability that the least significant bits were replaced
+// by random data; RS and SPA estimate the share of samples carrying a hidden
+// bit.
+type Channel struct {
+	Name      string
+	ChiSquare float64
+	RS        float64
+	SPA       float64
+}
+
+// Report sums up the channels of an image: the highest chi-square
+// probability and the mean RS and SPA estimates, with Rate the mean of both.
+//
+// JPEG carriers hide data in their DCT coefficients, where RS and SPA do not
+// apply; their report holds a single DCT channel with the chi-square
+// probability of the AC coefficients, and DCT set.
+type Report struct {
+	Channels  []Channel
+	ChiSquare float64
+	RS        float64
+	SPA       float64
+	Rate      float64
+	DCT       bool
+}
+
+// Detectable reports whether any detector crosses its threshold.
+func (r Report) Detectable() bool {
+	return r.ChiSquare > ChiSquareThreshold || r.Rate > RateThreshold
+}
+
+// Analyze runs the detectors on img.
+func Analyze(img image.Image) Report {
+	if j, ok := img.(*internal.JPEG); ok {
+		p := chiSquare(histogramDCT(j.ACCoefficients()))
+		return Report{Channels: []Channel{{Name: "DCT", ChiSquare: p}}, ChiSquare: p, DCT: true}
+	}
+
+	pix := toNRGBA(img)
+	var r Report
+	for i, name := range []string{"R", "G", "B"} {
+		samples := channel(pix, i)
+		c := Channel{
+			Name:      name,
+			ChiSquare: chiSquare(histogram(samples.values)),
+			RS:        rsAnalysis(samples),
+			SPA:       samplePairs(samples),
+		}
+		r.Channels = append(r.Channels, c)
+		r.ChiSquare = max(r.ChiSquare, c.ChiSquare)
+		r.RS += c.RS / 3
+		r.SPA += c.SPA / 3
+	}
+	r.Rate = (r.RS + r.SPA) / 2
+	return r
+}
+
+// samples is one channel of an image, row by row.
+type samples struct {
+	values        []uint8
+	width, height int
+}
+
+func (s samples) at(x, y int) int {
+	return int(s.values[y*s.width+x])
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if pix, ok := img.(*image.NRGBA); ok {
+		return pix
+	}
+	b := img.Bounds()
+	pix := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(pix, pix.Bounds(), img, b.Min, draw.Src)
+	return pix
+}
+
+func channel(pix *image.NRGBA, i int) samples {
+	b := pix.Bounds()
+	s := samples{values: make([]uint8, 0, b.Dx()*b.Dy()), width: b.Dx(), height: b.Dy()}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := pix.Pix[(y-b.Min.Y)*pix.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			s.values = append(s.values, row[x*4+i])
+		}
+	}
+	return s
+}
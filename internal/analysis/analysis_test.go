@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/aomori446/zuon/internal"
+)
+
+// natural returns a smooth image with a little noise and every least
+// significant bit cleared, so that only embedding sets them.
+func natural(w, h int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				v := 128 + 60*math.Sin(float64(x)/(17+float64(c)))*math.Cos(float64(y)/23) + 30*math.Sin(float64(x+y)/41) + rng.NormFloat64()*2
+				img.Pix[y*img.Stride+x*4+c] = uint8(math.Max(0, math.Min(255, math.Round(v)))) &^ 1
+			}
+			img.Pix[y*img.Stride+x*4+3] = 255
+		}
+	}
+	return img
+}
+
+// embedRate replaces the least significant bit of a share q of the samples
+// of src with a random one.
+func embedRate(src *image.NRGBA, q float64) *image.NRGBA {
+	rng := rand.New(rand.NewSource(2))
+	img := image.NewNRGBA(src.Rect)
+	copy(img.Pix, src.Pix)
+	for i := range img.Pix {
+		if i%4 != 3 && rng.Float64() < q {
+			img.Pix[i] = img.Pix[i]&^1 | uint8(rng.Intn(2))
+		}
+	}
+	return img
+}
+
+func TestAnalyzeClean(t *testing.T) {
+	if r := Analyze(natural(400, 300)); r.Detectable() {
+		t.Fatalf("clean image flagged: %+v", r)
+	}
+}
+
+func TestAnalyzeRate(t *testing.T) {
+	base := natural(400, 300)
+	for _, q := range []float64{0.05, 0.1, 0.25, 0.5} {
+		r := Analyze(embedRate(base, q))
+		if math.Abs(r.Rate-q) > 0.06 {
+			t.Errorf("rate %.2f estimated as %.3f", q, r.Rate)
+		}
+		if !r.Detectable() {
+			t.Errorf("rate %.2f not flagged", q)
+		}
+	}
+
+	if r := Analyze(embedRate(base, 1)); r.ChiSquare < ChiSquareThreshold {
+		t.Errorf("full embedding: chi-square %.3f", r.ChiSquare)
+	}
+}
+
+func TestAnalyzeEmbedded(t *testing.T) {
+	img := natural(200, 200)
+	opts := internal.Options{
+		Layout:      internal.Layout{Bits: 1, Channels: internal.ChannelsRGB},
+		Compression: internal.CompressionNone,
+		KDF:         internal.LegacyKDF,
+	}
+	out, err := internal.EmbedData(img, make([]byte, internal.Capacity(img, opts)-1), "", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := Analyze(out.(image.Image)); !r.Detectable() {
+		t.Fatalf("full carrier not flagged: %+v", r)
+	}
+}
+
+func TestAnalyzeSmall(t *testing.T) {
+	for _, size := range []int{1, 2, 3} {
+		r := Analyze(natural(size, size))
+		if math.IsNaN(r.ChiSquare) || math.IsNaN(r.Rate) {
+			t.Errorf("%dx%d: %+v", size, size, r)
+		}
+	}
+}
+
+func TestAnalyzeJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, natural(64, 64), nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := internal.DecodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := Analyze(img.(image.Image)); !r.DCT || len(r.Channels) != 1 {
+		t.Fatalf("got %+v", r)
+	}
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import "math"
+
+// histogram counts the values of a channel.
+func histogram(values []uint8) []float64 {
+	h := make([]float64, 256)
+	for _, v := range values {
+		h[v]++
+	}
+	return h
+}
+
+// histogramDCT counts quantized AC coefficients by magnitude, leaving out 0
+// and 1, which neither JSteg-like nor F5-like embedding uses.
+func histogramDCT(coef []int16) []float64 {
+	var h []float64
+	for _, c := range coef {
+		m := int(c)
+		if m < 0 {
+			m = -m
+		}
+		if m < 2 {
+			continue
+		}
+		for len(h) <= m {
+			h = append(h, 0)
+		}
+		h[m]++
+	}
+	return h
+}
+
+// chiSquare is the attack of Westfeld and Pfitzmann: replacing least
+// significant bits with random ones evens out the counts of every pair of
+// values 2k and 2k+1. It returns the probability that the pairs are as even
+// as embedding would leave them.
+func chiSquare(h []float64) float64 {
+	var x2 float64
+	pairs := 0
+	for k := 0; k+1 < len(h); k += 2 {
+		expected := (h[k] + h[k+1]) / 2
+		// Sparse pairs only add noise to the statistic.
+		if expected < 5 {
+			continue
+		}
+		d := h[k] - expected
+		x2 += d * d / expected
+		pairs++
+	}
+	if pairs < 2 {
+		return 0
+	}
+	return 1 - regularizedGammaP(float64(pairs-1)/2, x2/2)
+}
+
+// regularizedGammaP is the lower regularized incomplete gamma function, so
+// regularizedGammaP(k/2, x/2) is the chi-square distribution function with
+// k degrees of freedom.
+func regularizedGammaP(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	lg, _ := math.Lgamma(a)
+	front := math.Exp(a*math.Log(x) - x - lg)
+
+	if x < a+1 {
+		// Series expansion.
+		sum, term := 1/a, 1/a
+		for n := 1.0; n < 1000; n++ {
+			term *= x / (a + n)
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-15 {
+				break
+			}
+		}
+		return sum * front
+	}
+
+	// Continued fraction for the upper function, by Lentz's method.
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	f := d
+	for n := 1.0; n < 1000; n++ {
+		an := -n * (n - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		f *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return 1 - front*f
+}
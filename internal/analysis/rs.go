@@ -0,0 +1,105 @@
+package analysis
+
+import "math"
+
+// rsMask selects the samples of a group that get flipped.
+var rsMask = [4]bool{false, true, true, false}
+
+// rsAnalysis is the RS analysis of Fridrich, Goljan and Du. Flipping the
+// least significant bits of a group of neighbours usually makes it noisier
+// in a natural image; embedding erodes that, and measuring the groups again
+// with every bit flipped tells by how much. It returns the estimated share
+// of samples carrying a hidden bit.
+func rsAnalysis(s samples) float64 {
+	r0, s0, rn0, sn0 := rsCounts(s, false)
+	r1, s1, rn1, sn1 := rsCounts(s, true)
+
+	d0, d1 := r0-s0, r1-s1
+	dn0, dn1 := rn0-sn0, rn1-sn1
+
+	a := 2 * (d1 + d0)
+	b := dn0 - dn1 - d1 - 3*d0
+	c := d0 - dn0
+
+	var z float64
+	if math.Abs(a) < 1e-12 {
+		if math.Abs(b) < 1e-12 {
+			return 0
+		}
+		z = -c / b
+	} else {
+		disc := b*b - 4*a*c
+		if disc < 0 {
+			return 0
+		}
+		z1 := (-b + math.Sqrt(disc)) / (2 * a)
+		z2 := (-b - math.Sqrt(disc)) / (2 * a)
+		z = z1
+		if math.Abs(z2) < math.Abs(z1) {
+			z = z2
+		}
+	}
+	return clamp(z / (z - 0.5))
+}
+
+// rsCounts returns the shares of regular and singular groups under the mask
+// and under its negation, with every least significant bit flipped first
+// when flipped is set.
+func rsCounts(s samples, flipped bool) (r, sg, rn, sn float64) {
+	var groups float64
+	var g, m, n [4]int
+	for y := 0; y < s.height; y++ {
+		for x := 0; x+4 <= s.width; x += 4 {
+			for i := range g {
+				g[i] = s.at(x+i, y)
+				if flipped {
+					g[i] ^= 1
+				}
+				m[i], n[i] = g[i], g[i]
+				if rsMask[i] {
+					m[i] = g[i] ^ 1
+					n[i] = ((g[i] + 1) ^ 1) - 1
+				}
+			}
+
+			f := smoothness(g)
+			switch fm := smoothness(m); {
+			case fm > f:
+				r++
+			case fm < f:
+				sg++
+			}
+			switch fn := smoothness(n); {
+			case fn > f:
+				rn++
+			case fn < f:
+				sn++
+			}
+			groups++
+		}
+	}
+	if groups == 0 {
+		return 0, 0, 0, 0
+	}
+	return r / groups, sg / groups, rn / groups, sn / groups
+}
+
+// smoothness is the discrimination function of RS analysis.
+func smoothness(g [4]int) float64 {
+	var f int
+	for i := 1; i < len(g); i++ {
+		d := g[i] - g[i-1]
+		if d < 0 {
+			d = -d
+		}
+		f += d
+	}
+	return float64(f)
+}
+
+func clamp(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return math.Min(math.Max(v, 0), 1)
+}
@@ -0,0 +1,49 @@
+package analysis
+
+import "math"
+
+// samplePairs is the sample pair analysis of Dumitrescu, Wu and Wang, in the
+// form given by Ker. It counts horizontally adjacent pairs by how their
+// least significant bits relate to their order, which embedding shifts in a
+// known way, and returns the estimated share of samples carrying a hidden
+// bit.
+func samplePairs(s samples) float64 {
+	var p, x, y, k float64
+	for row := 0; row < s.height; row++ {
+		for col := 0; col+1 < s.width; col++ {
+			u, v := s.at(col, row), s.at(col+1, row)
+			p++
+			if (v%2 == 0 && u < v) || (v%2 == 1 && u > v) {
+				x++
+			}
+			if (v%2 == 0 && u > v) || (v%2 == 1 && u < v) {
+				y++
+			}
+			if u>>1 == v>>1 {
+				k++
+			}
+		}
+	}
+
+	a := k / 2
+	b := 2*x - p
+	c := y - x
+
+	var rate float64
+	disc := b*b - 4*a*c
+	switch {
+	case a == 0 || disc < 0:
+		if b == 0 {
+			return 0
+		}
+		rate = -c / b
+	default:
+		r1 := (-b + math.Sqrt(disc)) / (2 * a)
+		r2 := (-b - math.Sqrt(disc)) / (2 * a)
+		rate = r1
+		if math.Abs(r2) < math.Abs(r1) {
+			rate = r2
+		}
+	}
+	return clamp(rate)
+}
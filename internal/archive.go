@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ArchiveExtension marks a payload holding an Archive. Pass it to EmbedData
+// together with the output of Archive.MarshalBinary; ExtractData returns it
+// for such payloads, and OpenArchive turns them back into entries.
+const ArchiveExtension = ".zuonar"
+
+const archiveVersion = 1
+
+// Archive bundles named files and an optional text note into one payload. A
+// single file is hidden as an archive of one entry, so it keeps its name and
+// metadata too.
+type Archive struct {
+	Note    string
+	Entries []Entry
+}
+
+// FileInfo describes a hidden file.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	MIME    string
+}
+
+// SaveMode is what a hidden file is saved with: its permissions, but never
+// more than 0755 and always readable and writable by the owner, so a sender
+// can neither plant world-writable files nor ones the receiver cannot open.
+func (i FileInfo) SaveMode() fs.FileMode {
+	return i.Mode.Perm()&0o755 | 0o600
+}
+
+// NewFileInfo describes the file behind fi, with head, its first bytes, used
+// to detect the MIME type when the name does not tell.
+func NewFileInfo(fi fs.FileInfo, head []byte) FileInfo {
+	return FileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    fi.Mode().Perm(),
+		ModTime: fi.ModTime(),
+		MIME:    DetectMIME(fi.Name(), head),
+	}
+}
+
+// DetectMIME returns the MIME type for a file name, falling back to sniffing
+// its content.
+func DetectMIME(name string, head []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(head)
+}
+
+// Entry is a file in an Archive. Its Size is always len(Data).
+type Entry struct {
+	FileInfo
+	Data []byte
+}
+
+func NewEntry(info FileInfo, data []byte) Entry {
+	info.Size = int64(len(data))
+	return Entry{FileInfo: info, Data: data}
+}
+
+// ReadEntry reads the file at name into an entry that keeps its base name,
+// permissions, modification time and MIME type.
+func ReadEntry(name string) (Entry, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return Entry{}, err
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return Entry{}, err
+	}
+	return NewEntry(NewFileInfo(info, data), data), nil
+}
+
+// ReadArchive bundles the files at names, read with ReadEntry, and note into
+// an archive payload. A single file is an archive too, so it keeps its name.
+func ReadArchive(names []string, note string) ([]byte, error) {
+	a := &Archive{Note: note}
+	for _, name := range names {
+		e, err := ReadEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		a.Entries = append(a.Entries, e)
+	}
+	return a.MarshalBinary()
+}
+
+// StatFile describes the file at name like ReadEntry would, reading only its
+// first bytes to detect the MIME type.
+func StatFile(name string) (FileInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return NewFileInfo(info, head[:n]), nil
+}
+
+// ArchiveSize is the size of the archive ReadArchive makes of note and the
+// files that infos describe, known without reading them.
+func ArchiveSize(infos []FileInfo, note string) int {
+	n := len(appendArchiveHeader(nil, note, len(infos)))
+	for _, info := range infos {
+		head, _ := appendEntryHeader(nil, info, info.Size)
+		n += len(head) + int(info.Size)
+	}
+	return n
+}
+
+// validEntryName accepts plain file names only, so saving an entry can never
+// write outside the chosen directory.
+func validEntryName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 0xFFFF &&
+		!strings.ContainsAny(name, "/\\\x00")
+}
+
+// MarshalBinary lays the archive out as
+//
+//	version | u32 len(note) | note | u16 count | count × entry
+//
+// with every entry written by appendEntryHeader and followed by its data.
+func (a *Archive) MarshalBinary() ([]byte, error) {
+	if len(a.Entries) > 0xFFFF || uint64(len(a.Note)) > 0xFFFFFFFF {
+		return nil, ErrInvalidEntryName
+	}
+
+	seen := make(map[string]bool, len(a.Entries))
+	buf := appendArchiveHeader(nil, a.Note, len(a.Entries))
+	for _, e := range a.Entries {
+		if seen[e.Name] {
+			return nil, ErrInvalidEntryName
+		}
+		seen[e.Name] = true
+
+		var err error
+		if buf, err = appendEntryHeader(buf, e.FileInfo, int64(len(e.Data))); err != nil {
+			return nil, err
+		}
+		buf = append(buf, e.Data...)
+	}
+	return buf, nil
+}
+
+func appendArchiveHeader(buf []byte, note string, count int) []byte {
+	buf = append(buf, archiveVersion)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(note)))
+	buf = append(buf, note...)
+	return binary.BigEndian.AppendUint16(buf, uint16(count))
+}
+
+// appendEntryHeader writes
+//
+//	u16 len(name) | name | u32 mode | i64 mtime | u64 size | u8 len(mime) | mime
+//
+// with mtime in Unix nanoseconds, 0 when unknown, and only the permission
+// bits of the mode.
+func appendEntryHeader(buf []byte, info FileInfo, size int64) ([]byte, error) {
+	if !validEntryName(info.Name) {
+		return nil, ErrInvalidEntryName
+	}
+
+	var mtime int64
+	if !info.ModTime.IsZero() {
+		mtime = info.ModTime.UnixNano()
+	}
+	mimeType := info.MIME
+	if len(mimeType) > 255 {
+		mimeType = ""
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(info.Name)))
+	buf = append(buf, info.Name...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(info.Mode.Perm()))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(mtime))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(size))
+	buf = append(buf, uint8(len(mimeType)))
+	return append(buf, mimeType...), nil
+}
+
+// ParseArchive reads the output of MarshalBinary. Entries share memory with
+// data.
+func ParseArchive(data []byte) (*Archive, error) {
+	r := archiveReader{data: data, ok: true}
+	a, count, err := r.archiveHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, count)
+	for i := 0; i < count && r.ok; i++ {
+		info := r.entryHeader()
+		if !r.ok || info.Size > int64(len(r.data)) || !validEntryName(info.Name) || seen[info.Name] {
+			return nil, ErrInternal
+		}
+		seen[info.Name] = true
+
+		a.Entries = append(a.Entries, Entry{FileInfo: info, Data: r.bytes(int(info.Size))})
+	}
+
+	if !r.ok || len(r.data) != 0 {
+		return nil, ErrInternal
+	}
+	return a, nil
+}
+
+// OpenArchive returns the payload returned by ExtractData or ExtractShards
+// as an archive: archives are parsed, text becomes the note and a file
+// without metadata becomes the only entry, named after its extension.
+func OpenArchive(data []byte, extension string) (*Archive, error) {
+	switch extension {
+	case ArchiveExtension:
+		return ParseArchive(data)
+	case "":
+		return &Archive{Note: string(data)}, nil
+	}
+	return &Archive{Entries: []Entry{plainEntry(data, extension)}}, nil
+}
+
+// OpenFile returns the only file of a payload, or ErrSeveralFiles when it
+// holds more than that.
+func OpenFile(data []byte, extension string) (Entry, error) {
+	a, err := OpenArchive(data, extension)
+	if err != nil {
+		return Entry{}, err
+	}
+	if extension == "" {
+		return NewEntry(FileInfo{}, data), nil
+	}
+	if len(a.Entries) != 1 || a.Note != "" {
+		return Entry{}, ErrSeveralFiles
+	}
+	return a.Entries[0], nil
+}
+
+func plainEntry(data []byte, extension string) Entry {
+	name := "payload" + extension
+	return NewEntry(FileInfo{Name: name, Mode: 0o644, MIME: DetectMIME(name, data)}, data)
+}
+
+// ExtractArchive is ExtractData returning the payload as a list of entries.
+func ExtractArchive(src Carrier, off int, password string, opts Options) (*Archive, Verification, error) {
+	data, extension, verification, err := ExtractData(src, off, password, opts)
+	if err != nil {
+		return nil, verification, err
+	}
+	a, err := OpenArchive(data, extension)
+	return a, verification, err
+}
+
+type archiveReader struct {
+	data []byte
+	ok   bool
+}
+
+func (r *archiveReader) archiveHeader() (*Archive, int, error) {
+	version := r.byte()
+	if r.ok && version != archiveVersion {
+		return nil, 0, ErrUnsupportedFormat
+	}
+
+	a := &Archive{Note: string(r.bytes(int(r.uint32())))}
+	count := int(r.uint16())
+	if !r.ok {
+		return nil, 0, ErrInternal
+	}
+	return a, count, nil
+}
+
+func (r *archiveReader) entryHeader() FileInfo {
+	info := FileInfo{
+		Name: string(r.bytes(int(r.uint16()))),
+		Mode: fs.FileMode(r.uint32()).Perm(),
+	}
+	if mtime := int64(r.uint64()); mtime != 0 {
+		info.ModTime = time.Unix(0, mtime)
+	}
+	info.Size = int64(r.uint64() & (1<<63 - 1))
+	info.MIME = string(r.bytes(int(r.byte())))
+	return info
+}
+
+func (r *archiveReader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		r.ok, r.data = false, nil
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *archiveReader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *archiveReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *archiveReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *archiveReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// maxFileHeader bounds what fileFilter buffers before giving up; the headers
+// of a single file are far smaller.
+const maxFileHeader = 1 << 17
+
+// fileFilter passes on the data of a single-file archive written to it and
+// drops the headers in front, which it parses into info. Without archive it
+// passes everything on.
+type fileFilter struct {
+	w       io.Writer
+	archive bool
+	head    []byte
+	done    bool
+	info    FileInfo
+	n       int64
+}
+
+func (f *fileFilter) Write(p []byte) (int, error) {
+	if f.done || !f.archive {
+		n, err := f.w.Write(p)
+		f.n += int64(n)
+		return n, err
+	}
+
+	f.head = append(f.head, p...)
+	r := archiveReader{data: f.head, ok: true}
+	a, count, err := r.archiveHeader()
+	if err == nil && (a.Note != "" || count != 1) {
+		return 0, ErrSeveralFiles
+	}
+	var info FileInfo
+	if err == nil {
+		info = r.entryHeader()
+	}
+	if err == ErrInternal || (err == nil && !r.ok) {
+		if len(f.head) > maxFileHeader {
+			return 0, ErrInternal
+		}
+		return len(p), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !validEntryName(info.Name) {
+		return 0, ErrInternal
+	}
+
+	f.info, f.done, f.head = info, true, nil
+	if _, err := f.Write(r.data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// result returns the file that went through f once everything is written.
+func (f *fileFilter) result(extension string) (FileInfo, error) {
+	if !f.archive {
+		if extension == "" {
+			return FileInfo{Size: f.n}, nil
+		}
+		name := "payload" + extension
+		return FileInfo{Name: name, Size: f.n, Mode: 0o644, MIME: mime.TypeByExtension(extension)}, nil
+	}
+	if !f.done || f.n != f.info.Size {
+		return FileInfo{}, ErrInternal
+	}
+	return f.info, nil
+}
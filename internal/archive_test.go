@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFiles writes each body to its own file in a temporary directory.
+func writeFiles(t *testing.T, bodies ...string) []string {
+	dir := t.TempDir()
+	var names []string
+	for i, body := range bodies {
+		name := filepath.Join(dir, string(rune('a'+i))+".txt")
+		if err := os.WriteFile(name, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestArchiveSize(t *testing.T) {
+	names := writeFiles(t, "hello", "a longer second file")
+	var infos []FileInfo
+	for _, name := range names {
+		info, err := StatFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+
+	data, err := ReadArchive(names, "note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ArchiveSize(infos, "note"); got != len(data) {
+		t.Fatalf("ArchiveSize = %d, archive is %d bytes", got, len(data))
+	}
+}
+
+func TestArchive(t *testing.T) {
+	a := &Archive{Note: "read me first", Entries: []Entry{
+		NewEntry(FileInfo{Name: "doc.pdf"}, []byte("%PDF")),
+		NewEntry(FileInfo{Name: "empty"}, nil),
+	}}
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := EmbedData(testImage(100, 100), data, ArchiveExtension, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h, _, err := Inspect(out, 0, ""); err != nil || h.Kind != KindArchive {
+		t.Fatalf("Inspect: %+v %v", h, err)
+	}
+	got, _, err := ExtractArchive(out, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Note != a.Note || len(got.Entries) != 2 {
+		t.Fatalf("got %+v", got)
+	}
+	for i, e := range got.Entries {
+		if e.Name != a.Entries[i].Name || e.Size != int64(len(a.Entries[i].Data)) || !bytes.Equal(e.Data, a.Entries[i].Data) {
+			t.Fatalf("entry %d: got %+v", i, e)
+		}
+	}
+	if _, _, err := ExtractArchive(out, 0, "wrongpw", Options{}); err != ErrDecryptionFailed {
+		t.Fatalf("wrong password: got %v, want %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestArchiveInvalid(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`} {
+		a := &Archive{Entries: []Entry{NewEntry(FileInfo{Name: name}, nil)}}
+		if _, err := a.MarshalBinary(); err != ErrInvalidEntryName {
+			t.Errorf("name %q: got %v, want %v", name, err, ErrInvalidEntryName)
+		}
+	}
+	dup := &Archive{Entries: []Entry{NewEntry(FileInfo{Name: "x"}, nil), NewEntry(FileInfo{Name: "x"}, nil)}}
+	if _, err := dup.MarshalBinary(); err != ErrInvalidEntryName {
+		t.Errorf("duplicate: got %v, want %v", err, ErrInvalidEntryName)
+	}
+
+	data, err := (&Archive{Note: "n", Entries: []Entry{NewEntry(FileInfo{Name: "a"}, []byte("hi"))}}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		if _, err := ParseArchive(data[:i]); err == nil {
+			t.Fatalf("parsed %d of %d bytes", i, len(data))
+		}
+	}
+}
+
+func TestOpenArchive(t *testing.T) {
+	if a, err := OpenArchive([]byte("hi"), ""); err != nil || a.Note != "hi" || len(a.Entries) != 0 {
+		t.Fatalf("text: %+v %v", a, err)
+	}
+	if a, err := OpenArchive([]byte("x"), ".txt"); err != nil || len(a.Entries) != 1 || a.Entries[0].Name != "payload.txt" {
+		t.Fatalf("file: %+v %v", a, err)
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	names := writeFiles(t, "hello")
+	data, err := ReadArchive(names, "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ParseArchive(data)
+	if err != nil || a.Note != "n" || len(a.Entries) != 1 || a.Entries[0].Name != "a.txt" || string(a.Entries[0].Data) != "hello" {
+		t.Fatalf("got %+v %v", a, err)
+	}
+
+	if _, err := ReadArchive([]string{filepath.Join(filepath.Dir(names[0]), "missing")}, ""); err == nil {
+		t.Fatal("read a missing file")
+	}
+}
+
+func TestArchiveFileInfo(t *testing.T) {
+	mt := time.Date(2024, 5, 6, 7, 8, 9, 123, time.UTC)
+	a := &Archive{Entries: []Entry{
+		NewEntry(FileInfo{Name: "doc.pdf", Mode: 0o640, ModTime: mt, MIME: "application/pdf"}, []byte("%PDF")),
+		NewEntry(FileInfo{Name: "README", Mode: 0o755}, nil),
+	}}
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseArchive(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := got.Entries[0]; e.Mode != 0o640 || !e.ModTime.Equal(mt) || e.MIME != "application/pdf" || e.Size != 4 {
+		t.Fatalf("got %+v", e.FileInfo)
+	}
+	if e := got.Entries[1]; e.Mode != 0o755 || !e.ModTime.IsZero() || e.Size != 0 {
+		t.Fatalf("got %+v", e.FileInfo)
+	}
+}
+
+func TestArchiveVersion(t *testing.T) {
+	data, err := (&Archive{Entries: []Entry{NewEntry(FileInfo{Name: "a"}, []byte("hi"))}}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] = archiveVersion + 1
+	if _, err := ParseArchive(data); err != ErrUnsupportedFormat {
+		t.Fatalf("got %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestSaveMode(t *testing.T) {
+	for mode, want := range map[os.FileMode]os.FileMode{
+		0:      0o600,
+		0o400:  0o600,
+		0o640:  0o640,
+		0o777:  0o755,
+		0o4777: 0o755,
+		0o1666: 0o644,
+	} {
+		if got := (FileInfo{Mode: mode}).SaveMode(); got != want {
+			t.Errorf("SaveMode(%o) = %o, want %o", mode, got, want)
+		}
+	}
+}
+
+func TestStatFile(t *testing.T) {
+	name := writeFiles(t, "hello")[0]
+	if err := os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(name, mt, mt); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := StatFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "a.txt" || info.Size != 5 || info.Mode != 0o640 || !info.ModTime.Equal(mt) || info.MIME != "text/plain; charset=utf-8" {
+		t.Fatalf("got %+v", info)
+	}
+
+	e, err := ReadEntry(name)
+	if err != nil || e.FileInfo != info || string(e.Data) != "hello" {
+		t.Fatalf("ReadEntry: %+v %v", e.FileInfo, err)
+	}
+}
@@ -0,0 +1,374 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type AudioFormat uint8
+
+const (
+	AudioWAV AudioFormat = iota + 1
+	AudioFLAC
+)
+
+// Audio is PCM sound read by DecodeAudio, and results of EmbedData are
+// written back in the format it was read from. Samples are interleaved by
+// channel and signed, with BitDepth significant bits each.
+type Audio struct {
+	Format     AudioFormat
+	SampleRate int
+	Channels   int
+	BitDepth   int
+	Samples    []int32
+
+	// wav is the original WAV file, data the offset of its samples and
+	// width their size in bytes, so every other chunk is written back as it
+	// was.
+	wav   []byte
+	data  int
+	width int
+
+	// meta holds the FLAC metadata blocks besides STREAMINFO.
+	meta []flacBlock
+}
+
+var errAudioFormat = errors.New("unsupported audio")
+
+// DecodeAudio reads PCM WAV and FLAC files.
+func DecodeAudio(r io.Reader) (*Audio, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var a *Audio
+	switch {
+	case isWAV(data):
+		a, err = decodeWAV(data)
+	case isFLAC(data):
+		a, err = decodeFLAC(data)
+	default:
+		err = errAudioFormat
+	}
+	if err != nil {
+		return nil, ErrAudioNotSupported
+	}
+	return a, nil
+}
+
+// Encode writes a in the format it was read from.
+func (a *Audio) Encode(w io.Writer) error {
+	if a.Format == AudioFLAC {
+		return encodeFLAC(w, a)
+	}
+	return encodeWAV(w, a)
+}
+
+// Extension is the file extension Encode writes a with.
+func (a *Audio) Extension() string {
+	if a.Format == AudioFLAC {
+		return ".flac"
+	}
+	return ".wav"
+}
+
+func (a *Audio) clone() *Audio {
+	clone := *a
+	clone.Samples = append([]int32(nil), a.Samples...)
+	return &clone
+}
+
+// audioHeaderBits is how many low bits of a sample hold the container
+// header, which has to be found before the layout of the body is known.
+const audioHeaderBits = 1
+
+// SampleOperator treats the low bits of audio samples as one bit stream,
+// most significant bit first, like PixOperator does for pixels. Only the
+// Bits of Layout are used.
+type SampleOperator struct {
+	samples []int32
+	bits    int
+
+	// order maps the i-th visited position to an index in samples; nil
+	// visits samples in order.
+	order *scatter
+
+	// start is the first visited sample of the stream, past any header.
+	start int
+}
+
+func NewSampleOperator(a *Audio, bits int) *SampleOperator {
+	return &SampleOperator{samples: a.Samples, bits: bits}
+}
+
+func newSampleOperator(a *Audio, t Traversal, key string) *SampleOperator {
+	op := NewSampleOperator(a, audioHeaderBits)
+	if t == TraversalScattered {
+		op.Scatter(scatterSeed(key))
+	}
+	return op
+}
+
+func (s *SampleOperator) Scatter(seed []byte) {
+	s.order = newScatter(seed, len(s.samples))
+}
+
+// After returns an operator using bits per sample on every sample that the
+// first n bytes of s do not touch.
+func (s *SampleOperator) After(n int, bits int) *SampleOperator {
+	after := *s
+	after.start += (n*8 + s.bits - 1) / s.bits
+	after.bits = bits
+	return &after
+}
+
+func (s *SampleOperator) Capacity() int {
+	return max(len(s.samples)-s.start, 0) * s.bits / 8
+}
+
+// locate returns the index in samples and the bit position of stream bit k.
+func (s *SampleOperator) locate(k int) (int, uint) {
+	pos := s.start + k/s.bits
+	if s.order != nil {
+		pos = s.order.At(pos)
+	}
+	return pos, uint(s.bits - 1 - k%s.bits)
+}
+
+func (s *SampleOperator) Embed(data []byte, off int) error {
+	if off < 0 || off+len(data) > s.Capacity() {
+		return errors.New("out of bounds")
+	}
+
+	k := off * 8
+	for _, v := range data {
+		for i := 7; i >= 0; i-- {
+			idx, shift := s.locate(k)
+			k++
+			s.samples[idx] = s.samples[idx]&^(1<<shift) | int32(v>>i&1)<<shift
+		}
+	}
+	return nil
+}
+
+func (s *SampleOperator) UnEmbed(n int, off int) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > s.Capacity() {
+		return nil, errors.New("out of bounds")
+	}
+
+	k := off * 8
+	out := make([]byte, n)
+	for i := range out {
+		var v byte
+		for j := 0; j < 8; j++ {
+			idx, shift := s.locate(k)
+			k++
+			v = v<<1 | byte(s.samples[idx]>>shift&1)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// Writer and Reader walk the stream from byte off onwards; samples are
+// addressed directly, so they simply keep the offset.
+func (s *SampleOperator) Writer(off int) io.Writer {
+	return &offsetStream{s: s, off: off}
+}
+
+func (s *SampleOperator) Reader(off int) io.Reader {
+	return &offsetStream{s: s, off: off}
+}
+
+// audioLayout returns the layout of the body, or the zero Layout when opts
+// select an invalid one.
+func audioLayout(opts Options) Layout {
+	layout := opts.Layout
+	if layout == (Layout{}) {
+		layout = DefaultLayout
+	}
+	if !layout.Valid() {
+		return Layout{}
+	}
+	return layout
+}
+
+// audioMedium embeds in the low bits of the samples of an *Audio.
+type audioMedium struct{ *Audio }
+
+func (a audioMedium) carrier() Carrier {
+	return a.Audio
+}
+
+func (a audioMedium) clone() medium {
+	return audioMedium{a.Audio.clone()}
+}
+
+func (a audioMedium) room(opts Options, kdf KDF) (int, int) {
+	layout := audioLayout(opts)
+	if layout == (Layout{}) {
+		return 0, 0
+	}
+	h := newHeader(layout, kdf, KindFile, 0)
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+	return h.Size(), NewSampleOperator(a.Audio, audioHeaderBits).After(h.Size(), layout.Bits).Capacity()
+}
+
+func (a audioMedium) streams(t Traversal, key string) (stream, func(n int, h *Header) stream) {
+	op := newSampleOperator(a.Audio, t, key)
+	return op, func(n int, h *Header) stream {
+		return op.After(n, h.Layout.Bits)
+	}
+}
+
+func (a audioMedium) prepare(off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	layout := audioLayout(opts)
+	if layout == (Layout{}) {
+		return nil, ErrInvalidLayout
+	}
+
+	op := newSampleOperator(a.Audio, opts.Traversal, opts.scatterKey(password))
+
+	h := newHeader(layout, kdf, kind, 0)
+	if opts.Traversal == TraversalScattered {
+		h.Flags |= FlagScattered
+	}
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+
+	c := &carrier{dst: a, op: op, body: op.After(off+h.Size(), layout.Bits), header: h, redundancy: opts.Redundancy}
+	if c.capacity() <= 0 {
+		return nil, ErrAudioNotSupported
+	}
+	return c, nil
+}
+
+func (a audioMedium) flags() HeaderFlags {
+	return 0
+}
+
+func (a audioMedium) regions() bool {
+	return false
+}
+
+func (a audioMedium) encode(w io.Writer) error {
+	return a.Encode(w)
+}
+
+func (a audioMedium) extension() string {
+	return a.Extension()
+}
+
+func isWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// decodeWAV reads integer PCM from a WAVE_FORMAT_PCM or WAVE_FORMAT_EXTENSIBLE
+// file.
+func decodeWAV(data []byte) (*Audio, error) {
+	a := &Audio{Format: AudioWAV, wav: data}
+	var frame, size int
+	for p := 12; p+8 <= len(data); {
+		id, n := string(data[p:p+4]), int(binary.LittleEndian.Uint32(data[p+4:]))
+		body := data[p+8:]
+		if n > len(body) {
+			n = len(body)
+		}
+
+		switch id {
+		case "fmt ":
+			if n < 16 {
+				return nil, errAudioFormat
+			}
+			tag := binary.LittleEndian.Uint16(body)
+			if tag == 0xFFFE && n >= 26 {
+				tag = binary.LittleEndian.Uint16(body[24:])
+			}
+			if tag != 1 {
+				return nil, errAudioFormat
+			}
+			a.Channels = int(binary.LittleEndian.Uint16(body[2:]))
+			a.SampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			frame = int(binary.LittleEndian.Uint16(body[12:]))
+			a.BitDepth = int(binary.LittleEndian.Uint16(body[14:]))
+		case "data":
+			a.data, size = p+8, n
+		}
+		p += 8 + n + n&1
+	}
+
+	if a.Channels < 1 || a.data == 0 || frame == 0 || frame%a.Channels != 0 {
+		return nil, errAudioFormat
+	}
+	a.width = frame / a.Channels
+	if a.width < 1 || a.width > 4 || a.BitDepth < 1 || a.BitDepth > 8*a.width {
+		return nil, errAudioFormat
+	}
+
+	count := size / frame * a.Channels
+	a.Samples = make([]int32, count)
+	for i := range a.Samples {
+		a.Samples[i] = readSample(data[a.data+i*a.width:], a.width)
+	}
+	return a, nil
+}
+
+// readSample reads a little-endian sample; 8-bit samples are unsigned.
+func readSample(b []byte, width int) int32 {
+	if width == 1 {
+		return int32(b[0]) - 128
+	}
+	var v uint32
+	for i := width - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[i])
+	}
+	shift := 32 - 8*width
+	return int32(v<<shift) >> shift
+}
+
+func encodeWAV(w io.Writer, a *Audio) error {
+	out, data, width := append([]byte(nil), a.wav...), a.data, a.width
+	if a.wav == nil {
+		out, data, width = newWAV(a)
+	}
+
+	for i, v := range a.Samples {
+		b := out[data+i*width:]
+		if width == 1 {
+			b[0] = byte(v + 128)
+			continue
+		}
+		for j := 0; j < width; j++ {
+			b[j] = byte(v >> (8 * j))
+		}
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// newWAV lays out a plain PCM WAV file for a and returns it with the offset
+// and width of the samples, which are left to be filled in.
+func newWAV(a *Audio) ([]byte, int, int) {
+	width := (a.BitDepth + 7) / 8
+	size := width * len(a.Samples)
+
+	out := make([]byte, 0, 44+size+size&1)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(36+size+size&1))
+	out = append(out, "WAVEfmt "...)
+	out = binary.LittleEndian.AppendUint32(out, 16)
+	out = binary.LittleEndian.AppendUint16(out, 1)
+	out = binary.LittleEndian.AppendUint16(out, uint16(a.Channels))
+	out = binary.LittleEndian.AppendUint32(out, uint32(a.SampleRate))
+	out = binary.LittleEndian.AppendUint32(out, uint32(a.SampleRate*a.Channels*width))
+	out = binary.LittleEndian.AppendUint16(out, uint16(a.Channels*width))
+	out = binary.LittleEndian.AppendUint16(out, uint16(a.BitDepth))
+	out = append(out, "data"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	return out[:len(out)+size+size&1], len(out), width
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// makeWAV returns a WAV file of a quiet sine tone with a little noise and an
+// extra chunk before the samples.
+func makeWAV(channels, width, frames int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	var b bytes.Buffer
+	data := channels * width * frames
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+16+8+8+4+data+data&1))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1))
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(44100))
+	binary.Write(&b, binary.LittleEndian, uint32(44100*channels*width))
+	binary.Write(&b, binary.LittleEndian, uint16(channels*width))
+	binary.Write(&b, binary.LittleEndian, uint16(8*width))
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(4))
+	b.WriteString("INFO")
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(data))
+	amp := math.Pow(2, float64(8*width-1)) * 0.6
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			v := int64(amp*math.Sin(float64(i)*0.01*float64(c+1))) + int64(rnd.Intn(9)-4)
+			if width == 1 {
+				b.WriteByte(byte(v + 128))
+				continue
+			}
+			for j := 0; j < width; j++ {
+				b.WriteByte(byte(v >> (8 * j)))
+			}
+		}
+	}
+	if data&1 != 0 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	for _, width := range []int{1, 2, 3, 4} {
+		raw := makeWAV(2, width, 5001, int64(width))
+		a, err := DecodeAudio(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", width, err)
+		}
+		if a.BitDepth != 8*width || len(a.Samples) != 10002 {
+			t.Fatalf("%d bytes: depth %d, %d samples", width, a.BitDepth, len(a.Samples))
+		}
+		var buf bytes.Buffer
+		if err := a.Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), raw) {
+			t.Fatalf("%d bytes: file changed", width)
+		}
+	}
+}
+
+func TestDecodeAudioInvalid(t *testing.T) {
+	raw := makeWAV(2, 2, 100, 1)
+	for name, data := range map[string][]byte{
+		"no format": []byte("RIFF\x00\x00\x00\x00WAVEjunk"),
+		"truncated": raw[:30],
+		"float":     append(append(bytes.Clone(raw[:20]), 3, 0), raw[22:]...),
+	} {
+		if _, err := DecodeCarrier(bytes.NewReader(data)); err != ErrAudioNotSupported {
+			t.Errorf("%s: got %v, want %v", name, err, ErrAudioNotSupported)
+		}
+	}
+}
+
+func TestEmbedAudio(t *testing.T) {
+	for _, format := range []AudioFormat{AudioWAV, AudioFLAC} {
+		for _, width := range []int{1, 2, 3} {
+			src, err := DecodeCarrier(bytes.NewReader(makeWAV(2, width, 30000, 9)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			src.(*Audio).Format = format
+			for _, opts := range []Options{{}, {Traversal: TraversalScattered}, {Redundancy: RedundancyMedium, Layout: Layout{Bits: 1, Channels: ChannelsRGB}}, {Compression: CompressionAuto}} {
+				capacity := Capacity(src, opts)
+				payload := make([]byte, capacity-5)
+				rand.Read(payload)
+				out, err := EmbedData(src, payload, ".bin", 0, "secret1", opts)
+				if err != nil {
+					t.Fatalf("format %d, %d bytes: %v", format, width, err)
+				}
+				if _, err := EmbedData(src, append(payload, 1), ".bin", 0, "secret1", opts); err != ErrImageTooSmall {
+					t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+				}
+				var buf bytes.Buffer
+				if err := EncodeCarrier(&buf, out); err != nil {
+					t.Fatal(err)
+				}
+				back, err := DecodeCarrier(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if CarrierExtension(back) != CarrierExtension(src) {
+					t.Fatalf("written as %s", CarrierExtension(back))
+				}
+				data, ext, _, err := ExtractData(back, 0, "secret1", Options{})
+				if err != nil || ext != ".bin" || !bytes.Equal(data, payload) {
+					t.Fatalf("format %d, %d bytes, %+v: %v", format, width, opts, err)
+				}
+				// Changes stay within the low bits.
+				bits := opts.Layout.Bits
+				if bits == 0 {
+					bits = 2
+				}
+				for i, v := range back.(*Audio).Samples {
+					if d := v - src.(*Audio).Samples[i]; d >= 1<<bits || d <= -(1<<bits) {
+						t.Fatalf("sample %d changed by %d", i, d)
+					}
+				}
+				h, _, err := Inspect(back, 0, "secret1")
+				if err != nil || h.Layout.Bits != bits {
+					t.Fatalf("Inspect: %+v %v", h, err)
+				}
+			}
+		}
+	}
+}
+
+func TestAudioStreamsAndShards(t *testing.T) {
+	a, err := DecodeCarrier(bytes.NewReader(makeWAV(1, 2, 40000, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DecodeCarrier(bytes.NewReader(makeWAV(2, 2, 10000, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 12000)
+	rand.Read(payload)
+	outs, err := EmbedShards([]Carrier{a, b}, payload, ".x", "secret1", Options{Traversal: TraversalScattered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, _, err := ExtractShards([]Carrier{outs[1], outs[0]}, "secret1", Options{})
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Fatal(err)
+	}
+
+	w, err := NewEmbedWriter(a, ".s", "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload[:5000]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := ExtractTo(&buf, w.Carrier(), "secret1", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), payload[:5000]) {
+		t.Fatal("stream differs")
+	}
+
+	d, err := EmbedDeniable(a, Payload{Data: []byte("a decoy longer than the hidden one"), Password: "decoy-pass"}, Payload{Data: []byte("hidden"), Password: "hidden-pass"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pw, want := range map[string]string{"decoy-pass": "a decoy longer than the hidden one", "hidden-pass": "hidden"} {
+		got, _, _, err := ExtractData(d, 0, pw, Options{})
+		if err != nil || string(got) != want {
+			t.Fatalf("%s: %v", pw, err)
+		}
+	}
+
+}
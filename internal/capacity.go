@@ -0,0 +1,107 @@
+package internal
+
+// CapacityReport splits what carriers hold under some Options into the
+// largest payload that fits and the overhead around it, in bytes. Payload and
+// the overheads add up to Total, unless the overhead alone does not fit.
+type CapacityReport struct {
+	// Payload is the largest payload that always fits; compression can only
+	// make room for more.
+	Payload int
+	// Estimated is set when Payload is only a lower bound, see CapacityFor.
+	Estimated bool
+
+	// Total is everything the carriers hold, headers included.
+	Total int
+
+	// Header is the container header of every carrier, with its parity
+	// under error correction and the Region it records.
+	Header int
+	// Parity is what error correction adds around the body.
+	Parity int
+	// Shards is the shard record in front of every body, see EmbedShards.
+	Shards int
+	// Encryption is the salt, nonce and tag, or the recipient stanzas.
+	Encryption int
+	// Extension is the recorded extension and its length byte.
+	Extension int
+	// Archive is the archive and entry header around a file.
+	Archive int
+	// Signature is the signer key and signature when signing.
+	Signature int
+}
+
+// Free is what is left after a payload that packs into packed bytes, as
+// PayloadSize counts them; it is negative when the payload does not fit.
+func (r CapacityReport) Free(packed int) int {
+	return r.Payload + r.Extension + r.Archive + r.Signature - packed
+}
+
+// CapacityFor reports what src holds for the data of file, hidden in an
+// archive of its own as the embed tab and zuon-cli do, or for text when file
+// is nil.
+//
+// With a Region in scattered order, the header takes pixels from the region
+// that only the key decides. Without a ScatterKey the report then assumes it
+// takes as many as it can and is Estimated; with the password as ScatterKey
+// it is exact.
+func CapacityFor(src Carrier, file *FileInfo, opts Options) CapacityReport {
+	kdf := opts.kdf()
+	dst, err := carrierView(src)
+	if err != nil {
+		return CapacityReport{}
+	}
+	header, body := carrierRoom(dst, opts, kdf)
+	if body <= 0 {
+		return CapacityReport{}
+	}
+
+	r := CapacityReport{
+		Total:  header + body,
+		Header: header,
+		Parity: body - eccCapacity(body, opts.Redundancy),
+	}
+	return r.payload(kdf, file, opts)
+}
+
+// ShardCapacityFor is CapacityFor across srcs, as EmbedShards would write
+// them. Nothing fits when one of the carriers has no room for a shard.
+func ShardCapacityFor(srcs []Carrier, file *FileInfo, opts Options) CapacityReport {
+	kdf := opts.kdf()
+
+	var r CapacityReport
+	for _, src := range srcs {
+		dst, err := carrierView(src)
+		if err != nil {
+			return CapacityReport{}
+		}
+		header, body := carrierRoom(dst, opts, kdf)
+		if eccCapacity(body, opts.Redundancy) <= shardRecordSize {
+			return CapacityReport{}
+		}
+		r.Total += header + body
+		r.Header += header
+		r.Parity += body - eccCapacity(body, opts.Redundancy)
+		r.Shards += shardRecordSize
+	}
+	if len(srcs) == 0 {
+		return CapacityReport{}
+	}
+	return r.payload(kdf, file, opts)
+}
+
+// payload sets the payload overheads and what remains for the payload.
+func (r CapacityReport) payload(kdf KDF, file *FileInfo, opts Options) CapacityReport {
+	r.Encryption = kdf.overhead()
+	r.Extension = 1
+	if file != nil {
+		r.Extension += len(ArchiveExtension)
+		head, _ := appendEntryHeader(appendArchiveHeader(nil, "", 1), *file, 0)
+		r.Archive = len(head)
+	}
+	if opts.SigningKey != nil {
+		r.Signature = signatureTrailerSize
+	}
+	r.Payload = max(r.Total-r.Header-r.Parity-r.Shards-r.Encryption-r.Extension-r.Archive-r.Signature, 0)
+	r.Estimated = opts.estimated()
+	return r
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"testing"
+	"time"
+)
+
+// TestCapacityFor checks that the breakdown adds up and that Payload bytes
+// fit exactly, in every mode: Payload fits and Payload+1 does not.
+func TestCapacityFor(t *testing.T) {
+	src := halfTextured(90, 70)
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := []Options{
+		{},
+		{Layout: Layout{Bits: 1, Channels: ChannelsRGB}},
+		{Layout: Layout{Bits: 3, Channels: ChannelsRGBA}, Alpha: AlphaRaw},
+		{Redundancy: RedundancyMedium},
+		{Redundancy: RedundancyHigh, Traversal: TraversalScattered},
+		{SigningKey: key},
+		{Coding: HammingCoding(3)},
+		{Coding: CodingAdaptive},
+		{Recipients: []*Recipient{id.Recipient()}},
+	}
+	info := &FileInfo{Name: "holiday photo.jpeg", Mode: 0o644, ModTime: time.Now(), MIME: DetectMIME("holiday photo.jpeg", nil)}
+	pack := func(data []byte, file *FileInfo) ([]byte, string) {
+		if file == nil {
+			return data, ""
+		}
+		a := &Archive{Entries: []Entry{NewEntry(*file, data)}}
+		b, err := a.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b, ArchiveExtension
+	}
+	for i, opts := range sets {
+		for _, file := range []*FileInfo{nil, info} {
+			opts.Compression = CompressionNone
+			r := CapacityFor(src, file, opts)
+			if r.Payload+r.Header+r.Parity+r.Encryption+r.Extension+r.Archive+r.Signature != r.Total {
+				t.Fatalf("%d: parts do not add up: %+v", i, r)
+			}
+			if r.Payload != Capacity(src, opts)-r.Extension-r.Archive-r.Signature {
+				t.Fatalf("%d: Payload %d, Capacity %d: %+v", i, r.Payload, Capacity(src, opts), r)
+			}
+			data := make([]byte, r.Payload+1)
+			rand.Read(data)
+			fit, ext := pack(data[:r.Payload], file)
+			over, _ := pack(data, file)
+			if r.Free(PayloadSize(fit, ext, opts)) != 0 {
+				t.Fatalf("%d: Free = %d for a full payload", i, r.Free(PayloadSize(fit, ext, opts)))
+			}
+			pw := "secret1"
+			if _, err := EmbedData(src, over, ext, 0, pw, opts); err != ErrImageTooSmall {
+				t.Fatalf("%d: Payload+1: got %v, want %v", i, err, ErrImageTooSmall)
+			}
+			out, err := EmbedData(src, fit, ext, 0, pw, opts)
+			if err != nil {
+				t.Fatalf("%d: Payload: %v", i, err)
+			}
+			got, _, _, err := ExtractData(out, 0, pw, Options{Identities: []*Identity{id}})
+			if err != nil || !bytes.Equal(got, fit) {
+				t.Fatalf("%d: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestShardCapacityFor(t *testing.T) {
+	srcs := []Carrier{halfTextured(40, 30), halfTextured(50, 30)}
+	opts := Options{Compression: CompressionNone, Redundancy: RedundancyLow}
+	r := ShardCapacityFor(srcs, nil, opts)
+	if r.Payload != ShardCapacity(srcs, opts)-1 || r.Shards != 2*shardRecordSize {
+		t.Fatalf("got %+v, ShardCapacity %d", r, ShardCapacity(srcs, opts))
+	}
+	data := make([]byte, r.Payload+1)
+	rand.Read(data)
+	if _, err := EmbedShards(srcs, data, "", "secret1", opts); err != ErrImageTooSmall {
+		t.Fatalf("Payload+1: got %v, want %v", err, ErrImageTooSmall)
+	}
+	if _, err := EmbedShards(srcs, data[:r.Payload], "", "secret1", opts); err != nil {
+		t.Fatalf("Payload: %v", err)
+	}
+	if r := ShardCapacityFor(append(srcs, halfTextured(1, 1)), nil, opts); r != (CapacityReport{}) {
+		t.Fatalf("with a carrier too small for a shard: %+v", r)
+	}
+}
+
+// TestCapacityForRegion checks that a scattered region is counted exactly
+// with the scatter key and as a lower bound without it.
+func TestCapacityForRegion(t *testing.T) {
+	src := halfTextured(160, 120)
+	opts := Options{Region: RectRegion(image.Rect(20, 30, 90, 100)), Traversal: TraversalScattered, Compression: CompressionNone}
+	estimate := CapacityFor(src, nil, opts)
+	if !estimate.Estimated {
+		t.Fatalf("no scatter key but not estimated: %+v", estimate)
+	}
+	opts.ScatterKey = "secret1"
+	exact := CapacityFor(src, nil, opts)
+	if exact.Estimated || exact.Payload < estimate.Payload {
+		t.Fatalf("exact %+v, estimate %+v", exact, estimate)
+	}
+	opts.ScatterKey = ""
+	for _, n := range []int{exact.Payload, exact.Payload + 1} {
+		_, err := EmbedData(src, make([]byte, n), "", 0, "secret1", opts)
+		if (n == exact.Payload) != (err == nil) {
+			t.Fatalf("%d bytes, Payload %d: %v", n, exact.Payload, err)
+		}
+	}
+}
+
+func TestCapacityForTooSmall(t *testing.T) {
+	for _, src := range []Carrier{halfTextured(1, 1), struct{}{}} {
+		if r := CapacityFor(src, nil, Options{}); r != (CapacityReport{}) {
+			t.Fatalf("%T: got %+v", src, r)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"io"
+)
+
+// CarrierExtensions lists the file extensions DecodeCarrier reads.
+var CarrierExtensions = []string{".png", ".jpg", ".jpeg", ".bmp", ".tif", ".tiff", ".webp", ".gif", ".wav", ".flac"}
+
+// Carrier is what data is hidden in: an image.Image or an *Audio. Anything
+// else fails with ErrUnsupportedCarrier.
+type Carrier interface{}
+
+// medium is a Carrier in the form the engines work on. carrierView gives one
+// for every supported Carrier; a new kind of carrier only has to implement
+// it.
+type medium interface {
+	// carrier is what EmbedData returns for the medium.
+	carrier() Carrier
+
+	// clone returns a copy of the medium that shares no memory with it.
+	clone() medium
+
+	// room returns the size of the header opts give the medium and what the
+	// stream behind it holds, before error correction.
+	room(opts Options, kdf KDF) (int, int)
+
+	// streams returns the stream holding the header in traversal t, and a
+	// function giving the body stream behind n header bytes.
+	streams(t Traversal, key string) (stream, func(n int, h *Header) stream)
+
+	// prepare sets the medium up for embedding in place.
+	prepare(off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error)
+
+	// flags are the header flags the medium's containers always carry.
+	flags() HeaderFlags
+
+	// regions reports whether the body can be kept to a Region.
+	regions() bool
+
+	encode(w io.Writer) error
+	extension() string
+}
+
+// DecodeCarrier reads an audio file with DecodeAudio and anything else with
+// DecodeImage.
+func DecodeCarrier(r io.Reader) (Carrier, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if isWAV(data) || isFLAC(data) {
+		return DecodeAudio(bytes.NewReader(data))
+	}
+	return DecodeImage(bytes.NewReader(data))
+}
+
+// EncodeCarrier writes a result of EmbedData with Audio.Encode or
+// EncodeImage.
+func EncodeCarrier(w io.Writer, c Carrier) error {
+	m, err := carrierView(c)
+	if err != nil {
+		return err
+	}
+	return m.encode(w)
+}
+
+// CarrierExtension is the file extension EncodeCarrier writes c with.
+func CarrierExtension(c Carrier) string {
+	m, err := carrierView(c)
+	if err != nil {
+		return ""
+	}
+	return m.extension()
+}
+
+// carrierView returns src as a medium, without copying an *Audio, a *JPEG,
+// an *image.Paletted, or an *image.NRGBA or *image.NRGBA64 whose Pix holds
+// nothing but its own pixels.
+func carrierView(src Carrier) (medium, error) {
+	switch src := src.(type) {
+	case *Picture:
+		m, err := carrierView(src.Image)
+		if err != nil {
+			return nil, err
+		}
+		return pictureMedium{medium: m, p: src}, nil
+	case *Audio:
+		return audioMedium{src}, nil
+	case *JPEG:
+		return jpegMedium{src}, nil
+	case *image.Paletted:
+		return paletteMedium{src}, nil
+	case *image.NRGBA:
+		if packed(src.Pix, src.Stride, src.Rect, 4) {
+			return pixMedium{nrgba{src}}, nil
+		}
+		return pixMedium{nrgba{format(src)}}, nil
+	case *image.NRGBA64:
+		if packed(src.Pix, src.Stride, src.Rect, 8) {
+			return pixMedium{nrgba64{src}}, nil
+		}
+		return pixMedium{nrgba64{format64(src)}}, nil
+	case image.Image:
+		if is16Bit(src) {
+			return pixMedium{nrgba64{format64(src)}}, nil
+		}
+		return pixMedium{nrgba{format(src)}}, nil
+	}
+	return nil, ErrUnsupportedCarrier
+}
+
+// carrierCopy is like carrierView but never shares memory with src.
+func carrierCopy(src Carrier) (medium, error) {
+	m, err := carrierView(src)
+	if err != nil {
+		return nil, err
+	}
+	return m.clone(), nil
+}
+
+// pictureMedium keeps the Format of a *Picture around the medium of its
+// image.
+type pictureMedium struct {
+	medium
+	p *Picture
+}
+
+func (m pictureMedium) carrier() Carrier {
+	p := *m.p
+	p.Image = m.medium.carrier().(image.Image)
+	return &p
+}
+
+func (m pictureMedium) clone() medium {
+	return pictureMedium{medium: m.medium.clone(), p: m.p}
+}
+
+func (m pictureMedium) prepare(off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	c, err := m.medium.prepare(off, password, kdf, kind, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.dst = m
+	return c, nil
+}
+
+func (m pictureMedium) encode(w io.Writer) error {
+	return m.carrier().(*Picture).encode(w)
+}
+
+func (m pictureMedium) extension() string {
+	return ImageExtension(m.p)
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestUnsupportedCarrier(t *testing.T) {
+	src := struct{}{}
+	if _, err := EmbedData(src, []byte("x"), "", 0, "secret1", Options{}); err != ErrUnsupportedCarrier {
+		t.Fatalf("EmbedData: got %v, want %v", err, ErrUnsupportedCarrier)
+	}
+	if _, _, _, err := ExtractData(src, 0, "secret1", Options{}); err != ErrUnsupportedCarrier {
+		t.Fatalf("ExtractData: got %v, want %v", err, ErrUnsupportedCarrier)
+	}
+	if _, _, err := Inspect(src, 0, ""); err != ErrUnsupportedCarrier {
+		t.Fatalf("Inspect: got %v, want %v", err, ErrUnsupportedCarrier)
+	}
+	if err := EncodeCarrier(nil, src); err != ErrUnsupportedCarrier {
+		t.Fatalf("EncodeCarrier: got %v, want %v", err, ErrUnsupportedCarrier)
+	}
+	if c := Capacity(src, Options{}); c != 0 {
+		t.Fatalf("Capacity = %d", c)
+	}
+}
+
+// Every kind of carrier comes back from EmbedData as the same type, leaves
+// its source alone and survives EncodeCarrier and DecodeCarrier.
+func TestCarrierKinds(t *testing.T) {
+	audio, err := DecodeAudio(bytes.NewReader(makeWAV(2, 2, 20000, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	carriers := []struct {
+		src Carrier
+		ext string
+	}{
+		{testImage(120, 90), ".png"},
+		{testWideImages()[1], ".png"},
+		{testPaletted(120, 90), ".png"},
+		{testJPEG(t), ".jpg"},
+		{audio, ".wav"},
+		{&Picture{Image: testImage(120, 90), Format: FormatBMP}, ".bmp"},
+	}
+	data := []byte("carrier kinds")
+	for _, c := range carriers {
+		name := fmt.Sprintf("%T", c.src)
+		var before bytes.Buffer
+		if err := EncodeCarrier(&before, c.src); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		out, err := EmbedData(c.src, data, "", 0, "secret1", Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprintf("%T", out) != name {
+			t.Fatalf("%s: got %T", name, out)
+		}
+		if ext := CarrierExtension(out); ext != c.ext {
+			t.Fatalf("%s: extension: got %q, want %q", name, ext, c.ext)
+		}
+
+		var after bytes.Buffer
+		if err := EncodeCarrier(&after, c.src); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(before.Bytes(), after.Bytes()) {
+			t.Fatalf("%s: source changed", name)
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeCarrier(&buf, out); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		back, err := DecodeCarrier(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, _, _, err := ExtractData(back, 0, "secret1", Options{})
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: round trip: %v", name, err)
+		}
+	}
+}
@@ -3,9 +3,7 @@ package internal
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/pbkdf2"
 	"crypto/rand"
-	"crypto/sha256"
 	"errors"
 	"io"
 	"unicode/utf8"
@@ -18,52 +16,113 @@ func ValidatePassword(password string) error {
 	return nil
 }
 
-func Encrypt(password string, plaintext []byte) ([]byte, error) {
+// Encrypt returns salt | nonce | ciphertext, with the salt size given by kdf.
+// The ciphertext also authenticates ad, which Decrypt has to be given again.
+func Encrypt(kdf KDF, password string, plaintext, ad []byte) ([]byte, error) {
 	if err := ValidatePassword(password); err != nil {
 		return nil, err
 	}
 
-	salt := make([]byte, 8)
+	salt := make([]byte, kdf.saltSize())
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return nil, err
 	}
 
-	block, err := newCipherBlock(password, salt)
+	block, err := newCipherBlock(kdf, password, salt)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	return seal(block, salt, plaintext, ad)
+}
+
+// EncryptTo returns stanzas | nonce | ciphertext, where the stanzas carry a
+// random file key wrapped to each recipient.
+func EncryptTo(recipients []*Recipient, plaintext, ad []byte) ([]byte, error) {
+	if len(recipients) == 0 || len(recipients) > maxRecipients {
+		return nil, ErrInvalidRecipient
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, err
+	}
+
+	stanzas, err := wrapFileKey(recipients, fileKey)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
 		return nil, err
 	}
 
-	prefix := append(salt, nonce...)
-	return gcm.Seal(prefix, nonce, plaintext, nil), nil
+	return seal(block, stanzas, plaintext, ad)
 }
 
-func Decrypt(password string, fullData []byte) ([]byte, error) {
+// Decrypt opens the output of Encrypt. Payloads from v1.3 and earlier are
+// opened with LegacyKDF.
+func Decrypt(kdf KDF, password string, fullData, ad []byte) ([]byte, error) {
+	block, err := passwordBlock(kdf, password, fullData)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(block, fullData[kdf.saltSize():], ad)
+}
+
+// passwordBlock derives the block cipher that Decrypt opens fullData with.
+func passwordBlock(kdf KDF, password string, fullData []byte) (cipher.Block, error) {
 	if err := ValidatePassword(password); err != nil {
 		return nil, err
 	}
 
-	if len(fullData) < 8 {
+	saltSize := kdf.saltSize()
+	if len(fullData) < saltSize {
 		return nil, errors.New("data too short")
 	}
 
-	salt := fullData[:8]
-	ciphertext := fullData[8:]
+	return newCipherBlock(kdf, password, fullData[:saltSize])
+}
+
+// DecryptWith opens the output of EncryptTo with any identity matching one
+// of its recipients.
+func DecryptWith(kdf KDF, identities []*Identity, fullData, ad []byte) ([]byte, error) {
+	saltSize := kdf.saltSize()
+	if kdf.ID != KDFX25519 || !kdf.Valid() || len(fullData) < saltSize {
+		return nil, errors.New("data too short")
+	}
 
-	block, err := newCipherBlock(password, salt)
+	fileKey, err := unwrapFileKey(identities, fullData[:saltSize])
 	if err != nil {
 		return nil, err
 	}
 
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(block, fullData[saltSize:], ad)
+}
+
+func seal(block cipher.Block, prefix, plaintext, ad []byte) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	prefix = append(prefix, nonce...)
+	return gcm.Seal(prefix, nonce, plaintext, ad), nil
+}
+
+func open(block cipher.Block, ciphertext, ad []byte) ([]byte, error) {
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return nil, err
@@ -77,13 +136,12 @@ func Decrypt(password string, fullData []byte) ([]byte, error) {
 	nonce := ciphertext[:nonceSize]
 	actualCipher := ciphertext[nonceSize:]
 
-	return gcm.Open(nil, nonce, actualCipher, nil)
+	return gcm.Open(nil, nonce, actualCipher, ad)
 }
 
-func newCipherBlock(password string, salt []byte) (cipher.Block, error) {
-	key, err := pbkdf2.Key(sha256.New, password, salt, 4096, 32)
-	if err != nil {
-		return nil, err
+func newCipherBlock(kdf KDF, password string, salt []byte) (cipher.Block, error) {
+	if !kdf.Valid() || kdf.ID == KDFX25519 {
+		return nil, errors.New("invalid key derivation parameters")
 	}
-	return aes.NewCipher(key)
+	return aes.NewCipher(kdf.Key(password, salt))
 }
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+type Compression uint8
+
+const (
+	// CompressionAuto deflates the payload when that makes it smaller.
+	CompressionAuto Compression = iota
+	CompressionNone
+)
+
+// maxInflated bounds what a crafted image can make ExtractData allocate.
+const maxInflated = 1 << 30
+
+// compress returns the deflated form of data, or data itself with false when
+// deflating does not save anything.
+func compress(data []byte) ([]byte, bool) {
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	if err != nil {
+		return data, false
+	}
+	if _, err = w.Write(data); err != nil {
+		return data, false
+	}
+	if err = w.Close(); err != nil {
+		return data, false
+	}
+
+	if buf.Len() >= len(data) {
+		return data, false
+	}
+	return buf.Bytes(), true
+}
+
+func decompress(data []byte) ([]byte, error) {
+	return decompressLimit(data, maxInflated)
+}
+
+// decompressLimit is decompress for data that cannot inflate to more than
+// limit bytes.
+func decompressLimit(data []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, errors.New("inflated payload too large")
+	}
+	return out, nil
+}
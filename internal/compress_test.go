@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	text := bytes.Repeat([]byte("name,value\nalpha,1\n"), 2000)
+	packed, ok := compress(text)
+	if !ok || len(packed) >= len(text)/10 {
+		t.Fatalf("compressed %d bytes to %d", len(text), len(packed))
+	}
+	got, err := decompress(packed)
+	if err != nil || !bytes.Equal(got, text) {
+		t.Fatal("decompress:", err)
+	}
+
+	if _, err := decompress([]byte{0xFF, 0xFF, 0xFF}); err == nil {
+		t.Fatal("decompressed garbage")
+	}
+}
+
+func TestCompressRandom(t *testing.T) {
+	data := make([]byte, 100)
+	rand.Read(data)
+	if _, ok := compress(data); ok {
+		t.Fatal("random data compressed")
+	}
+	if n := PayloadSize(data, "", Options{}); n != 101 {
+		t.Fatalf("PayloadSize = %d, want 101", n)
+	}
+}
+
+func TestEmbedCompressed(t *testing.T) {
+	img := testImage(60, 60)
+	text := bytes.Repeat([]byte("name,value\nalpha,1\n"), 2000)
+	if PayloadSize(text, ".csv", Options{}) > Capacity(img, Options{}) {
+		t.Fatal("compressed text does not fit")
+	}
+
+	out, err := EmbedData(img, text, ".csv", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ext, _, err := ExtractData(out, 0, "secret1", Options{})
+	if err != nil || !bytes.Equal(data, text) || ext != ".csv" {
+		t.Fatal(err)
+	}
+
+	if _, err := EmbedData(img, text, ".csv", 0, "secret1", Options{Compression: CompressionNone}); err != ErrImageTooSmall {
+		t.Fatalf("uncompressed: got %v, want %v", err, ErrImageTooSmall)
+	}
+}
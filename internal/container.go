@@ -0,0 +1,297 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Container layout written in front of the ciphertext since v2:
+//
+//	magic "ZUON" | version | flags | layout | kdf | len(kdf params) | kdf params | cipher | kind | len(ciphertext) [| coding]
+//
+// The coding byte is only there with FlagCoded; its top bit marks a body
+// limited to the Region recorded right behind the header.
+// The header itself is always written with bootstrapLayout; the ciphertext
+// follows on the next pixel using the layout recorded in the header. With
+// FlagECC the header is followed by headerParitySize Reed–Solomon parity
+// bytes covering it. The ciphertext authenticates the header, see
+// associatedData.
+//
+// Images produced before the header existed (v1.3 and earlier) start directly
+// with a 4-byte big-endian ciphertext length and are read by extractLegacy.
+const (
+	containerMagic = "ZUON"
+
+	ContainerVersion uint8 = 2
+
+	headerPrefixSize = 4 + 1 + 1 + 1 + 1 + 1
+	headerSuffixSize = 1 + 1 + 4
+	headerParitySize = 16
+)
+
+// kdfParamsSizes lists the parameter lengths of every known KDF, which is
+// all a damaged header needs to be found again.
+var kdfParamsSizes = []int{4, 9, 1}
+
+type CipherID uint8
+
+const (
+	CipherAES256GCM CipherID = 1
+	// CipherAES256GCMStream seals the body in chunks, see EmbedWriter.
+	CipherAES256GCMStream CipherID = 2
+)
+
+type PayloadKind uint8
+
+const (
+	KindText PayloadKind = 1
+	KindFile PayloadKind = 2
+	// KindArchive holds several files and a note, see Archive.
+	KindArchive PayloadKind = 3
+)
+
+type HeaderFlags uint8
+
+const (
+	FlagScattered HeaderFlags = 1 << iota
+	FlagSkipTransparent
+	FlagCompressed
+	FlagSigned
+	FlagSharded
+	FlagECC
+	// FlagDCT marks a container hidden in the coefficients of a JPEG.
+	FlagDCT
+	// FlagCoded marks a header followed by the Coding of its body.
+	FlagCoded
+)
+
+const knownFlags = FlagScattered | FlagSkipTransparent | FlagCompressed | FlagSigned | FlagSharded | FlagECC | FlagDCT | FlagCoded
+
+// Coding is how the body is mapped onto the low bits of a pixel image.
+type Coding uint8
+
+const (
+	// CodingPlain writes the body bits as they are.
+	CodingPlain Coding = 0
+	// CodingAdaptive writes the body as the syndrome of the lowest bits,
+	// changing as few samples as it can and mostly in textured areas, see
+	// stcStream.
+	CodingAdaptive Coding = 1
+
+	// codingHamming is followed by k in the low four bits, see HammingCoding.
+	codingHamming Coding = 0x10
+
+	// codingRegion is set in the coding byte of masked headers.
+	codingRegion Coding = 0x80
+)
+
+// Hamming codes hide k bits in 2^k-1 for k between minHammingK and
+// maxHammingK.
+const (
+	minHammingK = 2
+	maxHammingK = 7
+)
+
+// HammingCoding returns the coding that hides every k body bits in 2^k-1 bits
+// of the stream and changes at most one of them, see hammingStream. k other
+// than 2 to 7 gives a coding that embedding rejects.
+func HammingCoding(k int) Coding {
+	if k < minHammingK || k > maxHammingK {
+		return 0xFF
+	}
+	return codingHamming | Coding(k)
+}
+
+// Hamming returns k for a Hamming coding, and 0 for anything else.
+func (c Coding) Hamming() int {
+	if k := int(c & 0x0F); c&0xF0 == codingHamming && k >= minHammingK && k <= maxHammingK {
+		return k
+	}
+	return 0
+}
+
+func (c Coding) valid() bool {
+	return c == CodingPlain || c == CodingAdaptive || c.Hamming() != 0
+}
+
+type Header struct {
+	Version   uint8
+	Flags     HeaderFlags
+	Layout    Layout
+	KDF       KDFID
+	KDFParams []byte
+	Cipher    CipherID
+	Kind      PayloadKind
+	Length    uint32
+	Coding    Coding
+
+	// Masked marks a body limited to a Region, see readRegion.
+	Masked bool
+}
+
+func (h *Header) Size() int {
+	size := headerPrefixSize + len(h.KDFParams) + headerSuffixSize + codingSize(h.Flags)
+	if h.Flags&FlagECC != 0 {
+		size += headerParitySize
+	}
+	return size
+}
+
+func (h *Header) MarshalBinary() ([]byte, error) {
+	if len(h.KDFParams) > 255 {
+		return nil, errors.New("kdf parameters too long")
+	}
+
+	out := h.marshal()
+	if h.Flags&FlagECC != 0 {
+		out = rsEncode(out, headerParitySize)
+	}
+	return out, nil
+}
+
+// associatedData is the header as the encryption of its body authenticates
+// it: without parity, and without the length, which is only known once the
+// body is sealed. A wrong length cuts the ciphertext or pads it, which fails
+// to open all the same.
+func (h *Header) associatedData() []byte {
+	c := *h
+	c.Length = 0
+	return c.marshal()
+}
+
+func (h *Header) marshal() []byte {
+	out := make([]byte, 0, h.Size())
+	out = append(out, containerMagic...)
+	out = append(out, h.Version, uint8(h.Flags), h.Layout.encode(), uint8(h.KDF), uint8(len(h.KDFParams)))
+	out = append(out, h.KDFParams...)
+	out = append(out, uint8(h.Cipher), uint8(h.Kind))
+	out = binary.BigEndian.AppendUint32(out, h.Length)
+	if h.Flags&FlagCoded != 0 {
+		coding := h.Coding
+		if h.Masked {
+			coding |= codingRegion
+		}
+		out = append(out, uint8(coding))
+	}
+	return out
+}
+
+func (h *Header) validate() error {
+	if h.Version != ContainerVersion {
+		return ErrUnsupportedFormat
+	}
+	if h.Flags&^knownFlags != 0 || !h.Layout.valid(maxWideBits) {
+		return ErrUnsupportedFormat
+	}
+	// Only pixel bodies are coded or masked, adaptively with one bit per
+	// sample.
+	if h.Flags&FlagCoded != 0 && (h.Coding == CodingPlain && !h.Masked || !h.Coding.valid() || h.Flags&FlagDCT != 0) {
+		return ErrUnsupportedFormat
+	}
+	if h.Coding == CodingAdaptive && h.Layout.Bits != 1 {
+		return ErrUnsupportedFormat
+	}
+	switch h.Cipher {
+	case CipherAES256GCM:
+	case CipherAES256GCMStream:
+		// Streams are written in one pass, which rules out everything that
+		// needs the whole body first.
+		if h.Flags&(FlagCompressed|FlagECC|FlagSharded) != 0 || h.Coding == CodingAdaptive {
+			return ErrUnsupportedFormat
+		}
+	default:
+		return ErrUnsupportedFormat
+	}
+	if h.Kind != KindText && h.Kind != KindFile && h.Kind != KindArchive {
+		return ErrUnsupportedFormat
+	}
+	if _, err := h.kdf(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (h *Header) kdf() (KDF, error) {
+	return parseKDF(h.KDF, h.KDFParams)
+}
+
+func newHeader(layout Layout, kdf KDF, kind PayloadKind, length int) *Header {
+	return &Header{
+		Version:   ContainerVersion,
+		Layout:    layout,
+		KDF:       kdf.ID,
+		KDFParams: kdf.params(),
+		Cipher:    CipherAES256GCM,
+		Kind:      kind,
+		Length:    uint32(length),
+	}
+}
+
+// readHeader returns errNoHeader when the magic is missing, so callers can
+// fall back to the legacy layout.
+func readHeader(op stream, off int) (*Header, error) {
+	prefix, err := op.UnEmbed(headerPrefixSize, off)
+	if err != nil {
+		return nil, errNoHeader
+	}
+
+	if string(prefix[:4]) == containerMagic && HeaderFlags(prefix[5])&FlagECC == 0 {
+		raw, err := op.UnEmbed(headerPrefixSize+int(prefix[8])+headerSuffixSize+codingSize(HeaderFlags(prefix[5])), off)
+		if err != nil {
+			return nil, ErrDataNotFound
+		}
+		return parseHeader(raw), nil
+	}
+
+	// A protected header may be damaged anywhere, magic included, so try to
+	// repair it for every parameter length a KDF can have, with and without
+	// a coding byte.
+	for _, n := range kdfParamsSizes {
+		for _, coded := range []HeaderFlags{0, FlagCoded} {
+			raw, err := op.UnEmbed(headerPrefixSize+n+headerSuffixSize+codingSize(coded)+headerParitySize, off)
+			if err != nil {
+				continue
+			}
+
+			msg, err := rsDecode(raw, headerParitySize)
+			if err != nil || string(msg[:4]) != containerMagic || int(msg[8]) != n {
+				continue
+			}
+			if flags := HeaderFlags(msg[5]); flags&FlagECC == 0 || flags&FlagCoded != coded {
+				continue
+			}
+			return parseHeader(msg), nil
+		}
+	}
+	return nil, errNoHeader
+}
+
+// parseHeader decodes a header whose length has already been checked.
+func parseHeader(raw []byte) *Header {
+	paramsLen := int(raw[8])
+	rest := raw[headerPrefixSize+paramsLen:]
+	h := &Header{
+		Version:   raw[4],
+		Flags:     HeaderFlags(raw[5]),
+		Layout:    decodeLayout(raw[6]),
+		KDF:       KDFID(raw[7]),
+		KDFParams: raw[headerPrefixSize : headerPrefixSize+paramsLen],
+		Cipher:    CipherID(rest[0]),
+		Kind:      PayloadKind(rest[1]),
+		Length:    binary.BigEndian.Uint32(rest[2:]),
+	}
+	if h.Flags&FlagCoded != 0 {
+		h.Coding, h.Masked = Coding(rest[6])&^codingRegion, rest[6]&uint8(codingRegion) != 0
+	}
+	return h
+}
+
+// codingSize is the size of the coding byte of a header with flags.
+func codingSize(flags HeaderFlags) int {
+	if flags&FlagCoded != 0 {
+		return 1
+	}
+	return 0
+}
+
+var errNoHeader = errors.New("container header not found")
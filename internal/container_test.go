@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+// headerStream returns a stream holding raw followed by zeros.
+func headerStream(t testing.TB, raw []byte) stream {
+	op := NewPixOperator(make([]byte, 2*(len(raw)+512)), Layout{Bits: 4, Channels: ChannelsRGBA})
+	if err := op.Embed(raw, 0); err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func testHeaders() []*Header {
+	plain := newHeader(Layout{Bits: 2, Channels: ChannelsRGB}, DefaultKDF, KindFile, 1234)
+
+	protected := newHeader(Layout{Bits: 1, Channels: ChannelsRGBA}, LegacyKDF, KindArchive, 99)
+	protected.Flags = FlagECC | FlagCompressed | FlagCoded
+	protected.Coding = HammingCoding(3)
+	protected.Masked = true
+
+	return []*Header{plain, protected}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	for _, h := range testHeaders() {
+		raw, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != h.Size() {
+			t.Fatalf("marshaled %d bytes, Size is %d", len(raw), h.Size())
+		}
+
+		got, err := readHeader(headerStream(t, raw), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := got.validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Fatalf("got %+v, want %+v", got, h)
+		}
+	}
+}
+
+func TestHeaderRepair(t *testing.T) {
+	h := testHeaders()[1]
+	raw, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parity repairs damage anywhere, magic included.
+	raw[0] ^= 0xFF
+	raw[7] ^= 0x55
+	got, err := readHeader(headerStream(t, raw), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Fatalf("got %+v, want %+v", got, h)
+	}
+}
+
+func TestHeaderMissing(t *testing.T) {
+	if _, err := readHeader(headerStream(t, nil), 0); err != errNoHeader {
+		t.Fatalf("got %v, want %v", err, errNoHeader)
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	for name, change := range map[string]func(*Header){
+		"version": func(h *Header) { h.Version++ },
+		"flags":   func(h *Header) { h.Flags |= 0x80 },
+		"cipher":  func(h *Header) { h.Cipher = 0xEE },
+		"kind":    func(h *Header) { h.Kind = 0 },
+		"kdf":     func(h *Header) { h.KDFParams = h.KDFParams[:1] },
+		"stream":  func(h *Header) { h.Cipher, h.Flags = CipherAES256GCMStream, FlagCompressed },
+	} {
+		h := testHeaders()[0]
+		change(h)
+		if err := h.validate(); err == nil {
+			t.Errorf("%s: invalid header accepted", name)
+		}
+	}
+}
+
+// TestHeaderAuthenticated checks that the body's encryption covers the
+// header, so a field changed in place fails to open.
+func TestHeaderAuthenticated(t *testing.T) {
+	out, err := EmbedData(testImage(80, 80), []byte("hello world"), ".txt", 0, "secret1", Options{Traversal: TraversalSequential, Compression: CompressionNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := out.(*image.NRGBA)
+
+	op := newHeaderOperator(img.Pix, false)
+	h, err := readHeader(op, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Kind = KindText
+	raw, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Embed(raw, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := ExtractData(img, 0, "secret1", Options{}); err != ErrDecryptionFailed {
+		t.Fatalf("got %v, want %v", err, ErrDecryptionFailed)
+	}
+}
+
+func FuzzReadHeader(f *testing.F) {
+	for _, h := range testHeaders() {
+		raw, err := h.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		h, err := readHeader(headerStream(t, raw), 0)
+		if err != nil || h.validate() != nil {
+			return
+		}
+
+		// A header that reads and validates survives being written again.
+		again, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readHeader(headerStream(t, again), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Fatalf("got %+v, want %+v", got, h)
+		}
+	})
+}
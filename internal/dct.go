@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"errors"
+	"io"
+)
+
+// dctLayout is recorded in the header of JPEG carriers, where every usable
+// coefficient carries exactly one bit.
+var dctLayout = Layout{Bits: 1, Channels: ChannelsRGB}
+
+// DCTOperator hides bits in the parity of the quantized AC coefficients whose
+// magnitude is at least 2. Like F5 it mostly shrinks magnitudes, but a 2 is
+// raised to 3 instead of becoming 1, so no coefficient ever enters or leaves
+// the usable set and extraction finds exactly the positions embedding used.
+type DCTOperator struct {
+	coef   []int16
+	usable []int32
+
+	// order maps the i-th visited position to an index in usable; nil
+	// visits coefficients in file order.
+	order *scatter
+
+	// start is the first position of the stream, past any header.
+	start int
+}
+
+func NewDCTOperator(j *JPEG) *DCTOperator {
+	op := &DCTOperator{coef: j.coef}
+	for i, c := range j.coef {
+		if i%64 != 0 && (c >= 2 || c <= -2) {
+			op.usable = append(op.usable, int32(i))
+		}
+	}
+	return op
+}
+
+func newDCTOperator(j *JPEG, t Traversal, key string) *DCTOperator {
+	op := NewDCTOperator(j)
+	if t == TraversalScattered {
+		op.Scatter(scatterSeed(key))
+	}
+	return op
+}
+
+func (d *DCTOperator) Scatter(seed []byte) {
+	d.order = newScatter(seed, len(d.usable))
+}
+
+// After returns the stream that follows the first n bytes of d.
+func (d *DCTOperator) After(n int) *DCTOperator {
+	after := *d
+	after.start += n * 8
+	return &after
+}
+
+func (d *DCTOperator) Capacity() int {
+	return max(len(d.usable)-d.start, 0) / 8
+}
+
+func (d *DCTOperator) index(pos int) int {
+	pos += d.start
+	if d.order != nil {
+		pos = d.order.At(pos)
+	}
+	return int(d.usable[pos])
+}
+
+func (d *DCTOperator) Embed(data []byte, off int) error {
+	if off < 0 || off+len(data) > d.Capacity() {
+		return errors.New("out of bounds")
+	}
+
+	pos := off * 8
+	for _, v := range data {
+		for i := 7; i >= 0; i-- {
+			idx := d.index(pos)
+			pos++
+
+			c := d.coef[idx]
+			if parity(c) == v>>i&1 {
+				continue
+			}
+			switch {
+			case c == 2:
+				c = 3
+			case c == -2:
+				c = -3
+			case c > 0:
+				c--
+			default:
+				c++
+			}
+			d.coef[idx] = c
+		}
+	}
+	return nil
+}
+
+func (d *DCTOperator) UnEmbed(n int, off int) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > d.Capacity() {
+		return nil, errors.New("out of bounds")
+	}
+
+	pos := off * 8
+	out := make([]byte, n)
+	for i := range out {
+		var v byte
+		for j := 0; j < 8; j++ {
+			v = v<<1 | parity(d.coef[d.index(pos)])
+			pos++
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// Writer and Reader walk the stream from byte off onwards; coefficients are
+// addressed directly, so they simply keep the offset.
+func (d *DCTOperator) Writer(off int) io.Writer {
+	return &offsetStream{s: d, off: off}
+}
+
+func (d *DCTOperator) Reader(off int) io.Reader {
+	return &offsetStream{s: d, off: off}
+}
+
+func parity(c int16) byte {
+	if c < 0 {
+		c = -c
+	}
+	return byte(c & 1)
+}
+
+// jpegMedium embeds in the DCT coefficients of a *JPEG.
+type jpegMedium struct{ *JPEG }
+
+func (j jpegMedium) carrier() Carrier {
+	return j.JPEG
+}
+
+func (j jpegMedium) clone() medium {
+	return jpegMedium{j.JPEG.clone()}
+}
+
+func (j jpegMedium) room(opts Options, kdf KDF) (int, int) {
+	h := newHeader(dctLayout, kdf, KindFile, 0)
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+	return h.Size(), NewDCTOperator(j.JPEG).After(h.Size()).Capacity()
+}
+
+func (j jpegMedium) streams(t Traversal, key string) (stream, func(n int, h *Header) stream) {
+	op := newDCTOperator(j.JPEG, t, key)
+	return op, func(n int, h *Header) stream {
+		return op.After(n)
+	}
+}
+
+func (j jpegMedium) prepare(off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	op := newDCTOperator(j.JPEG, opts.Traversal, opts.scatterKey(password))
+
+	h := newHeader(dctLayout, kdf, kind, 0)
+	h.Flags |= FlagDCT
+	if opts.Traversal == TraversalScattered {
+		h.Flags |= FlagScattered
+	}
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+
+	c := &carrier{dst: j, op: op, body: op.After(off + h.Size()), header: h, redundancy: opts.Redundancy}
+	if c.capacity() <= 0 {
+		return nil, ErrImageNotSupported
+	}
+	return c, nil
+}
+
+func (j jpegMedium) flags() HeaderFlags {
+	return FlagDCT
+}
+
+func (j jpegMedium) regions() bool {
+	return false
+}
+
+func (j jpegMedium) encode(w io.Writer) error {
+	return j.Encode(w)
+}
+
+func (j jpegMedium) extension() string {
+	return ".jpg"
+}
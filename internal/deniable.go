@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/big"
+)
+
+// A deniable carrier holds a decoy container followed by free space, which
+// is read as a ring that a blob starts at somewhere in:
+//
+//	nonce | sealed(u32 length) | nonce | sealed(flags | payload) | padding
+//
+// The blob is sealed with the key of the hidden password and the salt of the
+// decoy, padded to a random length no larger than the decoy, and starts at a
+// position that key picks. Without a hidden payload a blob of noise drawn
+// from the same lengths starts at a random position instead. Nothing records the hidden payload, which can only be told apart
+// from the noise by decrypting it; openContainer looks for it with the key
+// its password made for the decoy, so a wrong password costs one key
+// derivation either way.
+
+// Payload is data hidden under its own password.
+type Payload struct {
+	Data      []byte
+	Extension string
+	Password  string
+}
+
+// hiddenTrailerSize is the nonce, sealed length and tag that a hidden blob
+// starts with.
+const hiddenTrailerSize = 12 + 4 + 16
+
+// hiddenLabel is encrypted with the hidden key to pick where its blob starts.
+var hiddenLabel = []byte("zuon hidden ring")
+
+// HiddenSize returns how many bytes of Capacity the hidden payload of
+// EmbedDeniable uses; the decoy takes PayloadSize as usual.
+func HiddenSize(data []byte, extension string, opts Options) int {
+	plaintext, _, err := pack(data, extension, opts)
+	if err != nil {
+		return 0
+	}
+	return 1 + len(plaintext) + 12 + 16 + hiddenTrailerSize
+}
+
+// EmbedDeniable hides decoy like EmbedData would and writes hidden into the
+// free space behind it, so either password extracts its own payload through
+// ExtractData and the decoy password shows nothing else. hidden may be left
+// without a password to write noise in its place, so that a carrier without a
+// hidden payload looks like one with it. Noise is no larger than the decoy,
+// and a hidden payload that would be fails with ErrHiddenTooLarge.
+//
+// The decoy is always written in pixel order and never adaptively, since the
+// hidden payload has to be found behind its header without the decoy
+// password. Recipients cannot be used.
+func EmbedDeniable(src Carrier, decoy, hidden Payload, opts Options) (Carrier, error) {
+	if len(opts.Recipients) > 0 {
+		return nil, ErrInvalidRecipient
+	}
+	if hidden.Password != "" && hidden.Password == decoy.Password {
+		return nil, ErrSamePassword
+	}
+	opts.Traversal = TraversalSequential
+	if opts.Coding == CodingAdaptive {
+		opts.Coding = CodingPlain
+	}
+
+	kdf, err := opts.checkKDF()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := prepareCarrier(src, 0, decoy.Password, kdf, kindOf(decoy.Extension), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, flags, err := pack(decoy.Data, decoy.Extension, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.header.Flags |= flags
+	body, err := encryptPayload(kdf, decoy.Password, plaintext, c.header.associatedData(), opts)
+	if err != nil {
+		return nil, err
+	}
+	salt := body[:kdf.saltSize()]
+	if c.redundancy != RedundancyNone {
+		body = eccEncode(body, c.redundancy)
+	}
+
+	ring := hiddenRing{body: c.body, base: len(body), size: c.body.Capacity() - len(body)}
+	lo, hi := blobBounds(ring.size, len(body))
+	var blob []byte
+	if hidden.Password != "" {
+		block, sealed, err := sealHidden(kdf, hidden, salt, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(sealed) > ring.size {
+			return nil, ErrImageTooSmall
+		}
+		if len(sealed) > hi {
+			return nil, ErrHiddenTooLarge
+		}
+		if blob, err = padBlob(sealed, lo, hi); err != nil {
+			return nil, err
+		}
+		ring.start = ringStart(block, ring.size)
+	} else if ring.size > 0 {
+		if blob, err = padBlob(nil, lo, hi); err != nil {
+			return nil, err
+		}
+		start, err := rand.Int(rand.Reader, big.NewInt(int64(ring.size)))
+		if err != nil {
+			return nil, ErrInternal
+		}
+		ring.start = int(start.Int64())
+	}
+
+	if err := c.body.Embed(body, 0); err != nil {
+		return nil, ErrInternal
+	}
+	if err := ring.write(0, blob); err != nil {
+		return nil, ErrInternal
+	}
+	if err := c.writeHeader(0, flags, len(body)); err != nil {
+		return nil, err
+	}
+	return c.dst.carrier(), nil
+}
+
+// blobBounds returns the shortest and longest blob written in free bytes
+// behind a decoy body of the given size. Hidden payloads longer than the
+// decoy are refused, since noise never is.
+func blobBounds(free, decoy int) (int, int) {
+	lo := min(hiddenTrailerSize+12+16+2, free)
+	return lo, max(lo, min(free, decoy))
+}
+
+// padBlob pads blob with random bytes to a length drawn evenly from what is
+// left of lo to hi, so noise and hidden payloads take the same range of
+// sizes.
+func padBlob(blob []byte, lo, hi int) ([]byte, error) {
+	lo = max(lo, len(blob))
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(hi-lo+1)))
+	if err != nil {
+		return nil, ErrInternal
+	}
+	pad := make([]byte, lo+int(n.Int64())-len(blob))
+	if _, err := io.ReadFull(rand.Reader, pad); err != nil {
+		return nil, ErrInternal
+	}
+	return append(blob, pad...), nil
+}
+
+// sealHidden seals p under the key of its password and salt, and returns
+// that key's block with the blob.
+func sealHidden(kdf KDF, p Payload, salt []byte, opts Options) (cipher.Block, []byte, error) {
+	if ValidatePassword(p.Password) != nil {
+		return nil, nil, ErrPasswordShort
+	}
+
+	plaintext, flags, err := pack(p.Data, p.Extension, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := newCipherBlock(kdf, p.Password, salt)
+	if err != nil {
+		return nil, nil, ErrInternal
+	}
+
+	box, err := seal(block, nil, append([]byte{byte(flags)}, plaintext...), nil)
+	if err != nil {
+		return nil, nil, ErrInternal
+	}
+	trailer, err := seal(block, nil, binary.BigEndian.AppendUint32(nil, uint32(len(box))), nil)
+	if err != nil {
+		return nil, nil, ErrInternal
+	}
+	return block, append(trailer, box...), nil
+}
+
+// openHidden looks for a hidden payload behind the body of header with
+// block, the key its password made from the decoy's salt. It fails with
+// ErrDecryptionFailed when that key opens none.
+func openHidden(header *Header, body stream, block cipher.Block, opts Options) ([]byte, string, Verification, error) {
+	ring := hiddenRing{body: body, base: int(header.Length), size: body.Capacity() - int(header.Length)}
+	if ring.size < hiddenTrailerSize {
+		return nil, "", Verification{}, ErrDecryptionFailed
+	}
+	ring.start = ringStart(block, ring.size)
+
+	trailer, err := ring.read(0, hiddenTrailerSize)
+	if err != nil {
+		return nil, "", Verification{}, ErrDecryptionFailed
+	}
+	length, err := open(block, trailer, nil)
+	if err != nil || len(length) != 4 {
+		return nil, "", Verification{}, ErrDecryptionFailed
+	}
+
+	n := int(binary.BigEndian.Uint32(length))
+	if n > ring.size-hiddenTrailerSize {
+		return nil, "", Verification{}, ErrInternal
+	}
+	box, err := ring.read(hiddenTrailerSize, n)
+	if err != nil {
+		return nil, "", Verification{}, ErrDataNotFound
+	}
+	plaintext, err := open(block, box, nil)
+	if err != nil || len(plaintext) < 1 {
+		return nil, "", Verification{}, ErrDecryptionFailed
+	}
+	return unpack(plaintext[1:], HeaderFlags(plaintext[0]), opts)
+}
+
+// ringStart picks where the blob sealed with block starts in a ring of size
+// bytes.
+func ringStart(block cipher.Block, size int) int {
+	seed := make([]byte, block.BlockSize())
+	block.Encrypt(seed, hiddenLabel)
+	return int(binary.BigEndian.Uint64(seed) % uint64(size))
+}
+
+// hiddenRing reads and writes the size bytes of body from base on as a ring
+// that starts at start.
+type hiddenRing struct {
+	body       stream
+	base, size int
+	start      int
+}
+
+func (r hiddenRing) read(off, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for n > 0 {
+		pos := (r.start + off) % r.size
+		k := min(n, r.size-pos)
+		part, err := r.body.UnEmbed(k, r.base+pos)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, part...)
+		off, n = off+k, n-k
+	}
+	return out, nil
+}
+
+func (r hiddenRing) write(off int, data []byte) error {
+	for len(data) > 0 {
+		pos := (r.start + off) % r.size
+		k := min(len(data), r.size-pos)
+		if err := r.body.Embed(data[:k], r.base+pos); err != nil {
+			return err
+		}
+		off, data = off+k, data[k:]
+	}
+	return nil
+}
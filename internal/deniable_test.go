@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"testing"
+)
+
+func TestEmbedDeniable(t *testing.T) {
+	// The decoy has to be at least as large as the hidden payload.
+	decoy := Payload{Data: make([]byte, 1000), Password: "decoy123"}
+	rand.Read(decoy.Data)
+	hidden := Payload{Data: bytes.Repeat([]byte("real secret "), 50), Extension: ".txt", Password: "hidden123"}
+
+	for _, opts := range []Options{{}, {Traversal: TraversalScattered, Redundancy: RedundancyLow}} {
+		img := testImage(120, 120)
+		out, err := EmbedDeniable(img, decoy, hidden, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, ext, _, err := ExtractData(out, 0, "decoy123", Options{})
+		if err != nil || !bytes.Equal(data, decoy.Data) || ext != "" {
+			t.Fatalf("decoy: %v", err)
+		}
+		data, ext, _, err = ExtractData(out, 0, "hidden123", Options{})
+		if err != nil || !bytes.Equal(data, hidden.Data) || ext != ".txt" {
+			t.Fatalf("hidden: %v", err)
+		}
+		if _, _, _, err := ExtractData(out, 0, "wrong123", Options{}); err != ErrDecryptionFailed {
+			t.Fatalf("wrong password: got %v, want %v", err, ErrDecryptionFailed)
+		}
+
+		// Without a hidden payload the free space holds noise, which no
+		// password opens.
+		noise, err := EmbedDeniable(img, decoy, Payload{}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := ExtractData(noise, 0, "hidden123", Options{}); err != ErrDecryptionFailed {
+			t.Fatalf("noise: got %v, want %v", err, ErrDecryptionFailed)
+		}
+	}
+}
+
+func TestEmbedDeniableInvalid(t *testing.T) {
+	img := testImage(40, 40)
+	decoy := Payload{Data: []byte("a"), Password: "decoy123"}
+	if _, err := EmbedDeniable(img, decoy, Payload{Data: make([]byte, 2000), Password: "hidden123"}, Options{Compression: CompressionNone}); err != ErrImageTooSmall {
+		t.Fatalf("too large: got %v, want %v", err, ErrImageTooSmall)
+	}
+	if _, err := EmbedDeniable(img, decoy, Payload{Password: "decoy123"}, Options{}); err != ErrSamePassword {
+		t.Fatalf("same password: got %v, want %v", err, ErrSamePassword)
+	}
+
+	// Noise is never larger than the decoy, so a hidden payload may not be
+	// either.
+	large := Payload{Data: make([]byte, 100), Password: "hidden123"}
+	if _, err := EmbedDeniable(testImage(120, 120), decoy, large, Options{Compression: CompressionNone}); err != ErrHiddenTooLarge {
+		t.Fatalf("larger than the decoy: got %v, want %v", err, ErrHiddenTooLarge)
+	}
+	decoy.Data = make([]byte, 150)
+	if _, err := EmbedDeniable(testImage(120, 120), decoy, large, Options{Compression: CompressionNone}); err != nil {
+		t.Fatalf("smaller than the decoy: %v", err)
+	}
+}
+
+func TestPadBlob(t *testing.T) {
+	lo, hi := blobBounds(1000, 200)
+	if lo != hiddenTrailerSize+12+16+2 || hi != 200 {
+		t.Fatalf("bounds: got %d, %d", lo, hi)
+	}
+	if lo, hi := blobBounds(1000, 10); hi != lo {
+		t.Fatalf("small decoy: got %d, %d", lo, hi)
+	}
+	if lo, hi := blobBounds(20, 200); lo != 20 || hi != 20 {
+		t.Fatalf("small ring: got %d, %d", lo, hi)
+	}
+
+	sealed := bytes.Repeat([]byte{7}, 150)
+	lengths := map[int]bool{}
+	for i := 0; i < 200; i++ {
+		noise, err := padBlob(nil, lo, hi)
+		if err != nil || len(noise) < lo || len(noise) > hi {
+			t.Fatalf("noise of %d bytes: %v", len(noise), err)
+		}
+		lengths[len(noise)] = true
+
+		blob, err := padBlob(append([]byte(nil), sealed...), lo, hi)
+		if err != nil || len(blob) < len(sealed) || len(blob) > hi || !bytes.Equal(blob[:len(sealed)], sealed) {
+			t.Fatalf("blob of %d bytes: %v", len(blob), err)
+		}
+	}
+	if len(lengths) < 10 {
+		t.Fatalf("noise took only %d lengths", len(lengths))
+	}
+}
+
+func TestHiddenSize(t *testing.T) {
+	// A flags byte, the extension length, a nonce and a tag come on top of
+	// the data and the trailer.
+	if got, want := HiddenSize([]byte("x"), "", Options{Compression: CompressionNone}), 1+2+28+hiddenTrailerSize; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+}
+
+// TestDeniableTouched checks that neither a hidden payload nor the noise
+// standing in for one fills the free space.
+func TestDeniableTouched(t *testing.T) {
+	img := testImage(300, 300)
+	for _, hidden := range []Payload{{}, {Data: []byte("short secret"), Password: "hidden123"}} {
+		out, err := EmbedDeniable(img, Payload{Data: []byte("a decoy longer than the short secret behind it"), Password: "decoy123"}, hidden, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed := 0
+		for i, b := range out.(*image.NRGBA).Pix {
+			if b != img.Pix[i] {
+				changed++
+			}
+		}
+		if changed > len(img.Pix)/20 {
+			t.Fatalf("%d of %d bytes changed", changed, len(img.Pix))
+		}
+	}
+}
+
+func TestEmbedDeniableJPEG(t *testing.T) {
+	img, err := DecodeImage(bytes.NewReader(jpegBytes(t, testImage(320, 240), 92)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := EmbedDeniable(img, Payload{Data: []byte("a decoy longer than the hidden one"), Password: "decoy123"}, Payload{Data: []byte("h"), Password: "hidden123"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeCarrier(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	back, err := DecodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _, _, err := ExtractData(back, 0, "hidden123", Options{}); err != nil || string(data) != "h" {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package internal
+
+// Redundancy is the number of Reed–Solomon parity bytes in every 255-byte
+// codeword of the body. Each codeword survives up to Redundancy/2 damaged
+// bytes.
+type Redundancy uint8
+
+const (
+	RedundancyNone   Redundancy = 0
+	RedundancyLow    Redundancy = 16
+	RedundancyMedium Redundancy = 32
+	RedundancyHigh   Redundancy = 64
+
+	maxRedundancy = 128
+)
+
+// The parity count is stored three times in front of the codewords and read
+// back by majority, since nothing else protects it.
+const eccPrefixSize = 3
+
+func (r Redundancy) valid() bool {
+	return r <= maxRedundancy
+}
+
+// eccCodewords returns how many codewords carry n data bytes, and the data
+// length of each.
+func eccCodewords(n, nsym int) []int {
+	k := 255 - nsym
+	count := (n + k - 1) / k
+	if count == 0 {
+		count = 1
+	}
+
+	lengths := make([]int, count)
+	for i := range lengths {
+		lengths[i] = n / count
+		if i < n%count {
+			lengths[i]++
+		}
+	}
+	return lengths
+}
+
+// eccEncode splits data into codewords and interleaves them byte by byte, so
+// damage to neighbouring bytes lands in different codewords.
+func eccEncode(data []byte, r Redundancy) []byte {
+	nsym := int(r)
+	lengths := eccCodewords(len(data), nsym)
+
+	codewords := make([][]byte, len(lengths))
+	for i, n := range lengths {
+		codewords[i] = rsEncode(data[:n], nsym)
+		data = data[n:]
+	}
+
+	out := []byte{byte(r), byte(r), byte(r)}
+	return append(out, interleave(codewords)...)
+}
+
+func eccDecode(encoded []byte) ([]byte, error) {
+	if len(encoded) < eccPrefixSize {
+		return nil, ErrPayloadDamaged
+	}
+
+	a, b, c := encoded[0], encoded[1], encoded[2]
+	r := Redundancy(a&b | a&c | b&c)
+	if r == RedundancyNone || !r.valid() {
+		return nil, ErrPayloadDamaged
+	}
+
+	encoded = encoded[eccPrefixSize:]
+	nsym := int(r)
+	count := (len(encoded) + 254) / 255
+	n := len(encoded) - count*nsym
+	if n <= 0 {
+		return nil, ErrPayloadDamaged
+	}
+
+	lengths := eccCodewords(n, nsym)
+	if len(lengths) != count {
+		return nil, ErrPayloadDamaged
+	}
+
+	codewords := make([][]byte, count)
+	for i, l := range lengths {
+		codewords[i] = make([]byte, l+nsym)
+	}
+	deinterleave(encoded, codewords)
+
+	out := make([]byte, 0, n)
+	for _, cw := range codewords {
+		msg, err := rsDecode(cw, nsym)
+		if err != nil {
+			return nil, ErrPayloadDamaged
+		}
+		out = append(out, msg...)
+	}
+	return out, nil
+}
+
+// eccCapacity is how many data bytes fit in n encoded bytes.
+func eccCapacity(n int, r Redundancy) int {
+	if r == RedundancyNone {
+		return n
+	}
+
+	n -= eccPrefixSize
+	if n <= 0 {
+		return 0
+	}
+	return max(n-(n+254)/255*int(r), 0)
+}
+
+func interleave(codewords [][]byte) []byte {
+	var out []byte
+	for j := 0; ; j++ {
+		wrote := false
+		for _, cw := range codewords {
+			if j < len(cw) {
+				out = append(out, cw[j])
+				wrote = true
+			}
+		}
+		if !wrote {
+			return out
+		}
+	}
+}
+
+func deinterleave(data []byte, codewords [][]byte) {
+	for j := 0; len(data) > 0; j++ {
+		for _, cw := range codewords {
+			if j < len(cw) && len(data) > 0 {
+				cw[j] = data[0]
+				data = data[1:]
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestECC(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 100, 239, 240, 241, 1000, 5000} {
+		data := make([]byte, n)
+		r.Read(data)
+
+		enc := eccEncode(data, RedundancyLow)
+		if c := eccCapacity(len(enc), RedundancyLow); c < n {
+			t.Fatalf("%d bytes encode to %d, which holds only %d", n, len(enc), c)
+		}
+		got, err := eccDecode(enc)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+	}
+}
+
+func TestECCDamaged(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	enc := eccEncode(data, RedundancyLow)
+
+	// Interleaving spreads a run of damage over every codeword.
+	damaged := bytes.Clone(enc)
+	for i := 100; i < 100+8*len(enc)/255; i++ {
+		damaged[i] ^= 0xFF
+	}
+	// The parity count is read by majority.
+	damaged[1] = 0
+	got, err := eccDecode(damaged)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal(err)
+	}
+
+	for name, bad := range map[string][]byte{
+		"empty":     nil,
+		"prefix":    enc[:eccPrefixSize],
+		"truncated": enc[:len(enc)-40],
+		"no parity": append([]byte{0, 0, 0}, enc[eccPrefixSize:]...),
+		"overrun":   append(bytes.Clone(enc[:100]), make([]byte, len(enc)-100)...),
+	} {
+		if _, err := eccDecode(bad); err != ErrPayloadDamaged {
+			t.Errorf("%s: got %v, want %v", name, err, ErrPayloadDamaged)
+		}
+	}
+}
+
+// damageBody flips the bytes of a container body from off on, limited to n
+// when n is not negative, leaving the header alone.
+func damageBody(t *testing.T, img Carrier, off, n int) {
+	m, err := carrierView(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := findContainer(m, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := body.UnEmbed(body.Capacity(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 0 {
+		n = len(data) - off
+	}
+	for i := off; i < off+n; i++ {
+		data[i] ^= 0x5A
+	}
+	if err := body.Embed(data, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmbedECC(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, red := range []Redundancy{RedundancyLow, RedundancyMedium, RedundancyHigh} {
+		img := testImage(120, 120)
+		opts := Options{Redundancy: red, Compression: CompressionNone}
+		data := make([]byte, Capacity(img, opts)-1)
+		r.Read(data)
+		if _, err := EmbedData(img, append(data, 1), "", 0, "secret1", opts); err != ErrImageTooSmall {
+			t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+		}
+
+		for _, tr := range []Traversal{TraversalSequential, TraversalScattered} {
+			opts.Traversal = tr
+			out, err := EmbedData(img, data, "", 0, "secret1", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Interleaving spreads a run over the codewords, so each loses
+			// fewer bytes than half its parity.
+			codewords := len(data) / 255
+			damageBody(t, out, eccPrefixSize, codewords*(int(red)/2-1))
+			got, _, _, err := ExtractData(out, 0, "secret1", Options{})
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("redundancy %d %v: %v", red, tr, err)
+			}
+
+			damageBody(t, out, eccPrefixSize, -1)
+			if _, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != ErrPayloadDamaged {
+				t.Fatalf("redundancy %d %v: wrecked body: got %v, want %v", red, tr, err, ErrPayloadDamaged)
+			}
+		}
+	}
+}
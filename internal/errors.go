@@ -3,11 +3,28 @@ package internal
 import "errors"
 
 var (
-	ErrImageNotSupported = errors.New("err_image_not_supported")
-	ErrImageTooSmall     = errors.New("err_image_too_small")
-	ErrDataNotFound      = errors.New("err_data_not_found")
-	ErrDecryptionFailed  = errors.New("err_decryption_failed")
-	ErrExtensionTooLong  = errors.New("err_extension_too_long")
+	ErrImageNotSupported  = errors.New("err_image_not_supported")
+	ErrAudioNotSupported  = errors.New("err_audio_not_supported")
+	ErrImageTooSmall      = errors.New("err_image_too_small")
+	ErrDataNotFound       = errors.New("err_data_not_found")
+	ErrDecryptionFailed   = errors.New("err_decryption_failed")
+	ErrExtensionTooLong   = errors.New("err_extension_too_long")
+	ErrUnsupportedFormat  = errors.New("err_unsupported_format")
+	ErrInvalidLayout      = errors.New("err_invalid_layout")
+	ErrInvalidRecipient   = errors.New("err_invalid_recipient")
+	ErrInvalidIdentity    = errors.New("err_invalid_identity")
+	ErrIdentityRequired   = errors.New("err_identity_required")
+	ErrInvalidSigner      = errors.New("err_invalid_signer")
+	ErrInvalidSigningKey  = errors.New("err_invalid_signing_key")
+	ErrMissingShards      = errors.New("err_missing_shards")
+	ErrShardMismatch      = errors.New("err_shard_mismatch")
+	ErrPayloadDamaged     = errors.New("err_payload_damaged")
+	ErrInvalidEntryName   = errors.New("err_invalid_entry_name")
+	ErrSeveralFiles       = errors.New("err_several_files")
+	ErrSamePassword       = errors.New("err_same_password")
+	ErrHiddenTooLarge     = errors.New("err_hidden_too_large")
+	ErrRegionNotSupported = errors.New("err_region_not_supported")
+	ErrUnsupportedCarrier = errors.New("err_unsupported_carrier")
 	
 	ErrInvalidAPIKey = errors.New("err_invalid_api_key")
 	ErrNetworkIssue  = errors.New("err_network_issue")
@@ -0,0 +1,752 @@
+package internal
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// FLAC is decoded completely and written again by a simple encoder: fixed
+// blocks of flacBlockSize samples, independently coded channels and the best
+// of the constant, fixed-predictor and verbatim subframes with Rice coded
+// residuals. Metadata other than STREAMINFO and SEEKTABLE is kept.
+
+const (
+	flacBlockSize      = 4096
+	flacMaxFixedOrder  = 4
+	flacMaxRiceOrder   = 8
+	flacBlockInfo      = 0
+	flacBlockSeekTable = 3
+)
+
+var errFLACFormat = errors.New("flac: invalid stream")
+
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+func isFLAC(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == "fLaC"
+}
+
+func decodeFLAC(data []byte) (*Audio, error) {
+	a := &Audio{Format: AudioFLAC}
+	p := 4
+	var total uint64
+	for last := false; !last; {
+		if p+4 > len(data) {
+			return nil, errFLACFormat
+		}
+		last = data[p]&0x80 != 0
+		kind := data[p] & 0x7f
+		n := int(data[p+1])<<16 | int(data[p+2])<<8 | int(data[p+3])
+		p += 4
+		if p+n > len(data) {
+			return nil, errFLACFormat
+		}
+		block := data[p : p+n]
+		p += n
+
+		switch kind {
+		case flacBlockInfo:
+			if n < 34 {
+				return nil, errFLACFormat
+			}
+			v := binary.BigEndian.Uint64(block[10:])
+			a.SampleRate = int(v >> 44)
+			a.Channels = int(v>>41&7) + 1
+			a.BitDepth = int(v>>36&31) + 1
+			total = v & (1<<36 - 1)
+		case flacBlockSeekTable:
+			// Frame offsets change when the stream is encoded again.
+		default:
+			a.meta = append(a.meta, flacBlock{kind: kind, data: block})
+		}
+	}
+	if a.SampleRate == 0 || a.BitDepth < 4 {
+		return nil, errFLACFormat
+	}
+
+	// The header's sample count is only trusted as far as the frames that
+	// follow could plausibly hold it; a lossless frame rarely packs more
+	// than a few samples into a byte.
+	if total > 0 {
+		a.Samples = make([]int32, 0, min(int(min(total, 1<<28))*a.Channels, 8*(len(data)-p)))
+	}
+	r := &flacReader{data: data, pos: p * 8}
+	// Anything after the last frame, like an ID3 tag, is dropped.
+	for r.pos/8 < len(data) && data[r.pos/8] == 0xff {
+		if err := r.frame(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+var (
+	flacBlockSizes  = [16]int{0, 192, 576, 1152, 2304, 4608, 0, 0, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+	flacSampleSizes = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+)
+
+// flacReader reads bits most significant first.
+type flacReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *flacReader) read(n int) (uint64, error) {
+	if r.pos+n > 8*len(r.data) {
+		return 0, errFLACFormat
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint64(r.data[r.pos>>3]>>(7-r.pos&7)&1)
+		r.pos++
+	}
+	return v, nil
+}
+
+func (r *flacReader) signed(n int) (int64, error) {
+	v, err := r.read(n)
+	if n == 0 {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), err
+}
+
+func (r *flacReader) unary() (uint64, error) {
+	var n uint64
+	for {
+		if r.pos >= 8*len(r.data) {
+			return 0, errFLACFormat
+		}
+		// Skip whole zero bytes at once.
+		if r.pos&7 == 0 && r.data[r.pos>>3] == 0 {
+			n += 8
+			r.pos += 8
+			continue
+		}
+		b, _ := r.read(1)
+		if b == 1 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+func (r *flacReader) frame(a *Audio) error {
+	start := r.pos / 8
+	head, err := r.read(32)
+	if err != nil {
+		return err
+	}
+	if head>>18 != 0x3ffe {
+		return errFLACFormat
+	}
+
+	sizeCode, rateCode := int(head>>12&15), int(head>>8&15)
+	assign, depthCode := int(head>>4&15), int(head>>1&7)
+	if err = r.utf8(); err != nil {
+		return err
+	}
+
+	size := flacBlockSizes[sizeCode]
+	switch sizeCode {
+	case 0:
+		return errFLACFormat
+	case 6, 7:
+		v, err := r.read(8 * (sizeCode - 5))
+		if err != nil {
+			return err
+		}
+		size = int(v) + 1
+	}
+	switch rateCode {
+	case 12, 13, 14:
+		if _, err = r.read(8 + 8*min(rateCode-12, 1)); err != nil {
+			return err
+		}
+	case 15:
+		return errFLACFormat
+	}
+	depth := a.BitDepth
+	if depthCode != 0 {
+		depth = flacSampleSizes[depthCode]
+	}
+	if depth != a.BitDepth {
+		return errFLACFormat
+	}
+
+	if _, err = r.read(8); err != nil {
+		return err
+	}
+	if flacCRC8(r.data[start:r.pos/8]) != 0 {
+		return errFLACFormat
+	}
+
+	channels := assign + 1
+	if assign >= 8 {
+		if assign > 10 {
+			return errFLACFormat
+		}
+		channels = 2
+	}
+	if channels != a.Channels {
+		return errFLACFormat
+	}
+
+	block := make([][]int64, channels)
+	for c := range block {
+		d := depth
+		// The side channel needs one more bit.
+		if assign == 8 && c == 1 || assign == 9 && c == 0 || assign == 10 && c == 1 {
+			d++
+		}
+		if block[c], err = r.subframe(size, d); err != nil {
+			return err
+		}
+	}
+
+	r.pos = (r.pos + 7) &^ 7
+	if _, err = r.read(16); err != nil {
+		return err
+	}
+	if flacCRC16(r.data[start:r.pos/8]) != 0 {
+		return errFLACFormat
+	}
+
+	for i := 0; i < size; i++ {
+		switch assign {
+		case 8:
+			block[1][i] = block[0][i] - block[1][i]
+		case 9:
+			block[0][i] += block[1][i]
+		case 10:
+			mid := block[0][i]<<1 | block[1][i]&1
+			block[0][i], block[1][i] = (mid+block[1][i])>>1, (mid-block[1][i])>>1
+		}
+		for c := range block {
+			a.Samples = append(a.Samples, int32(block[c][i]))
+		}
+	}
+	return nil
+}
+
+// utf8 skips the frame or sample number.
+func (r *flacReader) utf8() error {
+	v, err := r.read(8)
+	if err != nil {
+		return err
+	}
+	extra := bits.LeadingZeros8(^uint8(v))
+	if extra == 1 || extra > 7 {
+		return errFLACFormat
+	}
+	if extra > 1 {
+		_, err = r.read(8 * (extra - 1))
+	}
+	return err
+}
+
+func (r *flacReader) subframe(size, depth int) ([]int64, error) {
+	head, err := r.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if head&0x80 != 0 {
+		return nil, errFLACFormat
+	}
+	kind := int(head >> 1 & 0x3f)
+
+	wasted := 0
+	if head&1 != 0 {
+		n, err := r.unary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = int(n) + 1
+	}
+	depth -= wasted
+	if depth < 1 {
+		return nil, errFLACFormat
+	}
+
+	s := make([]int64, size)
+	switch {
+	case kind == 0:
+		v, err := r.signed(depth)
+		if err != nil {
+			return nil, err
+		}
+		for i := range s {
+			s[i] = v
+		}
+	case kind == 1:
+		for i := range s {
+			if s[i], err = r.signed(depth); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 8+flacMaxFixedOrder:
+		order := kind - 8
+		if err = r.warmup(s, order, depth); err != nil {
+			return nil, err
+		}
+		if err = r.residual(s, order); err != nil {
+			return nil, err
+		}
+		fixedRestore(s, order)
+	case kind >= 32:
+		order := kind - 31
+		if err = r.warmup(s, order, depth); err != nil {
+			return nil, err
+		}
+		precision, err := r.read(4)
+		if err != nil || precision == 15 {
+			return nil, errFLACFormat
+		}
+		shift, err := r.signed(5)
+		if err != nil || shift < 0 {
+			return nil, errFLACFormat
+		}
+		coeffs := make([]int64, order)
+		for i := range coeffs {
+			if coeffs[i], err = r.signed(int(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+		if err = r.residual(s, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < size; i++ {
+			var sum int64
+			for j, c := range coeffs {
+				sum += c * s[i-1-j]
+			}
+			s[i] += sum >> shift
+		}
+	default:
+		return nil, errFLACFormat
+	}
+
+	if wasted > 0 {
+		for i := range s {
+			s[i] <<= wasted
+		}
+	}
+	return s, nil
+}
+
+func (r *flacReader) warmup(s []int64, order, depth int) error {
+	if order > len(s) {
+		return errFLACFormat
+	}
+	var err error
+	for i := 0; i < order; i++ {
+		if s[i], err = r.signed(depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// residual reads the partitioned Rice coded residual into s[order:].
+func (r *flacReader) residual(s []int64, order int) error {
+	head, err := r.read(6)
+	if err != nil {
+		return err
+	}
+	method, partitions := head>>4, head&15
+	if method > 1 {
+		return errFLACFormat
+	}
+	paramBits, escape := 4, uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+
+	n := len(s) >> partitions
+	if n<<partitions != len(s) || n < order {
+		return errFLACFormat
+	}
+	i := order
+	for p := 0; p < 1<<partitions; p++ {
+		end := (p + 1) * n
+		k, err := r.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			width, err := r.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if s[i], err = r.signed(int(width)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := r.unary()
+			if err != nil {
+				return err
+			}
+			low, err := r.read(int(k))
+			if err != nil {
+				return err
+			}
+			u := q<<k | low
+			s[i] = int64(u>>1) ^ -int64(u&1)
+		}
+	}
+	return nil
+}
+
+// fixedRestore adds the fixed predictions of the given order back onto the
+// residuals in s.
+func fixedRestore(s []int64, order int) {
+	for i := order; i < len(s); i++ {
+		s[i] += fixedPredict(s, i, order)
+	}
+}
+
+func fixedPredict(s []int64, i, order int) int64 {
+	switch order {
+	case 1:
+		return s[i-1]
+	case 2:
+		return 2*s[i-1] - s[i-2]
+	case 3:
+		return 3*s[i-1] - 3*s[i-2] + s[i-3]
+	case 4:
+		return 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+	}
+	return 0
+}
+
+func encodeFLAC(w io.Writer, a *Audio) error {
+	if a.Channels < 1 || a.Channels > 8 || a.BitDepth < 4 || a.BitDepth > 32 || len(a.Samples)%a.Channels != 0 {
+		return errFLACFormat
+	}
+	frames := len(a.Samples) / a.Channels
+
+	var out flacWriter
+	minFrame, maxFrame := 0, 0
+	block := make([][]int64, a.Channels)
+	for n, first := 0, 0; first < frames; n++ {
+		size := min(flacBlockSize, frames-first)
+		for c := range block {
+			block[c] = block[c][:0]
+			for i := first; i < first+size; i++ {
+				block[c] = append(block[c], int64(a.Samples[i*a.Channels+c]))
+			}
+		}
+		start := len(out.buf)
+		out.frame(n, block, a.BitDepth)
+		length := len(out.buf) - start
+		if minFrame == 0 || length < minFrame {
+			minFrame = length
+		}
+		maxFrame = max(maxFrame, length)
+		first += size
+	}
+
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:], flacBlockSize)
+	binary.BigEndian.PutUint16(info[2:], flacBlockSize)
+	info[4], info[5], info[6] = byte(minFrame>>16), byte(minFrame>>8), byte(minFrame)
+	info[7], info[8], info[9] = byte(maxFrame>>16), byte(maxFrame>>8), byte(maxFrame)
+	binary.BigEndian.PutUint64(info[10:], uint64(a.SampleRate)<<44|uint64(a.Channels-1)<<41|uint64(a.BitDepth-1)<<36|uint64(frames))
+	sum := flacMD5(a)
+	copy(info[18:], sum[:])
+
+	head := []byte("fLaC")
+	blocks := append([]flacBlock{{kind: flacBlockInfo, data: info}}, a.meta...)
+	for i, b := range blocks {
+		kind := b.kind
+		if i == len(blocks)-1 {
+			kind |= 0x80
+		}
+		n := len(b.data)
+		head = append(head, kind, byte(n>>16), byte(n>>8), byte(n))
+		head = append(head, b.data...)
+	}
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(out.buf)
+	return err
+}
+
+// flacMD5 is the signature STREAMINFO records: the MD5 of the interleaved
+// samples in little-endian bytes.
+func flacMD5(a *Audio) [md5.Size]byte {
+	width := (a.BitDepth + 7) / 8
+	raw := make([]byte, 0, width*len(a.Samples))
+	for _, v := range a.Samples {
+		for j := 0; j < width; j++ {
+			raw = append(raw, byte(v>>(8*j)))
+		}
+	}
+	return md5.Sum(raw)
+}
+
+// flacWriter writes bits most significant first.
+type flacWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (w *flacWriter) write(v uint64, n uint) {
+	for n > 32 {
+		n -= 32
+		w.write(v>>n, 32)
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.bits += n
+	for w.bits >= 8 {
+		w.bits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.bits))
+	}
+}
+
+func (w *flacWriter) align() {
+	if w.bits > 0 {
+		w.write(0, 8-w.bits)
+	}
+}
+
+func (w *flacWriter) frame(n int, block [][]int64, depth int) {
+	start := len(w.buf)
+	size := len(block[0])
+
+	sizeCode := uint64(7)
+	if size == flacBlockSize {
+		sizeCode = 12
+	}
+	depthCode := uint64(0)
+	for code, d := range flacSampleSizes {
+		if d == depth {
+			depthCode = uint64(code)
+		}
+	}
+	w.write(0x3ffe<<2, 16)
+	w.write(sizeCode<<4, 8)
+	w.write(uint64(len(block)-1)<<4|depthCode<<1, 8)
+	w.utf8(uint64(n))
+	if sizeCode == 7 {
+		w.write(uint64(size-1), 16)
+	}
+	w.write(uint64(flacCRC8(w.buf[start:])), 8)
+
+	for _, s := range block {
+		w.subframe(s, depth)
+	}
+
+	w.align()
+	w.write(uint64(flacCRC16(w.buf[start:])), 16)
+}
+
+func (w *flacWriter) utf8(v uint64) {
+	if v < 0x80 {
+		w.write(v, 8)
+		return
+	}
+	extra := 1
+	for v>>(5*extra+6) != 0 {
+		extra++
+	}
+	lead := uint64(0xff00>>(extra+1)) & 0xff
+	w.write(lead|v>>(6*extra), 8)
+	for i := extra - 1; i >= 0; i-- {
+		w.write(0x80|v>>(6*i)&0x3f, 8)
+	}
+}
+
+// subframe writes s in the cheapest subframe type.
+func (w *flacWriter) subframe(s []int64, depth int) {
+	constant := true
+	for _, v := range s {
+		if v != s[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		w.write(0, 8)
+		w.write(uint64(s[0]), uint(depth))
+		return
+	}
+
+	best, bestCost := -1, len(s)*depth
+	var bestRes []int64
+	for order := 0; order <= flacMaxFixedOrder && order < len(s); order++ {
+		res, ok := fixedResidual(s, order, depth)
+		if !ok {
+			continue
+		}
+		cost := order*depth + riceCost(res, order)
+		if cost < bestCost {
+			best, bestCost, bestRes = order, cost, res
+		}
+	}
+
+	if best < 0 {
+		w.write(1<<1, 8)
+		for _, v := range s {
+			w.write(uint64(v), uint(depth))
+		}
+		return
+	}
+
+	w.write(uint64(8+best)<<1, 8)
+	for _, v := range s[:best] {
+		w.write(uint64(v), uint(depth))
+	}
+	w.residual(bestRes, best)
+}
+
+// fixedResidual returns s minus its fixed prediction of the given order,
+// unless a residual cannot be Rice coded from 32 bits.
+func fixedResidual(s []int64, order, depth int) ([]int64, bool) {
+	res := make([]int64, len(s))
+	for i := order; i < len(s); i++ {
+		res[i] = s[i] - fixedPredict(s, i, order)
+		if res[i] >= 1<<31 || res[i] < -1<<31 {
+			return nil, false
+		}
+	}
+	return res, true
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1 ^ v>>63)
+}
+
+// riceParam estimates the best Rice parameter for n values summing to sum
+// after zigzag coding, and the bits they then take.
+func riceParam(sum uint64, n int) (uint, int) {
+	if n == 0 {
+		return 0, 0
+	}
+	k := uint(0)
+	if mean := sum / uint64(n); mean > 0 {
+		k = uint(bits.Len64(mean)) - 1
+	}
+	k = min(k, 30)
+	return k, n*int(k+1) + int(sum>>k)
+}
+
+// riceOrder picks the partition order for res[order:] and returns its
+// partition sums.
+func riceOrder(res []int64, order int) (uint, []uint64, int) {
+	n := len(res)
+	maxOrder := uint(0)
+	for maxOrder < flacMaxRiceOrder && n%(2<<maxOrder) == 0 && n>>(maxOrder+1) > order {
+		maxOrder++
+	}
+
+	sums := make([]uint64, 1<<maxOrder)
+	part := n >> maxOrder
+	for i := order; i < n; i++ {
+		sums[i/part] += zigzag(res[i])
+	}
+
+	bestOrder, bestSums, bestCost := uint(0), []uint64(nil), -1
+	for p := int(maxOrder); p >= 0; p-- {
+		cost := 0
+		for i, sum := range sums {
+			count := n >> p
+			if i == 0 {
+				count -= order
+			}
+			_, c := riceParam(sum, count)
+			cost += 5 + c
+		}
+		if bestCost < 0 || cost < bestCost {
+			bestOrder, bestSums, bestCost = uint(p), append([]uint64(nil), sums...), cost
+		}
+		if p > 0 {
+			for i := range sums[:len(sums)/2] {
+				sums[i] = sums[2*i] + sums[2*i+1]
+			}
+			sums = sums[:len(sums)/2]
+		}
+	}
+	return bestOrder, bestSums, bestCost
+}
+
+func riceCost(res []int64, order int) int {
+	_, _, cost := riceOrder(res, order)
+	return 6 + cost
+}
+
+func (w *flacWriter) residual(res []int64, order int) {
+	p, sums, _ := riceOrder(res, order)
+	n := len(res) >> p
+
+	params := make([]uint, len(sums))
+	method := uint64(0)
+	for i, sum := range sums {
+		count := n
+		if i == 0 {
+			count -= order
+		}
+		params[i], _ = riceParam(sum, count)
+		if params[i] >= 15 {
+			method = 1
+		}
+	}
+	paramBits := uint(4 + method)
+
+	w.write(method<<4|uint64(p), 6)
+	i := order
+	for part, k := range params {
+		w.write(uint64(k), paramBits)
+		for ; i < (part+1)*n; i++ {
+			u := zigzag(res[i])
+			q := u >> k
+			for ; q >= 32; q -= 32 {
+				w.write(0, 32)
+			}
+			w.write(1, uint(q)+1)
+			w.write(u, k)
+		}
+	}
+}
+
+func flacCRC8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
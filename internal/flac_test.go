@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFLACRoundTrip(t *testing.T) {
+	for _, width := range []int{1, 2, 3, 4} {
+		for _, frames := range []int{1, 15, 4096, 5000, 20000} {
+			for _, ch := range []int{1, 2, 6} {
+				a, err := DecodeAudio(bytes.NewReader(makeWAV(ch, width, frames, int64(frames))))
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.Format = AudioFLAC
+				a.meta = []flacBlock{{kind: 4, data: []byte("vendor....")}}
+				var buf bytes.Buffer
+				if err := a.Encode(&buf); err != nil {
+					t.Fatal(err)
+				}
+				b, err := DecodeAudio(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatal(width, frames, ch, err)
+				}
+				if b.Format != AudioFLAC || b.Channels != ch || b.BitDepth != 8*width || b.SampleRate != 44100 {
+					t.Fatal(b.Format, b.Channels, b.BitDepth)
+				}
+				if len(b.Samples) != len(a.Samples) {
+					t.Fatal(len(b.Samples), len(a.Samples))
+				}
+				for i := range a.Samples {
+					if a.Samples[i] != b.Samples[i] {
+						t.Fatal("sample", i, width, frames, ch)
+					}
+				}
+				if len(b.meta) != 1 || string(b.meta[0].data) != "vendor...." {
+					t.Fatal("meta")
+				}
+			}
+		}
+	}
+	// Extreme values.
+	a := &Audio{Format: AudioFLAC, SampleRate: 8000, Channels: 1, BitDepth: 32}
+	for i := 0; i < 9000; i++ {
+		v := int32(math.MaxInt32)
+		if i%3 == 0 {
+			v = math.MinInt32
+		}
+		a.Samples = append(a.Samples, v)
+	}
+	var buf bytes.Buffer
+	if err := a.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b, err := DecodeAudio(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a.Samples {
+		if a.Samples[i] != b.Samples[i] {
+			t.Fatal("extreme", i)
+		}
+	}
+}
+
+// A STREAMINFO claiming far more samples than the stream holds must not make
+// the decoder reserve room for them.
+func TestFLACHugeTotal(t *testing.T) {
+	a := &Audio{Format: AudioFLAC, SampleRate: 8000, Channels: 2, BitDepth: 16, Samples: make([]int32, 2000)}
+	var buf bytes.Buffer
+	if err := a.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	v := binary.BigEndian.Uint64(data[18:])
+	binary.BigEndian.PutUint64(data[18:], v|(1<<36-1))
+
+	b, err := DecodeAudio(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Samples) != len(a.Samples) {
+		t.Fatalf("samples: got %d, want %d", len(b.Samples), len(a.Samples))
+	}
+	if limit := 1 << 16; cap(b.Samples) > limit {
+		t.Fatalf("capacity: got %d, want at most %d", cap(b.Samples), limit)
+	}
+}
+
+// A hand-built frame with mid/side stereo, an LPC subframe, wasted bits and
+// an escaped partition.
+func TestFLACDecodeFeatures(t *testing.T) {
+	const n = 16
+	left, right := make([]int64, n), make([]int64, n)
+	for i := range left {
+		left[i] = int64(i*i - 40)
+		right[i] = int64(3*i) * 4
+	}
+	var w flacWriter
+	w.write(0x3ffe<<2|1, 16) // variable blocking
+	w.write(6<<4|9, 8)       // block size 8 bit, 44.1k
+	w.write(10<<4|4<<1, 8)   // mid/side, 16 bit
+	w.utf8(12345)
+	w.write(n-1, 8)
+	w.write(uint64(flacCRC8(w.buf)), 8)
+
+	mid, side := make([]int64, n), make([]int64, n)
+	for i := range mid {
+		mid[i] = (left[i] + right[i]) >> 1
+		side[i] = left[i] - right[i]
+	}
+	// mid: LPC order 2 with coefficients 2 and -1, precision 4, no shift, and
+	// Rice2 partitions of order 1.
+	w.write(uint64(32+1)<<1, 8)
+	w.write(uint64(mid[0]), 16)
+	w.write(uint64(mid[1]), 16)
+	w.write(3, 4)
+	w.write(0, 5)
+	w.write(2, 4)
+	w.write(uint64(0xf&-1), 4)
+	w.write(1<<4|1, 6)
+	for p := 0; p < 2; p++ {
+		if p == 1 {
+			w.write(31, 5) // escape
+			w.write(12, 5)
+		} else {
+			w.write(3, 5)
+		}
+		for i := max(p*8, 2); i < (p+1)*8; i++ {
+			r := mid[i] - (2*mid[i-1] - mid[i-2])
+			if p == 1 {
+				w.write(uint64(r), 12)
+				continue
+			}
+			u := zigzag(r)
+			w.write(1, uint(u>>3)+1)
+			w.write(u, 3)
+		}
+	}
+	// side: fixed order 1.
+	w.write(uint64(8+1)<<1, 8)
+	w.write(uint64(side[0]), 17)
+	w.residual(fixedRes(side, 1), 1)
+	w.align()
+	w.write(uint64(flacCRC16(w.buf)), 16)
+	frame := w.buf
+
+	// Second frame: independent channels, the first with two wasted bits.
+	var v flacWriter
+	v.write(0x3ffe<<2|1, 16)
+	v.write(6<<4|9, 8)
+	v.write(1<<4|4<<1, 8)
+	v.utf8(12345 + n)
+	v.write(n-1, 8)
+	v.write(uint64(flacCRC8(v.buf)), 8)
+	v.write(1<<1|1, 8) // verbatim, wasted
+	v.write(0b01, 2)   // unary 1 -> wasted 2
+	for i := 0; i < n; i++ {
+		v.write(uint64(right[i]>>2), 14)
+	}
+	v.write(1<<1, 8)
+	for i := 0; i < n; i++ {
+		v.write(uint64(left[i]), 16)
+	}
+	v.align()
+	v.write(uint64(flacCRC16(v.buf)), 16)
+
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint64(info[10:], 44100<<44|1<<41|15<<36|2*n)
+	stream := append([]byte("fLaC\x80\x00\x00\x22"), info...)
+	stream = append(stream, frame...)
+	stream = append(stream, v.buf...)
+	stream = append(stream, "TAG......"...)
+
+	a, err := DecodeAudio(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Samples) != 4*n {
+		t.Fatalf("%d samples, want %d", len(a.Samples), 4*n)
+	}
+	for i := 0; i < n; i++ {
+		if int64(a.Samples[2*i]) != left[i] || int64(a.Samples[2*i+1]) != right[i] {
+			t.Fatalf("frame 1, sample %d: got %d %d, want %d %d", i, a.Samples[2*i], a.Samples[2*i+1], left[i], right[i])
+		}
+		if int64(a.Samples[2*n+2*i]) != right[i] || int64(a.Samples[2*n+2*i+1]) != left[i] {
+			t.Fatalf("frame 2, sample %d differs", i)
+		}
+	}
+
+	damaged := bytes.Clone(stream)
+	damaged[len(stream)-len(v.buf)/2-9] ^= 0x10
+	if _, err := DecodeAudio(bytes.NewReader(damaged)); err == nil {
+		t.Fatal("decoded a frame with a bad checksum")
+	}
+}
+
+func fixedRes(s []int64, order int) []int64 {
+	r, _ := fixedResidual(s, order, 32)
+	return r
+}
+
+func TestWAVFromFLAC(t *testing.T) {
+	a, _ := DecodeAudio(bytes.NewReader(makeWAV(2, 3, 3001, 5)))
+	b := &Audio{Format: AudioWAV, SampleRate: a.SampleRate, Channels: 2, BitDepth: 24, Samples: a.Samples}
+	var buf bytes.Buffer
+	if err := b.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c, err := DecodeAudio(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a.Samples {
+		if a.Samples[i] != c.Samples[i] {
+			t.Fatal(i)
+		}
+	}
+}
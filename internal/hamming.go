@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"errors"
+	"io"
+)
+
+// hammingStream hides the body with matrix embedding over the bit stream of a
+// PixOperator: every group of 2^k-1 stream bits carries k body bits as its
+// Hamming syndrome, the XOR of the 1-based positions of its set bits. Writing
+// a group flips at most one of its bits, against k/2 on average when the
+// bits are written as they are.
+//
+// Groups are independent, so the stream can be addressed like any other.
+type hammingStream struct {
+	body *PixOperator
+	k    int
+}
+
+func newHammingStream(body *PixOperator, k int) *hammingStream {
+	return &hammingStream{body: body, k: k}
+}
+
+// size is the number of stream bits in a group.
+func (h *hammingStream) size() int {
+	return 1<<h.k - 1
+}
+
+func (h *hammingStream) Capacity() int {
+	return h.body.Capacity() * 8 / h.size() * h.k / 8
+}
+
+func (h *hammingStream) Embed(data []byte, off int) error {
+	if off < 0 || off+len(data) > h.Capacity() {
+		return errors.New("out of bounds")
+	}
+	h.walk(off).write(data)
+	return nil
+}
+
+func (h *hammingStream) UnEmbed(n int, off int) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > h.Capacity() {
+		return nil, errors.New("out of bounds")
+	}
+	out := make([]byte, n)
+	h.walk(off).read(out)
+	return out, nil
+}
+
+// Writer and Reader keep their place in the stream, like those of
+// PixOperator.
+func (h *hammingStream) Writer(off int) io.Writer {
+	return h.sequential(off)
+}
+
+func (h *hammingStream) Reader(off int) io.Reader {
+	return h.sequential(off)
+}
+
+func (h *hammingStream) sequential(off int) *hammingIO {
+	s := &hammingIO{}
+	if off >= 0 && off <= h.Capacity() {
+		s.w, s.left = h.walk(off), h.Capacity()-off
+	}
+	return s
+}
+
+type hammingIO struct {
+	w    *hammingWalk
+	left int
+}
+
+func (s *hammingIO) Write(b []byte) (int, error) {
+	n := min(len(b), s.left)
+	if n > 0 {
+		s.w.write(b[:n])
+	}
+	s.left -= n
+	if n < len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (s *hammingIO) Read(b []byte) (int, error) {
+	if s.left == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(b), s.left)
+	s.w.read(b[:n])
+	s.left -= n
+	return n, nil
+}
+
+// hammingWalk walks a hammingStream one body bit at a time.
+type hammingWalk struct {
+	h   *hammingStream
+	c   *cursor
+	bit int // next body bit
+
+	// group holds the index in Pix and the bit position of every stream bit
+	// of group g, whose syndrome is syndrome.
+	group    [][2]int
+	g        int
+	syndrome int
+}
+
+func (h *hammingStream) walk(off int) *hammingWalk {
+	w := &hammingWalk{h: h, c: h.body.cursor(), bit: off * 8, group: make([][2]int, h.size())}
+	w.g = w.bit / h.k
+	w.c.skip(w.g * h.size())
+	w.load()
+	return w
+}
+
+// load reads the positions and the syndrome of group g, which the cursor is
+// at.
+func (w *hammingWalk) load() {
+	w.syndrome = 0
+	if w.bit >= w.h.Capacity()*8 {
+		return
+	}
+	for i := range w.group {
+		idx, shift := w.c.next()
+		w.group[i] = [2]int{idx, int(shift)}
+		if w.h.body.Pix[idx]>>shift&1 == 1 {
+			w.syndrome ^= i + 1
+		}
+	}
+}
+
+// next moves to the following body bit, and its group when needed.
+func (w *hammingWalk) next() {
+	w.bit++
+	if w.bit/w.h.k != w.g {
+		w.g++
+		w.load()
+	}
+}
+
+// shift is the position in the syndrome of the current body bit; the bits of
+// a group are read most significant first.
+func (w *hammingWalk) shift() int {
+	return w.h.k - 1 - w.bit%w.h.k
+}
+
+func (w *hammingWalk) read(out []byte) {
+	for i := range out {
+		var v byte
+		for j := 0; j < 8; j++ {
+			v = v<<1 | byte(w.syndrome>>w.shift()&1)
+			w.next()
+		}
+		out[i] = v
+	}
+}
+
+// write sets the body bits from the current one on, changing the syndrome of
+// each group once all of its bits that data covers are known.
+func (w *hammingWalk) write(data []byte) {
+	want := w.syndrome
+	for i := 0; i < len(data)*8; i++ {
+		s := w.shift()
+		want = want&^(1<<s) | int(data[i/8]>>(7-i%8)&1)<<s
+		if s == 0 || i == len(data)*8-1 {
+			w.flip(want)
+		}
+		w.next()
+		if s == 0 {
+			want = w.syndrome
+		}
+	}
+}
+
+// flip changes the one stream bit of the current group that turns its
+// syndrome into want.
+func (w *hammingWalk) flip(want int) {
+	if j := w.syndrome ^ want; j != 0 {
+		w.h.body.flip(w.group[j-1][0], uint(w.group[j-1][1]))
+		w.syndrome = want
+	}
+}
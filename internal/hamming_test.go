@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestHammingStream(t *testing.T) {
+	img := testImage(64, 64)
+	for k := 2; k <= 7; k++ {
+		pix := append([]uint8(nil), img.Pix...)
+		op := NewPixOperator(pix, Layout{Bits: 2, Channels: ChannelsRGB})
+		h := newHammingStream(op, k)
+		data := make([]byte, h.Capacity())
+		rand.Read(data)
+		if err := h.Embed(data, 0); err != nil {
+			t.Fatal(err)
+		}
+		// Every group of samples changes in at most one place.
+		changed := 0
+		for i := range pix {
+			if pix[i] != img.Pix[i] {
+				changed++
+			}
+		}
+		if groups := (h.Capacity()*8 + k - 1) / k; changed > groups {
+			t.Fatalf("k=%d: %d samples changed for %d groups", k, changed, groups)
+		}
+
+		// Write again in pieces that do not line up with the groups.
+		rand.Read(data)
+		for off := 0; off < len(data); off += 7 {
+			if err := h.Embed(data[off:min(off+7, len(data))], off); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := h.UnEmbed(len(data), 0)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("k=%d: %v", k, err)
+		}
+		if part, err := h.UnEmbed(5, 3); err != nil || !bytes.Equal(part, data[3:8]) {
+			t.Fatalf("k=%d: part: %v", k, err)
+		}
+		// Sequential writer and reader.
+		w := h.Writer(1)
+		rand.Read(data[1:])
+		for off := 1; off < len(data); off += 3 {
+			if _, err := w.Write(data[off:min(off+3, len(data))]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := w.Write([]byte{1}); err != io.ErrShortWrite {
+			t.Fatalf("k=%d: write past the end: got %v, want %v", k, err, io.ErrShortWrite)
+		}
+		if all, err := io.ReadAll(h.Reader(0)); err != nil || !bytes.Equal(all, data) {
+			t.Fatalf("k=%d: reader: %v", k, err)
+		}
+	}
+}
+
+func TestEmbedHamming(t *testing.T) {
+	src := halfTextured(200, 150)
+	data := make([]byte, 1500)
+	rand.Read(data)
+	plainOpts := Options{Layout: Layout{Bits: 1, Channels: ChannelsRGB}, Compression: CompressionNone}
+	plain, err := EmbedData(src, data, "", 0, "secret1", plainOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, pr := changes(src, plain.(*image.NRGBA))
+	for k := 2; k <= 7; k++ {
+		opts := plainOpts
+		opts.Coding = HammingCoding(k)
+		opts.Traversal = TraversalScattered
+		capacity := Capacity(src, opts)
+		if capacity < len(data)+50 {
+			continue
+		}
+		out, err := EmbedData(src, data, "", 0, "secret1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, _, err := ExtractData(out, 0, "secret1", Options{})
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("k=%d: %v", k, err)
+		}
+		if l, r := changes(src, out.(*image.NRGBA)); l+r >= pl+pr {
+			t.Fatalf("k=%d: %d bytes changed, %d without coding", k, l+r, pl+pr)
+		}
+		if h, _, err := Inspect(out, 0, "secret1"); err != nil || h.Coding.Hamming() != k {
+			t.Fatalf("k=%d: Inspect: %v", k, err)
+		}
+	}
+
+	// ECC, streams, deniable carriers and 16-bit images take the option too.
+	opts := Options{Coding: HammingCoding(3), Redundancy: RedundancyMedium}
+	msg := []byte("matrix embedding everywhere")
+	out, err := EmbedData(src, msg, "", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("ECC: %v", err)
+	}
+	w, err := NewEmbedWriter(src, ".txt", "secret1", Options{Coding: HammingCoding(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := make([]byte, 1000)
+	rand.Read(big)
+	if _, err := w.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h, _, err := Inspect(w.Carrier(), 0, ""); err != nil || h.Coding != HammingCoding(4) || h.Cipher != CipherAES256GCMStream {
+		t.Fatalf("stream header: %+v %v", h, err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := ExtractTo(&buf, w.Carrier(), "secret1", Options{}); err != nil || !bytes.Equal(buf.Bytes(), big) {
+		t.Fatalf("stream: %v", err)
+	}
+	dn, err := EmbedDeniable(src, Payload{Data: msg, Password: "secret1"}, Payload{Data: []byte("x"), Password: "other1"}, Options{Coding: HammingCoding(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(dn, 0, "other1", Options{}); err != nil || string(got) != "x" {
+		t.Fatalf("hidden: %v", err)
+	}
+	if got, _, _, err := ExtractData(dn, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("decoy: %v", err)
+	}
+	wide := image.NewNRGBA64(image.Rect(0, 0, 60, 60))
+	for i := range wide.Pix {
+		wide.Pix[i] = uint8(i * 7)
+	}
+	out, err = EmbedData(wide, msg, "", 0, "secret1", Options{Coding: HammingCoding(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("16-bit: %v", err)
+	}
+	for _, bad := range []int{0, 1, 8, 18} {
+		if _, err := EmbedData(src, msg, "", 0, "secret1", Options{Coding: HammingCoding(bad)}); err != ErrInternal {
+			t.Fatalf("k=%d: got %v, want %v", bad, err, ErrInternal)
+		}
+	}
+}
+
+func TestHammingCapacity(t *testing.T) {
+	src := testImage(100, 80)
+	opts := Options{Coding: HammingCoding(3), Compression: CompressionNone}
+	data := bytes.Repeat([]byte{0x6B}, Capacity(src, opts)-1)
+
+	if _, err := EmbedData(src, append(data, 1), "", 0, "secret1", opts); err != ErrImageTooSmall {
+		t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+	}
+	out, err := EmbedData(src, data, "", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatal(err)
+	}
+}
@@ -1,13 +1,141 @@
 package internal
 
 import (
+	"bytes"
 	"errors"
 	"image"
 	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Format is a file format besides PNG and JPEG that EncodeImage writes.
+type Format uint8
+
+const (
+	FormatBMP Format = iota + 1
+	FormatTIFF
+	FormatWebP
+	FormatGIF
 )
 
+// Picture is an image read by DecodeImage from a Format other than PNG or
+// JPEG, which results of EmbedData are written back in. Image is the pixels,
+// or the first frame of a GIF.
+type Picture struct {
+	image.Image
+	Format Format
+	
+	// gif keeps the other frames of an animated GIF.
+	gif *gif.GIF
+}
+
+// DecodeImage reads a carrier image. JPEG files keep their DCT coefficients,
+// so embedding into them can write a JPEG again; JPEGs that cannot be read
+// that way are decoded to pixels like every other format. BMP, TIFF, lossless
+// WebP and GIF images come back as a *Picture, and palette images keep their
+// palette.
+func DecodeImage(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	
+	if bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		if j, err := parseJPEG(data); err == nil {
+			return j, nil
+		}
+	}
+	
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) == 0 {
+			return nil, ErrImageNotSupported
+		}
+		return &Picture{Image: g.Image[0], Format: FormatGIF, gif: g}, nil
+	}
+	
+	img, name, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageNotSupported
+	}
+	
+	switch name {
+	case "bmp":
+		return &Picture{Image: img, Format: FormatBMP}, nil
+	case "tiff":
+		return &Picture{Image: img, Format: FormatTIFF}, nil
+	case "webp":
+		// Lossy WebP would destroy the data again, so only lossless images,
+		// which decode to NRGBA, stay WebP.
+		if _, ok := img.(*image.NRGBA); ok {
+			return &Picture{Image: img, Format: FormatWebP}, nil
+		}
+	}
+	return img, nil
+}
+
+// EncodeImage writes a result of EmbedData: a *JPEG as JPEG, a *Picture in
+// its Format and anything else as PNG, all formats that keep the hidden data
+// intact.
+func EncodeImage(w io.Writer, img image.Image) error {
+	switch img := img.(type) {
+	case *JPEG:
+		return img.Encode(w)
+	case *Picture:
+		return img.encode(w)
+	}
+	return png.Encode(w, img)
+}
+
+func (p *Picture) encode(w io.Writer) error {
+	switch p.Format {
+	case FormatBMP:
+		return bmp.Encode(w, p.Image)
+	case FormatTIFF:
+		return tiff.Encode(w, p.Image, &tiff.Options{Compression: tiff.Deflate})
+	case FormatWebP:
+		return encodeWebP(w, format(p.Image))
+	case FormatGIF:
+		frame, ok := p.Image.(*image.Paletted)
+		if !ok || p.gif == nil {
+			return gif.Encode(w, p.Image, nil)
+		}
+		g := *p.gif
+		g.Image = append([]*image.Paletted{frame}, p.gif.Image[1:]...)
+		return gif.EncodeAll(w, &g)
+	}
+	return png.Encode(w, p.Image)
+}
+
+// ImageExtension is the file extension EncodeImage writes img with.
+func ImageExtension(img image.Image) string {
+	switch img := img.(type) {
+	case *JPEG:
+		return ".jpg"
+	case *Picture:
+		switch img.Format {
+		case FormatBMP:
+			return ".bmp"
+		case FormatTIFF:
+			return ".tiff"
+		case FormatWebP:
+			return ".webp"
+		case FormatGIF:
+			return ".gif"
+		}
+	}
+	return ".png"
+}
+
 func format(src image.Image) *image.NRGBA {
-	if img, ok := src.(*image.NRGBA); ok {
+	if img, ok := src.(*image.NRGBA); ok && packed(img.Pix, img.Stride, img.Rect, 4) {
 		clone := *img
 		clone.Pix = make([]byte, len(img.Pix))
 		copy(clone.Pix, img.Pix)
@@ -20,10 +148,284 @@ func format(src image.Image) *image.NRGBA {
 	return dst
 }
 
-type PixOperator []uint8
+// format64 is format for images with 16-bit samples, which keep all of them.
+func format64(src image.Image) *image.NRGBA64 {
+	if img, ok := src.(*image.NRGBA64); ok && packed(img.Pix, img.Stride, img.Rect, 8) {
+		clone := *img
+		clone.Pix = make([]byte, len(img.Pix))
+		copy(clone.Pix, img.Pix)
+		return &clone
+	}
+	
+	bounds := src.Bounds()
+	dst := image.NewNRGBA64(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+	return dst
+}
+
+// packed reports whether pix holds exactly the pixels of r, size bytes each,
+// row after row. A SubImage shares the rows of its parent instead, which
+// PixOperator would walk as pixels of its own.
+func packed(pix []uint8, stride int, r image.Rectangle, size int) bool {
+	return stride == size*r.Dx() && len(pix) == stride*r.Dy()
+}
+
+// is16Bit reports whether img has 16-bit samples worth keeping. Gray16 is
+// not among them: as an NRGBA64 its one sample would become three colors,
+// changed independently and written back as a color PNG.
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64:
+		return true
+	}
+	return false
+}
+
+type ChannelMask uint8
+
+const (
+	ChannelR ChannelMask = 1 << iota
+	ChannelG
+	ChannelB
+	ChannelA
+	
+	ChannelsRGB  = ChannelR | ChannelG | ChannelB
+	ChannelsRGBA = ChannelsRGB | ChannelA
+)
+
+// Layout selects how many low bits of which channels carry payload bits.
+// Options give Bits for 8-bit samples; 16-bit samples carry twice as many,
+// which is what their headers record.
+type Layout struct {
+	Bits     int
+	Channels ChannelMask
+}
+
+var (
+	DefaultLayout = Layout{Bits: 2, Channels: ChannelsRGBA}
+	
+	// bootstrapLayout is used for the container header, which has to be found
+	// before the layout of the body is known.
+	bootstrapLayout = Layout{Bits: 2, Channels: ChannelsRGB}
+)
+
+// Layouts take at most maxBits low bits of 8-bit samples, and twice as many
+// of 16-bit ones.
+const (
+	maxBits     = 4
+	maxWideBits = 2 * maxBits
+)
+
+func (l Layout) Valid() bool {
+	return l.valid(maxBits)
+}
+
+func (l Layout) valid(maxBits int) bool {
+	return l.Bits >= 1 && l.Bits <= maxBits && l.Channels != 0 && l.Channels&^ChannelsRGBA == 0
+}
+
+// wide is the layout l selects on 16-bit samples.
+func (l Layout) wide() Layout {
+	l.Bits *= 2
+	return l
+}
+
+func (l Layout) channels() []int {
+	var out []int
+	for c := 0; c < 4; c++ {
+		if l.Channels&(1<<c) != 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (l Layout) encode() uint8 {
+	return uint8(l.Bits)<<4 | uint8(l.Channels)
+}
+
+func decodeLayout(b uint8) Layout {
+	return Layout{Bits: int(b >> 4), Channels: ChannelMask(b & 0x0F)}
+}
+
+// A pixel holds part of the container header only when its alpha is at least
+// headerAlpha. Every body layout can then use all of its channels, which keeps
+// Capacity independent of the visiting order.
+const headerAlpha = 16
+
+// PixOperator treats the low bits of the selected channels as one bit stream,
+// most significant bit first.
+type PixOperator struct {
+	Pix    []uint8
+	Layout Layout
+	
+	// Wide samples are 16 bits, big-endian, as in image.NRGBA64; the bits go
+	// into their low byte.
+	Wide bool
+	
+	// SkipTransparent leaves pixels with alpha 0 alone and only writes into
+	// alpha where the result cannot become 0, so the set of usable samples is
+	// the same before and after embedding.
+	SkipTransparent bool
+	
+	// Matching moves each written sample up or down to the nearest value
+	// with the new low bits instead of overwriting them, see match.
+	Matching bool
+	
+	// region, when set, leaves out every pixel it does not mark.
+	region []bool
+	
+	channels []int
+	capacity int
+	
+	// forHeader limits the stream to pixels that may hold the header.
+	forHeader bool
+	// reserved is the number of visited positions claimed by the header;
+	// header pixels among them are left out of the stream.
+	reserved int
+	
+	// order maps the i-th visited pixel to its index in Pix; nil visits
+	// pixels sequentially from the top-left corner.
+	order *scatter
+}
+
+func NewPixOperator(pix []uint8, layout Layout) *PixOperator {
+	return &PixOperator{Pix: pix, Layout: layout, channels: layout.channels(), capacity: -1}
+}
+
+func newHeaderOperator(pix []uint8, wide bool) *PixOperator {
+	op := NewPixOperator(pix, bootstrapLayout)
+	op.Wide = wide
+	op.forHeader = true
+	return op
+}
+
+func (p *PixOperator) Scatter(seed []byte) {
+	p.order = newScatter(seed, p.pixels())
+}
+
+// After returns an operator with the given layout over every pixel that the
+// first n bytes of p do not touch.
+func (p *PixOperator) After(n int, layout Layout) *PixOperator {
+	c := p.cursor()
+	for i := 0; i < n*8 && c.pos < p.pixels(); i++ {
+		c.next()
+	}
+	
+	return &PixOperator{
+		Pix:      p.Pix,
+		Layout:   layout,
+		Wide:     p.Wide,
+		Matching: p.Matching,
+		channels: layout.channels(),
+		capacity: -1,
+		reserved: min(c.pos+1, p.pixels()),
+		order:    p.order,
+	}
+}
+
+func (p *PixOperator) pixels() int {
+	return len(p.Pix) / p.stride()
+}
+
+// stride is the number of bytes of a pixel.
+func (p *PixOperator) stride() int {
+	if p.Wide {
+		return 8
+	}
+	return 4
+}
+
+// sample is the index in Pix of the byte holding the low bits of channel c.
+func (p *PixOperator) sample(base, c int) int {
+	if p.Wide {
+		return base + 2*c + 1
+	}
+	return base + c
+}
+
+// alpha returns the alpha of a pixel, and its top 8 bits.
+func (p *PixOperator) alpha(base int) (int, uint8) {
+	if p.Wide {
+		return int(p.Pix[base+6])<<8 | int(p.Pix[base+7]), p.Pix[base+6]
+	}
+	return int(p.Pix[base+3]), p.Pix[base+3]
+}
+
+func (p *PixOperator) pixel(i int) int {
+	if p.order == nil {
+		return i
+	}
+	return p.order.At(i)
+}
+
+func (p *PixOperator) isReserved(pos, base int) bool {
+	_, a := p.alpha(base)
+	return pos < p.reserved && a >= headerAlpha
+}
+
+func (p *PixOperator) usable(base, c int) bool {
+	a, top := p.alpha(base)
+	switch {
+	case p.forHeader:
+		return top >= headerAlpha
+	case p.region != nil && !p.region[base/p.stride()]:
+		return false
+	case !p.SkipTransparent:
+		return true
+	case c == 3:
+		return a>>p.Layout.Bits != 0
+	}
+	return a != 0
+}
+
+func (p *PixOperator) slots(base int) int {
+	n := 0
+	for _, c := range p.channels {
+		if p.usable(base, c) {
+			n++
+		}
+	}
+	return n
+}
 
 func (p *PixOperator) Capacity() int {
-	return len(*p) / 4
+	if p.capacity >= 0 {
+		return p.capacity
+	}
+	
+	// Counting does not depend on the visiting order, so only the reserved
+	// positions have to be located through it.
+	slots := p.pixels() * len(p.channels)
+	if p.forHeader || p.SkipTransparent || p.region != nil {
+		slots = 0
+		for base := 0; base+p.stride() <= len(p.Pix); base += p.stride() {
+			slots += p.slots(base)
+		}
+	}
+	for i := 0; i < p.reserved; i++ {
+		if base := p.pixel(i) * p.stride(); p.isReserved(i, base) {
+			slots -= p.slots(base)
+		}
+	}
+	
+	p.capacity = slots * p.Layout.Bits / 8
+	return p.capacity
+}
+
+// assumeWorstOrder sets Capacity to what is left when every pixel that holds
+// the header lies in the region, as few as any visiting order can leave.
+func (p *PixOperator) assumeWorstOrder() {
+	slots := 0
+	for base := 0; base+p.stride() <= len(p.Pix); base += p.stride() {
+		slots += p.slots(base)
+	}
+	for i := 0; i < p.reserved; i++ {
+		if base := p.pixel(i) * p.stride(); p.isReserved(i, base) {
+			slots -= len(p.channels)
+		}
+	}
+	p.capacity = max(slots, 0) * p.Layout.Bits / 8
 }
 
 func (p *PixOperator) Embed(data []byte, off int) error {
@@ -31,30 +433,224 @@ func (p *PixOperator) Embed(data []byte, off int) error {
 		return errors.New("out of bounds")
 	}
 	
-	for i, v := range data {
-		base := (off + i) * 4
-		(*p)[base+0] = ((*p)[base+0] & 0xFC) | ((v >> 6) & 0x03)
-		(*p)[base+1] = ((*p)[base+1] & 0xFC) | ((v >> 4) & 0x03)
-		(*p)[base+2] = ((*p)[base+2] & 0xFC) | ((v >> 2) & 0x03)
-		(*p)[base+3] = ((*p)[base+3] & 0xFC) | (v & 0x03)
-	}
+	c := p.cursor()
+	c.skip(off * 8)
+	p.write(c, data)
 	return nil
 }
 
+func (p *PixOperator) write(c *cursor, data []byte) {
+	last, orig := -1, 0
+	for _, v := range data {
+		for i := 7; i >= 0; i-- {
+			idx, shift := c.next()
+			if p.Matching && idx != last {
+				if last >= 0 {
+					p.match(last, orig)
+				}
+				last, orig = idx, p.value(idx)
+			}
+			p.Pix[idx] = p.Pix[idx]&^(1<<shift) | (v>>i&1)<<shift
+		}
+	}
+	if last >= 0 {
+		p.match(last, orig)
+	}
+}
+
+// flip inverts bit shift of the sample at idx, or reaches the same low bits
+// by matching.
+func (p *PixOperator) flip(idx int, shift uint) {
+	orig := p.value(idx)
+	p.Pix[idx] ^= 1 << shift
+	if p.Matching {
+		p.match(idx, orig)
+	}
+}
+
+// value returns the whole sample whose low byte is at idx.
+func (p *PixOperator) value(idx int) int {
+	if p.Wide {
+		return int(p.Pix[idx-1])<<8 | int(p.Pix[idx])
+	}
+	return int(p.Pix[idx])
+}
+
+func (p *PixOperator) setValue(idx, v int) {
+	if p.Wide {
+		p.Pix[idx-1] = uint8(v >> 8)
+	}
+	p.Pix[idx] = uint8(v)
+}
+
+// match is LSB matching: the sample at idx, which held orig before its low
+// bits were overwritten, becomes the value closest to orig with those low
+// bits instead, picking up or down at random when both are as close. Unlike
+// overwriting, this does not pair up values that chi-square and RS analysis
+// look for.
+func (p *PixOperator) match(idx, orig int) {
+	period := 1 << p.Layout.Bits
+	d := (p.value(idx) - orig) & (period - 1)
+	if d == 0 {
+		return
+	}
+	
+	near, far := orig+d-period, orig+d
+	if 2*d < period || 2*d == period && rand.IntN(2) == 0 {
+		near, far = far, near
+	}
+	for _, v := range []int{near, far} {
+		if p.allowed(idx, orig, v) {
+			p.setValue(idx, v)
+			return
+		}
+	}
+	// Otherwise the overwritten value stays, which is always allowed.
+}
+
+// allowed reports whether the sample at idx may change from orig to v. Alpha
+// may not change which pixels hold the header or are usable at all, as
+// overwriting the low bits never does.
+func (p *PixOperator) allowed(idx, orig, v int) bool {
+	top := 255
+	if p.Wide {
+		top = 65535
+	}
+	if v < 0 || v > top {
+		return false
+	}
+	if idx%p.stride() != p.sample(0, 3) {
+		return true
+	}
+	
+	hi := func(a int) int {
+		if p.Wide {
+			return a >> 8
+		}
+		return a
+	}
+	return (hi(v) >= headerAlpha) == (hi(orig) >= headerAlpha) && (v>>p.Layout.Bits != 0) == (orig>>p.Layout.Bits != 0)
+}
+
 func (p *PixOperator) UnEmbed(n int, off int) ([]byte, error) {
 	if off < 0 || n < 0 || off+n > p.Capacity() {
 		return nil, errors.New("out of bounds")
 	}
 	
+	c := p.cursor()
+	c.skip(off * 8)
 	out := make([]byte, n)
-	for i := 0; i < n; i++ {
-		base := (off + i) * 4
+	p.read(c, out)
+	return out, nil
+}
+
+func (p *PixOperator) read(c *cursor, out []byte) {
+	for i := range out {
 		var v byte
-		v |= ((*p)[base+0] & 0x03) << 6
-		v |= ((*p)[base+1] & 0x03) << 4
-		v |= ((*p)[base+2] & 0x03) << 2
-		v |= (*p)[base+3] & 0x03
+		for j := 0; j < 8; j++ {
+			idx, shift := c.next()
+			v = v<<1 | p.Pix[idx]>>shift&1
+		}
 		out[i] = v
 	}
-	return out, nil
+}
+
+// Writer returns a writer filling the stream from byte off onwards. Unlike
+// repeated calls to Embed it keeps its place, so large payloads are written
+// in a single pass.
+func (p *PixOperator) Writer(off int) io.Writer {
+	return p.sequential(off)
+}
+
+// Reader is the reading counterpart of Writer.
+func (p *PixOperator) Reader(off int) io.Reader {
+	return p.sequential(off)
+}
+
+func (p *PixOperator) sequential(off int) *pixStream {
+	s := &pixStream{p: p, c: p.cursor()}
+	if off >= 0 && off <= p.Capacity() {
+		s.c.skip(off * 8)
+		s.left = p.Capacity() - off
+	}
+	return s
+}
+
+type pixStream struct {
+	p    *PixOperator
+	c    *cursor
+	left int
+}
+
+func (s *pixStream) Write(b []byte) (int, error) {
+	n := min(len(b), s.left)
+	s.p.write(s.c, b[:n])
+	s.left -= n
+	if n < len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (s *pixStream) Read(b []byte) (int, error) {
+	if s.left == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(b), s.left)
+	s.p.read(s.c, b[:n])
+	s.left -= n
+	return n, nil
+}
+
+// cursor walks the bit stream of a PixOperator one bit at a time.
+type cursor struct {
+	p    *PixOperator
+	pos  int // visited position of the current pixel
+	base int // index of the current pixel in Pix
+	ch   int // next entry of p.channels to look at
+	idx  int // index in Pix of the current sample
+	used int // bits of the current sample already consumed
+}
+
+func (p *PixOperator) cursor() *cursor {
+	return &cursor{p: p, pos: -1, ch: len(p.channels), used: p.Layout.Bits}
+}
+
+// next returns the index in Pix and the bit position of the next stream bit.
+// Callers check the capacity first.
+func (c *cursor) next() (int, uint) {
+	if c.used == c.p.Layout.Bits {
+		c.advance()
+	}
+	
+	shift := uint(c.p.Layout.Bits - 1 - c.used)
+	c.used++
+	return c.idx, shift
+}
+
+func (c *cursor) skip(n int) {
+	for i := 0; i < n; i++ {
+		c.next()
+	}
+}
+
+func (c *cursor) advance() {
+	for c.pos < c.p.pixels() {
+		for c.ch < len(c.p.channels) {
+			ch := c.p.channels[c.ch]
+			c.ch++
+			if c.p.usable(c.base, ch) {
+				c.idx, c.used = c.p.sample(c.base, ch), 0
+				return
+			}
+		}
+		
+		c.pos++
+		if c.pos < c.p.pixels() {
+			c.base, c.ch = c.p.pixel(c.pos)*c.p.stride(), 0
+			if c.p.isReserved(c.pos, c.base) {
+				c.ch = len(c.p.channels)
+			}
+		}
+	}
 }
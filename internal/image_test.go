@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"image/color"
+	"image/png"
+	mrand "math/rand"
+	"testing"
+)
+
+// TestLayouts fills every layout to capacity, one byte over fails, and the
+// layout is read back from the header.
+func TestLayouts(t *testing.T) {
+	img := testImage(120, 90)
+	for bits := 1; bits <= 4; bits++ {
+		for _, ch := range []ChannelMask{ChannelsRGB, ChannelsRGBA, ChannelG, ChannelR | ChannelA} {
+			for _, tr := range []Traversal{TraversalSequential, TraversalScattered} {
+				opts := Options{Traversal: tr, Layout: Layout{Bits: bits, Channels: ch}, Compression: CompressionNone}
+				// The extension takes a length byte and ".bin".
+				payload := bytes.Repeat([]byte{0xA5, 0x3C, 0x7E}, 20000)[:Capacity(img, opts)-5]
+
+				out, err := EmbedData(img, payload, ".bin", 0, "secret1", opts)
+				if err != nil {
+					t.Fatalf("%d bits %v %v: %v", bits, ch, tr, err)
+				}
+				if _, err := EmbedData(img, append(payload, 1), ".bin", 0, "secret1", opts); err != ErrImageTooSmall {
+					t.Fatalf("%d bits %v %v: one byte over: got %v", bits, ch, tr, err)
+				}
+
+				data, ext, _, err := ExtractData(out, 0, "secret1", Options{})
+				if err != nil || !bytes.Equal(data, payload) || ext != ".bin" {
+					t.Fatalf("%d bits %v %v: %v", bits, ch, tr, err)
+				}
+				if ch&ChannelA == 0 {
+					for i := 3; i < len(out.(*image.NRGBA).Pix); i += 4 {
+						if out.(*image.NRGBA).Pix[i] != 255 {
+							t.Fatalf("%d bits %v %v: alpha changed", bits, ch, tr)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTransparentPixels(t *testing.T) {
+	img := testImage(100, 80)
+	for i := 3; i < len(img.Pix); i += 4 {
+		switch (i / 4) % 5 {
+		case 0:
+			img.Pix[i-3], img.Pix[i-2], img.Pix[i-1], img.Pix[i] = 0, 0, 0, 0
+		case 1:
+			img.Pix[i] = 2
+		case 2:
+			img.Pix[i] = 128
+		}
+	}
+
+	for _, ch := range []ChannelMask{ChannelsRGB, ChannelsRGBA} {
+		for _, tr := range []Traversal{TraversalSequential, TraversalScattered} {
+			opts := Options{Traversal: tr, Layout: Layout{Bits: 2, Channels: ch}, Compression: CompressionNone}
+			payload := bytes.Repeat([]byte{0xFF, 0x00, 0x5A}, 20000)[:Capacity(img, opts)-1]
+
+			out, err := EmbedData(img, payload, "", 0, "secret1", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := EmbedData(img, append(payload, 1), "", 0, "secret1", opts); err != ErrImageTooSmall {
+				t.Fatalf("one byte over: got %v", err)
+			}
+
+			pix := out.(*image.NRGBA).Pix
+			for i := 0; i < len(pix); i += 4 {
+				if img.Pix[i+3] == 0 && !bytes.Equal(pix[i:i+4], img.Pix[i:i+4]) {
+					t.Fatalf("%v %v: transparent pixel %d changed", ch, tr, i/4)
+				}
+				if (pix[i+3] == 0) != (img.Pix[i+3] == 0) {
+					t.Fatalf("%v %v: pixel %d changed transparency", ch, tr, i/4)
+				}
+			}
+
+			data, _, _, err := ExtractData(out, 0, "secret1", Options{})
+			if err != nil || !bytes.Equal(data, payload) {
+				t.Fatalf("%v %v: %v", ch, tr, err)
+			}
+		}
+	}
+}
+
+func TestOpaqueAlpha(t *testing.T) {
+	img := testImage(50, 50)
+	rgba := Capacity(img, Options{Layout: Layout{2, ChannelsRGBA}})
+	rgb := Capacity(img, Options{Layout: Layout{2, ChannelsRGB}})
+	raw := Capacity(img, Options{Layout: Layout{2, ChannelsRGBA}, Alpha: AlphaRaw})
+	if rgba != rgb || raw <= rgba {
+		t.Fatalf("capacity RGBA %d, RGB %d, raw alpha %d", rgba, rgb, raw)
+	}
+
+	out, err := EmbedData(img, []byte("raw"), "", 0, "secret1", Options{Layout: Layout{2, ChannelsRGBA}, Alpha: AlphaRaw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, _, err := ExtractData(out, 0, "secret1", Options{})
+	if err != nil || string(data) != "raw" {
+		t.Fatal(err, string(data))
+	}
+}
+
+func TestFormats(t *testing.T) {
+	src := testImage(90, 70)
+	for _, f := range []Format{FormatBMP, FormatTIFF, FormatWebP} {
+		var in bytes.Buffer
+		if err := (&Picture{Image: src, Format: f}).encode(&in); err != nil {
+			t.Fatal(err)
+		}
+		img, err := DecodeImage(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := img.(*Picture); !ok || p.Format != f {
+			t.Fatalf("format %d decoded as %T", f, img)
+		}
+
+		data := bytes.Repeat([]byte{1, 2, 3}, Capacity(img, Options{})/6)
+		for _, tr := range []Traversal{TraversalSequential, TraversalScattered} {
+			out, err := EmbedData(img, data, ".bin", 0, "secret1", Options{Traversal: tr, Compression: CompressionNone})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeCarrier(&buf, out); err != nil {
+				t.Fatal(err)
+			}
+			back, err := DecodeImage(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ImageExtension(back) != ImageExtension(img) {
+				t.Fatalf("format %d written as %s", f, ImageExtension(back))
+			}
+			got, ext, _, err := ExtractData(back, 0, "secret1", Options{})
+			if err != nil || ext != ".bin" || !bytes.Equal(got, data) {
+				t.Fatalf("format %d %v: %v", f, tr, err)
+			}
+		}
+	}
+}
+
+func testWideImages() []image.Image {
+	r := mrand.New(mrand.NewSource(3))
+
+	rgba := image.NewRGBA64(image.Rect(0, 0, 120, 90))
+	r.Read(rgba.Pix)
+	for i := 6; i < len(rgba.Pix); i += 8 {
+		rgba.Pix[i], rgba.Pix[i+1] = 0xFF, 0xFF
+	}
+
+	nrgba := image.NewNRGBA64(image.Rect(0, 0, 120, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			nrgba.SetNRGBA64(x, y, color.NRGBA64{uint16(r.Intn(65536)), uint16(x * 500), uint16(y * 700), uint16(x * 550)})
+		}
+	}
+
+	return []image.Image{rgba, nrgba}
+}
+
+// TestGray16 checks that 16-bit grayscale stays out of the 16-bit path.
+func TestGray16(t *testing.T) {
+	src := image.NewGray16(image.Rect(0, 0, 120, 90))
+	mrand.New(mrand.NewSource(3)).Read(src.Pix)
+	if is16Bit(src) {
+		t.Fatal("Gray16 counts as 16-bit")
+	}
+	out, err := EmbedData(src, []byte("gray"), "", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out.(*image.NRGBA); !ok {
+		t.Fatalf("embedded into a %T, want *image.NRGBA", out)
+	}
+}
+
+// TestWide checks that 16-bit images keep their depth, take twice the bits
+// of a layout and never have their high bytes changed.
+func TestWide(t *testing.T) {
+	for _, src := range testWideImages() {
+		var in bytes.Buffer
+		if err := png.Encode(&in, src); err != nil {
+			t.Fatal(err)
+		}
+		img, err := DecodeImage(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Capacity(img, Options{}) <= Capacity(format(src), Options{}) {
+			t.Fatalf("%T: no more capacity than at 8 bits", src)
+		}
+
+		for _, opts := range []Options{
+			{},
+			{Traversal: TraversalScattered, Layout: Layout{Bits: 4, Channels: ChannelsRGBA}, Alpha: AlphaRaw},
+			{Layout: Layout{Bits: 1, Channels: ChannelsRGB}, Redundancy: RedundancyMedium},
+		} {
+			opts.Compression = CompressionNone
+			data := bytes.Repeat([]byte{0xC3, 0x19}, 1<<16)[:Capacity(img, opts)-1]
+			if _, err := EmbedData(img, append(data, 1), "", 0, "secret1", opts); err != ErrImageTooSmall {
+				t.Fatalf("%T: one byte over: got %v, want %v", src, err, ErrImageTooSmall)
+			}
+			out, err := EmbedData(img, data, "", 0, "secret1", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wide, ok := out.(*image.NRGBA64)
+			if !ok {
+				t.Fatalf("%T: embedded into a %T", src, out)
+			}
+			orig := format64(src)
+			for i := 0; i < len(orig.Pix); i += 2 {
+				if orig.Pix[i] != wide.Pix[i] {
+					t.Fatalf("%T: high byte %d changed", src, i)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeCarrier(&buf, out); err != nil {
+				t.Fatal(err)
+			}
+			back, err := DecodeImage(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, _, err := ExtractData(back, 0, "secret1", Options{})
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("%T %+v: %v", src, opts, err)
+			}
+		}
+
+		if _, err := EmbedData(img, []byte("x"), "", 0, "secret1", Options{Layout: Layout{Bits: maxBits + 1, Channels: ChannelsRGB}}); err != ErrInvalidLayout {
+			t.Fatalf("%T: got %v, want %v", src, err, ErrInvalidLayout)
+		}
+	}
+}
+
+func TestMatching(t *testing.T) {
+	src := halfTextured(120, 90)
+	// Some transparency and samples at the ends of the range.
+	for i := 0; i < len(src.Pix); i += 4 {
+		switch i / 4 % 11 {
+		case 0:
+			src.Pix[i+3] = 0
+		case 1:
+			src.Pix[i+3] = 15
+		case 2:
+			src.Pix[i+3] = 16
+		case 3:
+			src.Pix[i+3] = 4
+		case 4:
+			src.Pix[i], src.Pix[i+1], src.Pix[i+2] = 0, 255, 1
+		}
+	}
+	data := make([]byte, 600)
+	rand.Read(data)
+	for bits := 1; bits <= 4; bits++ {
+		for _, coding := range []Coding{CodingPlain, HammingCoding(2), HammingCoding(4), CodingAdaptive} {
+			for _, alpha := range []AlphaPolicy{AlphaPreserve, AlphaRaw} {
+				for _, tr := range []Traversal{TraversalSequential, TraversalScattered} {
+					opts := Options{Layout: Layout{Bits: bits, Channels: ChannelsRGBA}, Alpha: alpha, Coding: coding, Matching: true, Traversal: tr, Compression: CompressionNone}
+					out, err := EmbedData(src, data, "", 0, "secret1", opts)
+					if err == ErrImageTooSmall {
+						continue
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, _, _, err := ExtractData(out, 0, "secret1", Options{})
+					if err != nil || !bytes.Equal(got, data) {
+						t.Fatalf("%d bits, coding %#x, alpha %d, %v: %v", bits, coding, alpha, tr, err)
+					}
+					// Away from the ends of the range, a plain write moves a
+					// sample by at most half the period of its low bits.
+					o := out.(*image.NRGBA)
+					for i := range o.Pix {
+						d := int(o.Pix[i]) - int(src.Pix[i])
+						if d < 0 {
+							d = -d
+						}
+						if d > max(1<<bits/2, 2) && int(src.Pix[i]) >= 1<<bits && int(src.Pix[i]) <= 255-1<<bits && i%4 != 3 && coding == CodingPlain {
+							t.Fatalf("%d bits: sample %d moved from %d to %d", bits, i, src.Pix[i], o.Pix[i])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestMatchingCrossesPairs checks that matching moves samples both ways,
+// where replacement keeps every value within its pair.
+func TestMatchingCrossesPairs(t *testing.T) {
+	noisy := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	r := mrand.New(mrand.NewSource(3))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			noisy.SetNRGBA(x, y, color.NRGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255})
+		}
+	}
+	big := make([]byte, 3000)
+	rand.Read(big)
+	opts := Options{Layout: Layout{Bits: 1, Channels: ChannelsRGB}, Compression: CompressionNone, Matching: true}
+	out, err := EmbedData(noisy, big, "", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossed := 0
+	for i, v := range out.(*image.NRGBA).Pix {
+		if v>>1 != noisy.Pix[i]>>1 {
+			crossed++
+		}
+	}
+	if crossed == 0 {
+		t.Fatal("no sample left its pair")
+	}
+
+	replaced, err := EmbedData(noisy, big, "", 0, "secret1", Options{Layout: opts.Layout, Compression: CompressionNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header in front is written two bits deep.
+	for i, v := range replaced.(*image.NRGBA).Pix {
+		if i >= 4*200 && v>>1 != noisy.Pix[i]>>1 {
+			t.Fatalf("replacement moved sample %d from %d to %d", i, noisy.Pix[i], v)
+		}
+	}
+}
+
+func TestMatchingOptions(t *testing.T) {
+	r := mrand.New(mrand.NewSource(4))
+	data := make([]byte, 600)
+	rand.Read(data)
+
+	// 16-bit images, streams and deniable carriers take the option too.
+	wide := image.NewNRGBA64(image.Rect(0, 0, 60, 60))
+	for i := range wide.Pix {
+		wide.Pix[i] = uint8(r.Intn(256))
+	}
+	for i := 0; i < len(wide.Pix); i += 8 * 5 {
+		wide.Pix[i+1] = 0xFF
+		wide.Pix[i] = 0xFF
+	}
+	for _, coding := range []Coding{CodingPlain, HammingCoding(3), CodingAdaptive} {
+		out, err := EmbedData(wide, data[:200], "", 0, "secret1", Options{Coding: coding, Matching: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _, err := ExtractData(out, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data[:200]) {
+			t.Fatalf("16-bit, coding %#x: %v", coding, err)
+		}
+	}
+
+	src := testImage(100, 100)
+	w, err := NewEmbedWriter(src, ".bin", "secret1", Options{Matching: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(w.Carrier(), 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("stream: %v", err)
+	}
+	dn, err := EmbedDeniable(src, Payload{Data: data, Password: "secret1"}, Payload{Data: []byte("x"), Password: "other1"}, Options{Matching: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(dn, 0, "other1", Options{}); err != nil || string(got) != "x" {
+		t.Fatalf("hidden: %v", err)
+	}
+}
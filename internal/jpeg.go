@@ -0,0 +1,979 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// JPEG is a baseline JPEG kept as its quantized DCT coefficients, so data
+// hidden in them survives being written out as a JPEG again. It implements
+// image.Image by decoding itself on first use.
+type JPEG struct {
+	width, height int
+	components    []jpegComponent
+	quant         [4][64]uint16
+
+	// segments are the APPn and COM segments of the source, written back
+	// unchanged so Exif and color profiles are kept.
+	segments [][]byte
+
+	// coef holds every block of every component as 64 coefficients in
+	// zig-zag order.
+	coef []int16
+
+	decodeOnce sync.Once
+	decoded    image.Image
+}
+
+type jpegComponent struct {
+	id   uint8
+	h, v int
+	tq   uint8
+
+	// blocksW and blocksH cover whole MCUs; offset is the index of the first
+	// block of the component in coef.
+	blocksW, blocksH int
+	offset           int
+}
+
+// maxJPEGCoefficients bounds the memory a hostile header can ask for.
+const maxJPEGCoefficients = 1 << 28
+
+var errJPEGFormat = errors.New("unsupported or corrupt jpeg")
+
+// unzig maps the zig-zag order to the natural order of a block.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type huffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// standardHuffman holds the tables of section K.3 of the JPEG standard:
+// luminance DC and AC, then chrominance DC and AC. They cover every symbol
+// an 8-bit baseline image can need.
+var standardHuffman = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// DecodeJPEG reads the coefficients of a baseline JPEG. Progressive images
+// are decoded to pixels and quantized again with their own tables.
+func DecodeJPEG(r io.Reader) (*JPEG, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := parseJPEG(data)
+	if err != nil {
+		return nil, ErrImageNotSupported
+	}
+	return j, nil
+}
+
+func (j *JPEG) ColorModel() color.Model {
+	return j.image().ColorModel()
+}
+
+func (j *JPEG) Bounds() image.Rectangle {
+	return image.Rect(0, 0, j.width, j.height)
+}
+
+func (j *JPEG) At(x, y int) color.Color {
+	return j.image().At(x, y)
+}
+
+// image decodes the coefficients with the standard library, which is what
+// every viewer will show.
+func (j *JPEG) image() image.Image {
+	j.decodeOnce.Do(func() {
+		var buf bytes.Buffer
+		if err := j.Encode(&buf); err == nil {
+			j.decoded, err = jpeg.Decode(&buf)
+		}
+		if j.decoded == nil {
+			j.decoded = image.NewGray(j.Bounds())
+		}
+	})
+	return j.decoded
+}
+
+func (j *JPEG) clone() *JPEG {
+	return &JPEG{
+		width:      j.width,
+		height:     j.height,
+		components: j.components,
+		quant:      j.quant,
+		segments:   j.segments,
+		coef:       append([]int16(nil), j.coef...),
+	}
+}
+
+func (j *JPEG) maxSampling() (int, int) {
+	hmax, vmax := 1, 1
+	for _, c := range j.components {
+		hmax, vmax = max(hmax, c.h), max(vmax, c.v)
+	}
+	return hmax, vmax
+}
+
+func (j *JPEG) mcus() (int, int) {
+	hmax, vmax := j.maxSampling()
+	return (j.width + 8*hmax - 1) / (8 * hmax), (j.height + 8*vmax - 1) / (8 * vmax)
+}
+
+// scanBlocks is the block grid of c in a scan holding only c, which leaves
+// out the padding of the last MCUs.
+func (j *JPEG) scanBlocks(c *jpegComponent) (int, int) {
+	hmax, vmax := j.maxSampling()
+	w := (j.width*c.h + hmax - 1) / hmax
+	h := (j.height*c.v + vmax - 1) / vmax
+	return (w + 7) / 8, (h + 7) / 8
+}
+
+func (j *JPEG) block(i int) []int16 {
+	return j.coef[i*64 : i*64+64]
+}
+
+// ACCoefficients returns a copy of the quantized AC coefficients of every
+// block, for analysis.
+func (j *JPEG) ACCoefficients() []int16 {
+	out := make([]int16, 0, len(j.coef)/64*63)
+	for i := 0; i < len(j.coef); i += 64 {
+		out = append(out, j.coef[i+1:i+64]...)
+	}
+	return out
+}
+
+func parseJPEG(data []byte) (*JPEG, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errJPEGFormat
+	}
+
+	j := &JPEG{}
+	var dc, ac [4]*huffmanTable
+	restart := 0
+	progressive := false
+	scanned := false
+
+	pos := 2
+	for {
+		if pos+1 >= len(data) && scanned {
+			// Tolerate a missing EOI.
+			return j, nil
+		}
+		if pos+1 >= len(data) || data[pos] != 0xFF {
+			return nil, errJPEGFormat
+		}
+		marker := data[pos+1]
+		pos += 2
+
+		switch {
+		case marker == 0xFF:
+			// Fill byte in front of a marker.
+			pos--
+			continue
+		case marker == 0xD9:
+			if !scanned {
+				return nil, errJPEGFormat
+			}
+			return j, nil
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, errJPEGFormat
+		}
+		n := int(binary.BigEndian.Uint16(data[pos:]))
+		if n < 2 || pos+n > len(data) {
+			return nil, errJPEGFormat
+		}
+		seg := data[pos+2 : pos+n]
+
+		var err error
+		switch {
+		case marker >= 0xE0 && marker <= 0xEF, marker == 0xFE:
+			j.segments = append(j.segments, data[pos-2:pos+n])
+		case marker == 0xDB:
+			err = j.parseDQT(seg)
+		case marker == 0xC4:
+			err = parseDHT(seg, &dc, &ac)
+		case marker == 0xC0, marker == 0xC1, marker == 0xC2:
+			if j.components != nil {
+				return nil, errJPEGFormat
+			}
+			progressive = marker == 0xC2
+			err = j.parseSOF(seg)
+		case marker == 0xDD:
+			if len(seg) < 2 {
+				return nil, errJPEGFormat
+			}
+			restart = int(binary.BigEndian.Uint16(seg))
+		case marker == 0xDA:
+			if j.components == nil {
+				return nil, errJPEGFormat
+			}
+			if progressive {
+				return j.fromPixels(data)
+			}
+			if pos, err = j.decodeScan(data, pos+n, seg, &dc, &ac, restart); err != nil {
+				return nil, err
+			}
+			scanned = true
+			continue
+		case marker >= 0xC3 && marker <= 0xCF:
+			// Lossless, hierarchical and arithmetic coded images.
+			return nil, errJPEGFormat
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+}
+
+func (j *JPEG) parseDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		if tq > 3 || pq > 1 {
+			return errJPEGFormat
+		}
+		seg = seg[1:]
+
+		size := 64 * (1 + int(pq))
+		if len(seg) < size {
+			return errJPEGFormat
+		}
+		for k := 0; k < 64; k++ {
+			if pq == 0 {
+				j.quant[tq][k] = uint16(seg[k])
+			} else {
+				j.quant[tq][k] = binary.BigEndian.Uint16(seg[2*k:])
+			}
+		}
+		seg = seg[size:]
+	}
+	return nil
+}
+
+func parseDHT(seg []byte, dc, ac *[4]*huffmanTable) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return errJPEGFormat
+		}
+		tc, th := seg[0]>>4, seg[0]&15
+		if tc > 1 || th > 3 {
+			return errJPEGFormat
+		}
+
+		var spec huffmanSpec
+		copy(spec.counts[:], seg[1:17])
+		total := 0
+		for _, n := range spec.counts {
+			total += int(n)
+		}
+		if len(seg) < 17+total {
+			return errJPEGFormat
+		}
+		spec.values = seg[17 : 17+total]
+		seg = seg[17+total:]
+
+		t, err := newHuffmanTable(spec)
+		if err != nil {
+			return err
+		}
+		if tc == 0 {
+			dc[th] = t
+		} else {
+			ac[th] = t
+		}
+	}
+	return nil
+}
+
+func (j *JPEG) parseSOF(seg []byte) error {
+	if len(seg) < 6 || seg[0] != 8 {
+		return errJPEGFormat
+	}
+	j.height = int(binary.BigEndian.Uint16(seg[1:]))
+	j.width = int(binary.BigEndian.Uint16(seg[3:]))
+	n := int(seg[5])
+	if j.width == 0 || j.height == 0 || n == 0 || n > 4 || len(seg) < 6+3*n {
+		return errJPEGFormat
+	}
+
+	j.components = make([]jpegComponent, n)
+	for i := range j.components {
+		c := &j.components[i]
+		c.id = seg[6+3*i]
+		c.h, c.v = int(seg[7+3*i]>>4), int(seg[7+3*i]&15)
+		c.tq = seg[8+3*i]
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errJPEGFormat
+		}
+	}
+
+	mcusX, mcusY := j.mcus()
+	blocks := 0
+	for i := range j.components {
+		c := &j.components[i]
+		c.blocksW, c.blocksH = mcusX*c.h, mcusY*c.v
+		c.offset = blocks
+		blocks += c.blocksW * c.blocksH
+	}
+	if blocks*64 > maxJPEGCoefficients {
+		return errJPEGFormat
+	}
+	j.coef = make([]int16, blocks*64)
+	return nil
+}
+
+// scanEnd returns the position of the marker that ends the entropy-coded
+// data starting at pos. Stuffed bytes and restart markers are part of it.
+func scanEnd(data []byte, pos int) int {
+	for pos+1 < len(data) {
+		if data[pos] == 0xFF {
+			next := data[pos+1]
+			if next != 0 && (next < 0xD0 || next > 0xD7) {
+				return pos
+			}
+			pos++
+		}
+		pos++
+	}
+	return len(data)
+}
+
+func (j *JPEG) decodeScan(data []byte, start int, seg []byte, dc, ac *[4]*huffmanTable, restart int) (int, error) {
+	if len(seg) < 1 {
+		return 0, errJPEGFormat
+	}
+	ns := int(seg[0])
+	if ns < 1 || ns > 4 || len(seg) < 1+2*ns+3 {
+		return 0, errJPEGFormat
+	}
+
+	comps := make([]*jpegComponent, ns)
+	dcs := make([]*huffmanTable, ns)
+	acs := make([]*huffmanTable, ns)
+	for i := 0; i < ns; i++ {
+		for k := range j.components {
+			if j.components[k].id == seg[1+2*i] {
+				comps[i] = &j.components[k]
+			}
+		}
+		td, ta := seg[2+2*i]>>4, seg[2+2*i]&15
+		if comps[i] == nil || td > 3 || ta > 3 || dc[td] == nil || ac[ta] == nil {
+			return 0, errJPEGFormat
+		}
+		dcs[i], acs[i] = dc[td], ac[ta]
+	}
+
+	end := scanEnd(data, start)
+	r := &bitReader{data: data[:end], pos: start}
+	preds := make([]int32, ns)
+
+	if ns == 1 {
+		c := comps[0]
+		bw, bh := j.scanBlocks(c)
+		for i := 0; i < bw*bh; i++ {
+			if restart > 0 && i > 0 && i%restart == 0 {
+				r.restart()
+				preds[0] = 0
+			}
+			b := c.offset + (i/bw)*c.blocksW + i%bw
+			if err := r.decodeBlock(j.block(b), dcs[0], acs[0], &preds[0]); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	}
+
+	mcusX, mcusY := j.mcus()
+	for m := 0; m < mcusX*mcusY; m++ {
+		if restart > 0 && m > 0 && m%restart == 0 {
+			r.restart()
+			clear(preds)
+		}
+		mx, my := m%mcusX, m/mcusX
+		for i, c := range comps {
+			for y := 0; y < c.v; y++ {
+				for x := 0; x < c.h; x++ {
+					b := c.offset + (my*c.v+y)*c.blocksW + mx*c.h + x
+					if err := r.decodeBlock(j.block(b), dcs[i], acs[i], &preds[i]); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+	}
+	return end, nil
+}
+
+// fromPixels fills the coefficients from the decoded image, for images whose
+// entropy coding is not read directly. The quantization tables and sampling
+// of the source are kept.
+func (j *JPEG) fromPixels(data []byte) (*JPEG, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errJPEGFormat
+	}
+
+	var sample func(c, x, y int) float64
+	switch img := img.(type) {
+	case *image.Gray:
+		if len(j.components) != 1 {
+			return nil, errJPEGFormat
+		}
+		sample = func(_, x, y int) float64 {
+			return float64(img.GrayAt(x, y).Y)
+		}
+	case *image.YCbCr:
+		if len(j.components) != 3 {
+			return nil, errJPEGFormat
+		}
+		sample = func(c, x, y int) float64 {
+			p := img.YCbCrAt(x, y)
+			return float64([3]uint8{p.Y, p.Cb, p.Cr}[c])
+		}
+	default:
+		return nil, errJPEGFormat
+	}
+
+	hmax, vmax := j.maxSampling()
+	for ci := range j.components {
+		c := &j.components[ci]
+		if hmax%c.h != 0 || vmax%c.v != 0 {
+			return nil, errJPEGFormat
+		}
+		q := &j.quant[c.tq]
+		for _, v := range q {
+			if v == 0 {
+				return nil, errJPEGFormat
+			}
+		}
+
+		sx, sy := hmax/c.h, vmax/c.v
+		for by := 0; by < c.blocksH; by++ {
+			for bx := 0; bx < c.blocksW; bx++ {
+				var block [64]float64
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						// Average the pixels under the sample, repeating the
+						// last row and column past the edges.
+						sum := 0.0
+						for dy := 0; dy < sy; dy++ {
+							for dx := 0; dx < sx; dx++ {
+								px := min((bx*8+x)*sx+dx, j.width-1)
+								py := min((by*8+y)*sy+dy, j.height-1)
+								sum += sample(ci, px, py)
+							}
+						}
+						block[y*8+x] = sum/float64(sx*sy) - 128
+					}
+				}
+
+				out := fdct(&block)
+				blk := j.block(c.offset + by*c.blocksW + bx)
+				for k := range blk {
+					blk[k] = int16(math.Round(out[unzig[k]] / float64(q[k])))
+				}
+			}
+		}
+	}
+
+	j.decodeOnce.Do(func() { j.decoded = img })
+	return j, nil
+}
+
+var dctCos = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// fdct is the forward 8x8 DCT of a level-shifted block in natural order.
+func fdct(in *[64]float64) (out [64]float64) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			s := 0.0
+			for x := 0; x < 8; x++ {
+				s += in[y*8+x] * dctCos[u][x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			s := 0.0
+			for y := 0; y < 8; y++ {
+				s += tmp[y*8+u] * dctCos[v][y]
+			}
+			out[v*8+u] = s
+		}
+	}
+	return out
+}
+
+// Encode writes j as a baseline JPEG with the standard Huffman tables.
+func (j *JPEG) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, s := range j.segments {
+		buf.Write(s)
+	}
+
+	sof := byte(0xC0)
+	var written [4]bool
+	for _, c := range j.components {
+		if written[c.tq] {
+			continue
+		}
+		written[c.tq] = true
+
+		q := &j.quant[c.tq]
+		wide := false
+		for _, v := range q {
+			wide = wide || v > 255
+		}
+		if wide {
+			sof = 0xC1
+			writeSegment(&buf, 0xDB, 1+128, func(b *bytes.Buffer) {
+				b.WriteByte(0x10 | c.tq)
+				for _, v := range q {
+					b.Write(binary.BigEndian.AppendUint16(nil, v))
+				}
+			})
+		} else {
+			writeSegment(&buf, 0xDB, 1+64, func(b *bytes.Buffer) {
+				b.WriteByte(c.tq)
+				for _, v := range q {
+					b.WriteByte(byte(v))
+				}
+			})
+		}
+	}
+
+	writeSegment(&buf, sof, 6+3*len(j.components), func(b *bytes.Buffer) {
+		b.WriteByte(8)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(j.height)))
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(j.width)))
+		b.WriteByte(byte(len(j.components)))
+		for _, c := range j.components {
+			b.Write([]byte{c.id, byte(c.h<<4 | c.v), c.tq})
+		}
+	})
+
+	var codes [4]*huffmanCodes
+	for i, spec := range standardHuffman {
+		codes[i] = newHuffmanCodes(spec)
+		class := byte(i%2) << 4
+		id := byte(i / 2)
+		writeSegment(&buf, 0xC4, 17+len(spec.values), func(b *bytes.Buffer) {
+			b.WriteByte(class | id)
+			b.Write(spec.counts[:])
+			b.Write(spec.values)
+		})
+	}
+
+	// One interleaved scan when the MCU is small enough for it, otherwise
+	// one scan per component.
+	units := 0
+	for _, c := range j.components {
+		units += c.h * c.v
+	}
+	var err error
+	if len(j.components) > 1 && units <= 10 {
+		err = j.encodeScan(&buf, codes, j.components)
+	} else {
+		for i := range j.components {
+			if err = j.encodeScan(&buf, codes, j.components[i:i+1]); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	buf.Write([]byte{0xFF, 0xD9})
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeSegment(buf *bytes.Buffer, marker byte, n int, body func(*bytes.Buffer)) {
+	buf.Write([]byte{0xFF, marker})
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n+2)))
+	body(buf)
+}
+
+// tables returns the DC and AC tables of a component: luminance for the
+// first one and chrominance for the rest.
+func (j *JPEG) tables(c *jpegComponent) byte {
+	if c.id == j.components[0].id {
+		return 0
+	}
+	return 1
+}
+
+func (j *JPEG) encodeScan(buf *bytes.Buffer, codes [4]*huffmanCodes, comps []jpegComponent) error {
+	writeSegment(buf, 0xDA, 1+2*len(comps)+3, func(b *bytes.Buffer) {
+		b.WriteByte(byte(len(comps)))
+		for i := range comps {
+			t := j.tables(&comps[i])
+			b.Write([]byte{comps[i].id, t<<4 | t})
+		}
+		b.Write([]byte{0, 63, 0})
+	})
+
+	w := &bitWriter{buf: buf}
+	preds := make([]int32, len(comps))
+	encode := func(i, b int) error {
+		t := j.tables(&comps[i])
+		return w.encodeBlock(j.block(b), &preds[i], codes[2*t], codes[2*t+1])
+	}
+
+	if len(comps) == 1 {
+		c := &comps[0]
+		bw, bh := j.scanBlocks(c)
+		for i := 0; i < bw*bh; i++ {
+			if err := encode(0, c.offset+(i/bw)*c.blocksW+i%bw); err != nil {
+				return err
+			}
+		}
+	} else {
+		mcusX, mcusY := j.mcus()
+		for m := 0; m < mcusX*mcusY; m++ {
+			mx, my := m%mcusX, m/mcusX
+			for i := range comps {
+				c := &comps[i]
+				for y := 0; y < c.v; y++ {
+					for x := 0; x < c.h; x++ {
+						if err := encode(i, c.offset+(my*c.v+y)*c.blocksW+mx*c.h+x); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+	w.flush()
+	return nil
+}
+
+// huffmanTable decodes the canonical codes of a huffmanSpec as described in
+// section F.2.2.3 of the standard.
+type huffmanTable struct {
+	maxCode [17]int32
+	minCode [17]int32
+	valPtr  [17]int32
+	values  []byte
+}
+
+func newHuffmanTable(spec huffmanSpec) (*huffmanTable, error) {
+	t := &huffmanTable{values: spec.values}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(spec.counts[l-1])
+		t.valPtr[l], t.minCode[l] = k, code
+		t.maxCode[l] = -1
+		if n > 0 {
+			t.maxCode[l] = code + n - 1
+		}
+		code += n
+		k += n
+		if code > 1<<l {
+			return nil, errJPEGFormat
+		}
+		code <<= 1
+	}
+	return t, nil
+}
+
+type huffmanCodes struct {
+	code [256]uint16
+	size [256]uint8
+}
+
+func newHuffmanCodes(spec huffmanSpec) *huffmanCodes {
+	c := &huffmanCodes{}
+	code, k := 0, 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(spec.counts[l-1]); i++ {
+			c.code[spec.values[k]] = uint16(code)
+			c.size[spec.values[k]] = uint8(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return c
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    uint
+}
+
+func (r *bitReader) bit() int32 {
+	if r.n == 0 {
+		// Past the end of the data the stream reads as zeros.
+		var c byte
+		if r.pos < len(r.data) {
+			c = r.data[r.pos]
+			r.pos++
+			if c == 0xFF {
+				if r.pos < len(r.data) && r.data[r.pos] == 0 {
+					r.pos++
+				} else {
+					r.pos--
+					c = 0
+				}
+			}
+		}
+		r.acc, r.n = uint32(c), 8
+	}
+	r.n--
+	return int32(r.acc >> r.n & 1)
+}
+
+func (r *bitReader) receive(s int) int32 {
+	v := int32(0)
+	for i := 0; i < s; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+// restart drops the bits left in the current byte and skips the restart
+// marker that follows.
+func (r *bitReader) restart() {
+	r.n = 0
+	if r.pos+1 < len(r.data) && r.data[r.pos] == 0xFF && r.data[r.pos+1] >= 0xD0 && r.data[r.pos+1] <= 0xD7 {
+		r.pos += 2
+	}
+}
+
+func (r *bitReader) decode(t *huffmanTable) (byte, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | r.bit()
+		if code <= t.maxCode[l] {
+			i := t.valPtr[l] + code - t.minCode[l]
+			if int(i) >= len(t.values) {
+				return 0, errJPEGFormat
+			}
+			return t.values[i], nil
+		}
+	}
+	return 0, errJPEGFormat
+}
+
+func extend(v int32, s int) int32 {
+	if s > 0 && v < 1<<(s-1) {
+		return v - 1<<s + 1
+	}
+	return v
+}
+
+func (r *bitReader) decodeBlock(blk []int16, dc, ac *huffmanTable, pred *int32) error {
+	s, err := r.decode(dc)
+	if err != nil || s > 11 {
+		return errJPEGFormat
+	}
+	*pred += extend(r.receive(int(s)), int(s))
+	blk[0] = int16(*pred)
+
+	for k := 1; k < 64; {
+		rs, err := r.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), int(rs&15)
+		if size == 0 {
+			if run != 15 {
+				break
+			}
+			k += 16
+			continue
+		}
+
+		k += run
+		if k > 63 || size > 10 {
+			return errJPEGFormat
+		}
+		blk[k] = int16(extend(r.receive(size), size))
+		k++
+	}
+	return nil
+}
+
+type bitWriter struct {
+	buf *bytes.Buffer
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc = w.acc<<n | uint64(v)&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		c := byte(w.acc >> (w.n - 8))
+		w.buf.WriteByte(c)
+		if c == 0xFF {
+			w.buf.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with ones.
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+// emit writes the code of symbol followed by the size low bits of v.
+func (w *bitWriter) emit(codes *huffmanCodes, symbol byte, v int32, size int) error {
+	if codes.size[symbol] == 0 {
+		return errJPEGFormat
+	}
+	w.write(uint32(codes.code[symbol]), uint(codes.size[symbol]))
+	if size > 0 {
+		if v < 0 {
+			v--
+		}
+		w.write(uint32(v), uint(size))
+	}
+	return nil
+}
+
+func magnitude(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	return bits.Len32(uint32(v))
+}
+
+func (w *bitWriter) encodeBlock(blk []int16, pred *int32, dc, ac *huffmanCodes) error {
+	diff := int32(blk[0]) - *pred
+	*pred = int32(blk[0])
+	if err := w.emit(dc, byte(magnitude(diff)), diff, magnitude(diff)); err != nil {
+		return err
+	}
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		v := int32(blk[k])
+		if v == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			if err := w.emit(ac, 0xF0, 0, 0); err != nil {
+				return err
+			}
+		}
+
+		size := magnitude(v)
+		if size > 10 {
+			return errJPEGFormat
+		}
+		if err := w.emit(ac, byte(run<<4|size), v, size); err != nil {
+			return err
+		}
+		run = 0
+	}
+	if run > 0 {
+		return w.emit(ac, 0x00, 0, 0)
+	}
+	return nil
+}
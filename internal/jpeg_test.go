@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"slices"
+	"testing"
+)
+
+func jpegBytes(t *testing.T, img image.Image, quality int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testJPEG(t *testing.T) Carrier {
+	src := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			src.Set(x, y, color.RGBA{uint8(x*x/7 + y), uint8(y * 3), uint8((x ^ y) * 5), 255})
+		}
+	}
+
+	img, err := DecodeImage(bytes.NewReader(jpegBytes(t, src, 90)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*JPEG); !ok {
+		t.Fatalf("decoded a %T", img)
+	}
+	return img
+}
+
+// TestJPEGCodec checks that decoding matches image/jpeg and that encoding
+// keeps every coefficient.
+func TestJPEGCodec(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 97, 61))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7 % 251)
+	}
+
+	for _, src := range []image.Image{testImage(203, 151), gray} {
+		data := jpegBytes(t, src, 85)
+		j, err := DecodeJPEG(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ref, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := ref.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r1, g1, b1, _ := ref.At(x, y).RGBA()
+				r2, g2, b2, _ := j.At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 {
+					t.Fatalf("pixel %d,%d differs", x, y)
+				}
+			}
+		}
+
+		var out bytes.Buffer
+		if err := j.Encode(&out); err != nil {
+			t.Fatal(err)
+		}
+		again, err := DecodeJPEG(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(j.coef, again.coef) {
+			t.Fatal("coefficients changed")
+		}
+	}
+}
+
+func TestEmbedJPEG(t *testing.T) {
+	img := testJPEG(t)
+	for _, opts := range []Options{{}, {Traversal: TraversalScattered}, {Traversal: TraversalScattered, Redundancy: RedundancyLow}} {
+		msg := bytes.Repeat([]byte("zuon jpeg "), Capacity(img, opts)/40)
+		out, err := EmbedData(img, msg, ".txt", 0, "secret1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeCarrier(&buf, out); err != nil {
+			t.Fatal(err)
+		}
+		back, err := DecodeImage(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, ext, _, err := ExtractData(back, 0, "secret1", Options{})
+		if err != nil || !bytes.Equal(data, msg) || ext != ".txt" {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if opts.Traversal == TraversalSequential {
+			if h, _, err := Inspect(back, 0, ""); err != nil || h.Flags&FlagDCT == 0 {
+				t.Fatalf("Inspect: %+v %v", h, err)
+			}
+		}
+		if _, _, _, err := ExtractData(back, 0, "wrongpw", Options{}); err == nil {
+			t.Fatal("opened with the wrong password")
+		}
+	}
+}
+
+func TestJPEGCapacity(t *testing.T) {
+	img := testJPEG(t)
+	opts := Options{Compression: CompressionNone}
+	data := bytes.Repeat([]byte{0x42}, Capacity(img, opts)-1)
+
+	if _, err := EmbedData(img, append(data, 1), "", 0, "secret1", opts); err != ErrImageTooSmall {
+		t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+	}
+	out, err := EmbedData(img, data, "", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, _, err := ExtractData(out, 0, "secret1", Options{})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal(err)
+	}
+}
+
+func TestJPEGShards(t *testing.T) {
+	img := testJPEG(t)
+	out, err := EmbedShards([]Carrier{img, img}, []byte("split"), "", "secret1", Options{Traversal: TraversalScattered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, _, err := ExtractShards(out, "secret1", Options{})
+	if err != nil || string(data) != "split" {
+		t.Fatal(err)
+	}
+}
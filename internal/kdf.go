@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type KDFID uint8
+
+const (
+	KDFPBKDF2SHA256 KDFID = 1
+	KDFArgon2id     KDFID = 2
+	// KDFX25519 wraps a random file key to each recipient instead of
+	// deriving it from a password.
+	KDFX25519 KDFID = 3
+)
+
+const pbkdf2Iterations = 4096
+
+// Upper bounds accepted from a header, which is read before anything is
+// authenticated. They leave room above what Zuon writes, but keep a crafted
+// image from making extraction allocate more than 1 GiB or run for minutes.
+const (
+	maxPBKDF2Iterations = 1000000
+	maxArgon2Time       = 10
+	maxArgon2Memory     = 1 << 20
+	maxArgon2Threads    = 16
+)
+
+// KDF describes how the AES key is derived from the password. Iterations is
+// used by PBKDF2; Time, Memory (KiB) and Threads by Argon2id; Recipients by
+// X25519.
+type KDF struct {
+	ID         KDFID
+	Iterations uint32
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	Recipients uint8
+}
+
+var (
+	DefaultKDF = KDF{ID: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// LegacyKDF is what v1.3 and earlier used for every payload.
+	LegacyKDF = KDF{ID: KDFPBKDF2SHA256, Iterations: pbkdf2Iterations}
+)
+
+func (k KDF) Valid() bool {
+	switch k.ID {
+	case KDFPBKDF2SHA256:
+		return k.Iterations > 0 && k.Iterations <= maxPBKDF2Iterations
+	case KDFArgon2id:
+		return k.Time > 0 && k.Time <= maxArgon2Time &&
+			k.Threads > 0 && k.Threads <= maxArgon2Threads &&
+			k.Memory >= 8*uint32(k.Threads) && k.Memory <= maxArgon2Memory
+	case KDFX25519:
+		return k.Recipients > 0
+	}
+	return false
+}
+
+func (k KDF) saltSize() int {
+	switch k.ID {
+	case KDFPBKDF2SHA256:
+		return 8
+	case KDFX25519:
+		return int(k.Recipients) * stanzaSize
+	}
+	return 16
+}
+
+// overhead is the salt (or recipient stanzas), nonce and tag stored in front of every payload body.
+func (k KDF) overhead() int {
+	return k.saltSize() + 12 + 16
+}
+
+func (k KDF) Key(password string, salt []byte) []byte {
+	if k.ID == KDFArgon2id {
+		return argon2.IDKey([]byte(password), salt, k.Time, k.Memory, k.Threads, 32)
+	}
+	return pbkdf2.Key([]byte(password), salt, int(k.Iterations), 32, sha256.New)
+}
+
+func (k KDF) params() []byte {
+	switch k.ID {
+	case KDFArgon2id:
+		out := binary.BigEndian.AppendUint32(nil, k.Time)
+		out = binary.BigEndian.AppendUint32(out, k.Memory)
+		return append(out, k.Threads)
+	case KDFX25519:
+		return []byte{k.Recipients}
+	}
+	return binary.BigEndian.AppendUint32(nil, k.Iterations)
+}
+
+func parseKDF(id KDFID, params []byte) (KDF, error) {
+	k := KDF{ID: id}
+	switch {
+	case id == KDFPBKDF2SHA256 && len(params) == 4:
+		k.Iterations = binary.BigEndian.Uint32(params)
+	case id == KDFArgon2id && len(params) == 9:
+		k.Time = binary.BigEndian.Uint32(params)
+		k.Memory = binary.BigEndian.Uint32(params[4:])
+		k.Threads = params[8]
+	case id == KDFX25519 && len(params) == 1:
+		k.Recipients = params[0]
+	default:
+		return KDF{}, ErrUnsupportedFormat
+	}
+
+	if !k.Valid() {
+		return KDF{}, ErrUnsupportedFormat
+	}
+	return k, nil
+}
@@ -0,0 +1,53 @@
+package internal
+
+import "testing"
+
+func TestParseKDF(t *testing.T) {
+	for _, k := range []KDF{LegacyKDF, DefaultKDF, {ID: KDFArgon2id, Time: 2, Memory: 1024, Threads: 2}, {ID: KDFX25519, Recipients: 3}} {
+		got, err := parseKDF(k.ID, k.params())
+		if err != nil || got != k {
+			t.Fatalf("parseKDF(%+v) = %+v, %v", k, got, err)
+		}
+	}
+}
+
+// TestParseKDFLimits checks that a header cannot ask for unbounded work.
+func TestParseKDFLimits(t *testing.T) {
+	for _, k := range []KDF{
+		{ID: KDFPBKDF2SHA256},
+		{ID: KDFPBKDF2SHA256, Iterations: maxPBKDF2Iterations + 1},
+		{ID: KDFArgon2id, Time: maxArgon2Time + 1, Memory: 1024, Threads: 1},
+		{ID: KDFArgon2id, Time: 1, Memory: maxArgon2Memory + 1, Threads: 1},
+		{ID: KDFArgon2id, Time: 1, Memory: 1024, Threads: maxArgon2Threads + 1},
+		{ID: KDFArgon2id, Time: 1, Memory: 1024},
+		{ID: KDFArgon2id, Time: 1, Memory: 7, Threads: 1},
+		{ID: KDFX25519},
+		{ID: 0xEE},
+	} {
+		if _, err := parseKDF(k.ID, k.params()); err != ErrUnsupportedFormat {
+			t.Errorf("parseKDF(%+v): got %v, want %v", k, err, ErrUnsupportedFormat)
+		}
+	}
+
+	if _, err := parseKDF(KDFArgon2id, LegacyKDF.params()); err != ErrUnsupportedFormat {
+		t.Errorf("short Argon2id parameters: got %v", err)
+	}
+}
+
+func TestEmbedKDF(t *testing.T) {
+	img := testImage(60, 60)
+	for _, k := range []KDF{LegacyKDF, {ID: KDFPBKDF2SHA256, Iterations: 1000}, {ID: KDFArgon2id, Time: 2, Memory: 1024, Threads: 2}} {
+		out, err := EmbedData(img, []byte("kdf"), "", 0, "secret1", Options{KDF: k})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, _, err := ExtractData(out, 0, "secret1", Options{})
+		if err != nil || string(data) != "kdf" {
+			t.Fatalf("%+v: %v", k, err)
+		}
+	}
+
+	if _, err := EmbedData(img, []byte("kdf"), "", 0, "secret1", Options{KDF: KDF{ID: KDFArgon2id, Time: 1, Memory: 1 << 30, Threads: 1}}); err == nil {
+		t.Fatal("embedded with an invalid KDF")
+	}
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// paletteLayout is recorded in the header of palette carriers, where every
+// usable pixel carries exactly one bit.
+var paletteLayout = Layout{Bits: 1, Channels: ChannelsRGB}
+
+// maxPairDistance is the largest squared RGB distance between two palette
+// colors that may stand in for each other.
+const maxPairDistance = 3 * 24 * 24
+
+// PaletteOperator hides bits in the pixels of a palette image. The opaque
+// colors of the palette are matched into pairs, closest first, and a pixel
+// carries a bit in which color of its pair it uses, so writing a bit at most
+// swaps a pixel to the nearest color the palette allows. The palette is never
+// changed and a pixel never leaves its pair, so extraction finds exactly the
+// positions embedding used.
+type PaletteOperator struct {
+	pix     []uint8
+	partner [256]int16
+	usable  []int32
+
+	// order maps the i-th visited position to an index in usable; nil
+	// visits pixels row by row.
+	order *scatter
+
+	// start is the first position of the stream, past any header.
+	start int
+}
+
+func NewPaletteOperator(img *image.Paletted) *PaletteOperator {
+	op := &PaletteOperator{pix: img.Pix, partner: pairColors(img.Palette)}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.PixOffset(b.Min.X, y)
+		for i := row; i < row+b.Dx(); i++ {
+			if op.partner[img.Pix[i]] >= 0 {
+				op.usable = append(op.usable, int32(i))
+			}
+		}
+	}
+	return op
+}
+
+func newPaletteOperator(img *image.Paletted, t Traversal, key string) *PaletteOperator {
+	op := NewPaletteOperator(img)
+	if t == TraversalScattered {
+		op.Scatter(scatterSeed(key))
+	}
+	return op
+}
+
+// pairColors returns the partner of every palette index, or -1 for colors
+// that are translucent or have no close enough partner.
+func pairColors(p color.Palette) [256]int16 {
+	var partner [256]int16
+	for i := range partner {
+		partner[i] = -1
+	}
+
+	type pair struct {
+		a, b int
+		dist int
+	}
+	var pairs []pair
+	for a := 0; a < len(p) && a < 256; a++ {
+		ra, ga, ba, aa := p[a].RGBA()
+		if aa != 0xffff {
+			continue
+		}
+		for b := a + 1; b < len(p) && b < 256; b++ {
+			rb, gb, bb, ab := p[b].RGBA()
+			if ab != 0xffff {
+				continue
+			}
+			dr, dg, db := int(ra>>8)-int(rb>>8), int(ga>>8)-int(gb>>8), int(ba>>8)-int(bb>>8)
+			if d := dr*dr + dg*dg + db*db; d <= maxPairDistance {
+				pairs = append(pairs, pair{a, b, d})
+			}
+		}
+	}
+	// Ties keep index order, so extraction pairs the colors the same way.
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].dist < pairs[j].dist
+	})
+
+	for _, q := range pairs {
+		if partner[q.a] < 0 && partner[q.b] < 0 {
+			partner[q.a], partner[q.b] = int16(q.b), int16(q.a)
+		}
+	}
+	return partner
+}
+
+func (p *PaletteOperator) Scatter(seed []byte) {
+	p.order = newScatter(seed, len(p.usable))
+}
+
+// After returns the stream that follows the first n bytes of p.
+func (p *PaletteOperator) After(n int) *PaletteOperator {
+	after := *p
+	after.start += n * 8
+	return &after
+}
+
+func (p *PaletteOperator) Capacity() int {
+	return max(len(p.usable)-p.start, 0) / 8
+}
+
+func (p *PaletteOperator) index(pos int) int {
+	pos += p.start
+	if p.order != nil {
+		pos = p.order.At(pos)
+	}
+	return int(p.usable[pos])
+}
+
+// bit is 1 when a pixel uses the higher index of its pair.
+func (p *PaletteOperator) bit(idx int) byte {
+	if int16(p.pix[idx]) > p.partner[p.pix[idx]] {
+		return 1
+	}
+	return 0
+}
+
+func (p *PaletteOperator) Embed(data []byte, off int) error {
+	if off < 0 || off+len(data) > p.Capacity() {
+		return errors.New("out of bounds")
+	}
+
+	pos := off * 8
+	for _, v := range data {
+		for i := 7; i >= 0; i-- {
+			idx := p.index(pos)
+			pos++
+
+			if p.bit(idx) != v>>i&1 {
+				p.pix[idx] = uint8(p.partner[p.pix[idx]])
+			}
+		}
+	}
+	return nil
+}
+
+func (p *PaletteOperator) UnEmbed(n int, off int) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > p.Capacity() {
+		return nil, errors.New("out of bounds")
+	}
+
+	pos := off * 8
+	out := make([]byte, n)
+	for i := range out {
+		var v byte
+		for j := 0; j < 8; j++ {
+			v = v<<1 | p.bit(p.index(pos))
+			pos++
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// Writer and Reader walk the stream from byte off onwards, like those of
+// DCTOperator.
+func (p *PaletteOperator) Writer(off int) io.Writer {
+	return &offsetStream{s: p, off: off}
+}
+
+func (p *PaletteOperator) Reader(off int) io.Reader {
+	return &offsetStream{s: p, off: off}
+}
+
+// paletteMedium embeds in the indices of an *image.Paletted.
+type paletteMedium struct{ *image.Paletted }
+
+func (p paletteMedium) carrier() Carrier {
+	return p.Paletted
+}
+
+func (p paletteMedium) clone() medium {
+	return paletteMedium{clonePaletted(p.Paletted)}
+}
+
+func (p paletteMedium) room(opts Options, kdf KDF) (int, int) {
+	h := newHeader(paletteLayout, kdf, KindFile, 0)
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+	return h.Size(), NewPaletteOperator(p.Paletted).After(h.Size()).Capacity()
+}
+
+func (p paletteMedium) streams(t Traversal, key string) (stream, func(n int, h *Header) stream) {
+	op := newPaletteOperator(p.Paletted, t, key)
+	return op, func(n int, h *Header) stream {
+		return op.After(n)
+	}
+}
+
+func (p paletteMedium) prepare(off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	op := newPaletteOperator(p.Paletted, opts.Traversal, opts.scatterKey(password))
+
+	h := newHeader(paletteLayout, kdf, kind, 0)
+	if opts.Traversal == TraversalScattered {
+		h.Flags |= FlagScattered
+	}
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+
+	c := &carrier{dst: p, op: op, body: op.After(off + h.Size()), header: h, redundancy: opts.Redundancy}
+	if c.capacity() <= 0 {
+		return nil, ErrImageNotSupported
+	}
+	return c, nil
+}
+
+func (p paletteMedium) flags() HeaderFlags {
+	return 0
+}
+
+func (p paletteMedium) regions() bool {
+	return false
+}
+
+func (p paletteMedium) encode(w io.Writer) error {
+	return EncodeImage(w, p.Paletted)
+}
+
+func (p paletteMedium) extension() string {
+	return ImageExtension(p.Paletted)
+}
+
+func clonePaletted(img *image.Paletted) *image.Paletted {
+	clone := *img
+	clone.Pix = append([]uint8(nil), img.Pix...)
+	return &clone
+}
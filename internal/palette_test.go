@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func testPaletted(w, h int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, w, h), palette.Plan9)
+	draw.FloydSteinberg.Draw(img, img.Bounds(), testImage(w, h), image.Point{})
+	return img
+}
+
+// pixelsClose fails unless every pixel of b is the color of a or its
+// palette partner.
+func pixelsClose(t *testing.T, a, b *image.Paletted) {
+	t.Helper()
+	partner := pairColors(a.Palette)
+	for i := range a.Pix {
+		if a.Pix[i] != b.Pix[i] && int(partner[a.Pix[i]]) != int(b.Pix[i]) {
+			t.Fatalf("pixel %d moved from color %d to %d", i, a.Pix[i], b.Pix[i])
+		}
+	}
+}
+
+func TestPairColors(t *testing.T) {
+	partner := pairColors(palette.Plan9)
+	paired := 0
+	for i, j := range partner {
+		if j < 0 {
+			continue
+		}
+		paired++
+		if int(partner[j]) != i {
+			t.Fatalf("color %d pairs with %d, which pairs with %d", i, j, partner[j])
+		}
+		r1, g1, b1, _ := palette.Plan9[i].RGBA()
+		r2, g2, b2, _ := palette.Plan9[j].RGBA()
+		d := sq(int(r1>>8)-int(r2>>8)) + sq(int(g1>>8)-int(g2>>8)) + sq(int(b1>>8)-int(b2>>8))
+		if d > maxPairDistance {
+			t.Fatalf("colors %d and %d are %d apart", i, j, d)
+		}
+	}
+	if paired == 0 {
+		t.Fatal("no colors paired")
+	}
+}
+
+func sq(x int) int {
+	return x * x
+}
+
+func TestEmbedGIF(t *testing.T) {
+	frame := testPaletted(160, 120)
+	var in bytes.Buffer
+	if err := gif.EncodeAll(&in, &gif.GIF{Image: []*image.Paletted{frame, frame, frame}, Delay: []int{10, 10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	src, err := DecodeImage(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("hello palette world")
+	for _, opts := range []Options{{}, {Traversal: TraversalScattered, Redundancy: RedundancyLow}} {
+		out, err := EmbedData(src, data, "", 0, "secret1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pixelsClose(t, src.(*Picture).Image.(*image.Paletted), out.(*Picture).Image.(*image.Paletted))
+
+		var buf bytes.Buffer
+		if err := EncodeCarrier(&buf, out); err != nil {
+			t.Fatal(err)
+		}
+		if g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes())); err != nil || len(g.Image) != 3 {
+			t.Fatalf("animation lost: %v", err)
+		}
+		back, err := DecodeImage(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, _, err := ExtractData(back, 0, "secret1", Options{})
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+	}
+}
+
+func TestEmbedPalettedPNG(t *testing.T) {
+	var in bytes.Buffer
+	if err := png.Encode(&in, testPaletted(160, 120)); err != nil {
+		t.Fatal(err)
+	}
+	src, err := DecodeImage(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Compression: CompressionNone}
+	data := bytes.Repeat([]byte{0x5A}, Capacity(src, opts)-1)
+	if _, err := EmbedData(src, append(data, 1), "", 0, "secret1", opts); err != ErrImageTooSmall {
+		t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+	}
+	out, err := EmbedData(src, data, "", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeCarrier(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	back, err := DecodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := back.(*image.Paletted); !ok {
+		t.Fatalf("written as %T", back)
+	}
+	got, _, _, err := ExtractData(back, 0, "secret1", Options{})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal(err)
+	}
+}
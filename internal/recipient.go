@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Keys use the same bech32 encoding as age, so existing age key files and
+// "age1..." recipients can be used directly.
+const (
+	recipientHRP = "age"
+	identityHRP  = "age-secret-key-"
+
+	// A stanza is an ephemeral X25519 share followed by the wrapped file key.
+	stanzaSize = 32 + 32 + chacha20poly1305.Overhead
+
+	maxRecipients = 255
+	fileKeySize   = 32
+)
+
+var stanzaInfo = []byte("zuon/x25519/v1")
+
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+func ParseRecipient(s string) (*Recipient, error) {
+	hrp, data, err := bech32Decode(strings.TrimSpace(s))
+	if err != nil || hrp != recipientHRP {
+		return nil, ErrInvalidRecipient
+	}
+
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, ErrInvalidRecipient
+	}
+	return &Recipient{key: key}, nil
+}
+
+// ParseRecipients reads one recipient per line, ignoring blank lines and
+// lines starting with '#'.
+func ParseRecipients(text string) ([]*Recipient, error) {
+	var out []*Recipient
+	for _, line := range keyLines(strings.NewReader(text)) {
+		r, err := ParseRecipient(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func ParseIdentity(s string) (*Identity, error) {
+	hrp, data, err := bech32Decode(strings.TrimSpace(s))
+	if err != nil || hrp != identityHRP {
+		return nil, ErrInvalidIdentity
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, ErrInvalidIdentity
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseIdentities reads a key file in the format written by age-keygen.
+func ParseIdentities(r io.Reader) ([]*Identity, error) {
+	var out []*Identity
+	for _, line := range keyLines(r) {
+		id, err := ParseIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	if len(out) == 0 {
+		return nil, ErrInvalidIdentity
+	}
+	return out, nil
+}
+
+func keyLines(r io.Reader) []string {
+	var out []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func (r *Recipient) String() string {
+	s, _ := bech32Encode(recipientHRP, r.key.Bytes())
+	return s
+}
+
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{key: i.key.PublicKey()}
+}
+
+func (i *Identity) String() string {
+	s, _ := bech32Encode(identityHRP, i.key.Bytes())
+	return strings.ToUpper(s)
+}
+
+// wrapFileKey returns one stanza per recipient, each carrying fileKey.
+func wrapFileKey(recipients []*Recipient, fileKey []byte) ([]byte, error) {
+	out := make([]byte, 0, len(recipients)*stanzaSize)
+	for _, r := range recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		share := ephemeral.PublicKey().Bytes()
+		aead, err := stanzaAEAD(ephemeral, r.key, share)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, share...)
+		out = aead.Seal(out, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+	}
+	return out, nil
+}
+
+// unwrapFileKey tries every identity against every stanza.
+func unwrapFileKey(identities []*Identity, stanzas []byte) ([]byte, error) {
+	for off := 0; off+stanzaSize <= len(stanzas); off += stanzaSize {
+		share := stanzas[off : off+32]
+		peer, err := ecdh.X25519().NewPublicKey(share)
+		if err != nil {
+			continue
+		}
+
+		for _, id := range identities {
+			aead, err := stanzaAEAD(id.key, peer, share)
+			if err != nil {
+				continue
+			}
+
+			fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanzas[off+32:off+stanzaSize], nil)
+			if err == nil {
+				return fileKey, nil
+			}
+		}
+	}
+	return nil, errors.New("no identity matched")
+}
+
+// stanzaAEAD derives the wrapping key from the X25519 shared secret, bound to
+// the ephemeral share and the recipient public key.
+func stanzaAEAD(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, share []byte) (interface {
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}, error) {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient := pub.Bytes()
+	if bytes.Equal(share, recipient) {
+		recipient = priv.PublicKey().Bytes()
+	}
+
+	salt := append(append([]byte{}, share...), recipient...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, stanzaInfo), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestRecipientStrings(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := ParseRecipient(id.Recipient().String()); err != nil || r.String() != id.Recipient().String() {
+		t.Fatal("recipient:", err)
+	}
+	ids, err := ParseIdentities(strings.NewReader("# created\n# public key: x\n" + id.String() + "\n"))
+	if err != nil || len(ids) != 1 || ids[0].String() != id.String() {
+		t.Fatal("identity:", err)
+	}
+
+	// age's own recipient strings are accepted as they are.
+	if _, err := ParseRecipient("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"); err != nil {
+		t.Fatal("age recipient:", err)
+	}
+	if _, err := ParseRecipient("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q"); err == nil {
+		t.Fatal("accepted a bad checksum")
+	}
+}
+
+func TestEmbedRecipients(t *testing.T) {
+	a, _ := GenerateIdentity()
+	b, _ := GenerateIdentity()
+	c, _ := GenerateIdentity()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	opts := Options{Traversal: TraversalScattered, Recipients: []*Recipient{a.Recipient(), b.Recipient()}}
+	out, err := EmbedData(img, []byte("secret"), ".txt", 0, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []*Identity{a, b} {
+		data, ext, _, err := ExtractData(out, 0, "", Options{Identities: []*Identity{id}})
+		if err != nil || string(data) != "secret" || ext != ".txt" {
+			t.Fatal(err)
+		}
+	}
+	if _, _, _, err := ExtractData(out, 0, "", Options{}); err != ErrIdentityRequired {
+		t.Fatalf("no identity: got %v, want %v", err, ErrIdentityRequired)
+	}
+	if _, _, _, err := ExtractData(out, 0, "", Options{Identities: []*Identity{c}}); err != ErrDecryptionFailed {
+		t.Fatalf("other identity: got %v, want %v", err, ErrDecryptionFailed)
+	}
+}
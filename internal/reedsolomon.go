@@ -0,0 +1,252 @@
+package internal
+
+import "errors"
+
+// Reed–Solomon over GF(2^8) with the 0x11d field polynomial, generator 2 and
+// first consecutive root 1, correcting up to nsym/2 byte errors per codeword.
+
+var errTooManyErrors = errors.New("too many errors to correct")
+
+var gfExp, gfLog = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(x, y byte) byte {
+	if x == 0 || y == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[x])+int(gfLog[y])]
+}
+
+func gfDiv(x, y byte) byte {
+	if x == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[x])+255-int(gfLog[y]))%255]
+}
+
+func gfPow(x byte, n int) byte {
+	e := (int(gfLog[x]) * n) % 255
+	if e < 0 {
+		e += 255
+	}
+	return gfExp[e]
+}
+
+func gfInverse(x byte) byte {
+	return gfExp[255-int(gfLog[x])]
+}
+
+// Polynomials are stored highest degree first.
+
+func polyScale(p []byte, x byte) []byte {
+	out := make([]byte, len(p))
+	for i, c := range p {
+		out[i] = gfMul(c, x)
+	}
+	return out
+}
+
+func polyAdd(p, q []byte) []byte {
+	out := make([]byte, max(len(p), len(q)))
+	for i, c := range p {
+		out[i+len(out)-len(p)] = c
+	}
+	for i, c := range q {
+		out[i+len(out)-len(q)] ^= c
+	}
+	return out
+}
+
+func polyMul(p, q []byte) []byte {
+	out := make([]byte, len(p)+len(q)-1)
+	for j, b := range q {
+		for i, a := range p {
+			out[i+j] ^= gfMul(a, b)
+		}
+	}
+	return out
+}
+
+func polyEval(p []byte, x byte) byte {
+	y := p[0]
+	for _, c := range p[1:] {
+		y = gfMul(y, x) ^ c
+	}
+	return y
+}
+
+func reversed(p []byte) []byte {
+	out := make([]byte, len(p))
+	for i, c := range p {
+		out[len(p)-1-i] = c
+	}
+	return out
+}
+
+func rsGenerator(nsym int) []byte {
+	g := []byte{1}
+	for i := 0; i < nsym; i++ {
+		g = polyMul(g, []byte{1, gfPow(2, i)})
+	}
+	return g
+}
+
+// rsEncode returns msg followed by nsym parity bytes. len(msg)+nsym must not
+// exceed 255.
+func rsEncode(msg []byte, nsym int) []byte {
+	gen := rsGenerator(nsym)
+	out := make([]byte, len(msg)+nsym)
+	copy(out, msg)
+	for i := range msg {
+		coef := out[i]
+		if coef == 0 {
+			continue
+		}
+		for j := 1; j < len(gen); j++ {
+			out[i+j] ^= gfMul(gen[j], coef)
+		}
+	}
+	copy(out, msg)
+	return out
+}
+
+// rsDecode corrects codeword in place and returns its message part.
+func rsDecode(codeword []byte, nsym int) ([]byte, error) {
+	synd := rsSyndromes(codeword, nsym)
+	if isZero(synd) {
+		return codeword[:len(codeword)-nsym], nil
+	}
+
+	errLoc, err := rsErrorLocator(synd, nsym)
+	if err != nil {
+		return nil, err
+	}
+
+	errPos, err := rsFindErrors(reversed(errLoc), len(codeword))
+	if err != nil {
+		return nil, err
+	}
+
+	rsCorrect(codeword, synd, errPos)
+	if !isZero(rsSyndromes(codeword, nsym)) {
+		return nil, errTooManyErrors
+	}
+	return codeword[:len(codeword)-nsym], nil
+}
+
+// rsSyndromes has a leading zero so that synd[i+1] = r(2^i).
+func rsSyndromes(codeword []byte, nsym int) []byte {
+	synd := make([]byte, nsym+1)
+	for i := 0; i < nsym; i++ {
+		synd[i+1] = polyEval(codeword, gfPow(2, i))
+	}
+	return synd
+}
+
+func isZero(p []byte) bool {
+	for _, c := range p {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rsErrorLocator runs Berlekamp–Massey on the syndromes.
+func rsErrorLocator(synd []byte, nsym int) ([]byte, error) {
+	errLoc := []byte{1}
+	oldLoc := []byte{1}
+	shift := len(synd) - nsym
+
+	for i := 0; i < nsym; i++ {
+		k := i + shift
+		delta := synd[k]
+		for j := 1; j < len(errLoc); j++ {
+			delta ^= gfMul(errLoc[len(errLoc)-1-j], synd[k-j])
+		}
+
+		oldLoc = append(oldLoc, 0)
+		if delta != 0 {
+			if len(oldLoc) > len(errLoc) {
+				newLoc := polyScale(oldLoc, delta)
+				oldLoc = polyScale(errLoc, gfInverse(delta))
+				errLoc = newLoc
+			}
+			errLoc = polyAdd(errLoc, polyScale(oldLoc, delta))
+		}
+	}
+
+	for len(errLoc) > 0 && errLoc[0] == 0 {
+		errLoc = errLoc[1:]
+	}
+	if (len(errLoc)-1)*2 > nsym {
+		return nil, errTooManyErrors
+	}
+	return errLoc, nil
+}
+
+// rsFindErrors is a Chien search for the roots of the error locator.
+func rsFindErrors(errLoc []byte, n int) ([]int, error) {
+	var pos []int
+	for i := 0; i < n; i++ {
+		if polyEval(errLoc, gfPow(2, i)) == 0 {
+			pos = append(pos, n-1-i)
+		}
+	}
+	if len(pos) != len(errLoc)-1 {
+		return nil, errTooManyErrors
+	}
+	return pos, nil
+}
+
+// rsCorrect applies the Forney algorithm to fix the bytes at errPos.
+func rsCorrect(codeword, synd []byte, errPos []int) {
+	coefPos := make([]int, len(errPos))
+	for i, p := range errPos {
+		coefPos[i] = len(codeword) - 1 - p
+	}
+
+	errLoc := []byte{1}
+	for _, p := range coefPos {
+		errLoc = polyMul(errLoc, polyAdd([]byte{1}, []byte{gfPow(2, p), 0}))
+	}
+
+	// Error evaluator: the reversed syndromes times errLoc, modulo
+	// x^len(errLoc).
+	product := polyMul(reversed(synd), errLoc)
+	errEval := product[len(product)-len(errLoc):]
+
+	x := make([]byte, len(coefPos))
+	for i, p := range coefPos {
+		x[i] = gfPow(2, -(255 - p))
+	}
+
+	for i, xi := range x {
+		xiInv := gfInverse(xi)
+
+		prime := byte(1)
+		for j, xj := range x {
+			if j != i {
+				prime = gfMul(prime, 1^gfMul(xiInv, xj))
+			}
+		}
+
+		y := gfMul(xi, polyEval(errEval, xiInv))
+		codeword[errPos[i]] ^= gfDiv(y, prime)
+	}
+}
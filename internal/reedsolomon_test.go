@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 300; trial++ {
+		nsym := 2 + 2*r.Intn(32)
+		msg := make([]byte, 1+r.Intn(255-nsym))
+		r.Read(msg)
+
+		cw := rsEncode(msg, nsym)
+		errs := r.Intn(nsym/2 + 1)
+		for _, p := range r.Perm(len(cw))[:errs] {
+			cw[p] ^= byte(1 + r.Intn(255))
+		}
+
+		got, err := rsDecode(cw, nsym)
+		if err != nil || !bytes.Equal(got, msg) {
+			t.Fatalf("%d parity bytes, %d errors: %v", nsym, errs, err)
+		}
+	}
+}
+
+func TestReedSolomonTooManyErrors(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	msg := make([]byte, 200)
+	r.Read(msg)
+	for trial := 0; trial < 50; trial++ {
+		cw := rsEncode(msg, 16)
+		for _, p := range r.Perm(len(cw))[:20] {
+			cw[p] ^= byte(1 + r.Intn(255))
+		}
+		if got, err := rsDecode(cw, 16); err == nil && bytes.Equal(got, msg) {
+			t.Fatal("corrected more errors than the parity allows")
+		}
+	}
+}
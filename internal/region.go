@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+)
+
+// Region limits the body of a pixel image to part of it, to keep the payload
+// out of a face or a logo. The pixels it selects are recorded behind the
+// container header, so extraction needs nothing but the password; the header
+// itself stays where it always is.
+type Region struct {
+	rect image.Rectangle
+	mask image.Image
+}
+
+// RectRegion selects the pixels inside r, in the coordinates of the carrier.
+func RectRegion(r image.Rectangle) *Region {
+	return &Region{rect: r}
+}
+
+// MaskRegion selects the pixels where mask is lighter than mid-gray. A mask
+// of another size than the carrier is stretched over it.
+func MaskRegion(mask image.Image) *Region {
+	return &Region{mask: mask}
+}
+
+// pixels marks the pixels of an image with bounds b that r selects, in the
+// order of its Pix.
+func (r *Region) pixels(b image.Rectangle) []bool {
+	out := make([]bool, b.Dx()*b.Dy())
+	if r.mask == nil {
+		in := r.rect.Intersect(b)
+		for y := in.Min.Y; y < in.Max.Y; y++ {
+			row := out[(y-b.Min.Y)*b.Dx():]
+			for x := in.Min.X; x < in.Max.X; x++ {
+				row[x-b.Min.X] = true
+			}
+		}
+		return out
+	}
+
+	m := r.mask.Bounds()
+	if m.Empty() {
+		return out
+	}
+	for y := 0; y < b.Dy(); y++ {
+		my := m.Min.Y + y*m.Dy()/b.Dy()
+		for x := 0; x < b.Dx(); x++ {
+			mx := m.Min.X + x*m.Dx()/b.Dx()
+			out[y*b.Dx()+x] = color.GrayModel.Convert(r.mask.At(mx, my)).(color.Gray).Y >= 0x80
+		}
+	}
+	return out
+}
+
+// The region record behind the header is
+//
+//	u32 len(runs) | runs
+//
+// where runs is the deflated lengths, as uvarints, of the runs of pixels
+// outside and inside the region, alternately and starting outside. With
+// FlagECC the length is followed by regionParitySize Reed–Solomon parity
+// bytes and runs is error corrected like a body.
+const (
+	regionLengthSize = 4
+	regionParitySize = 8
+
+	regionRedundancy = RedundancyHigh
+)
+
+func encodeRegion(in []bool, ecc bool) []byte {
+	var runs []byte
+	inside, n := false, 0
+	for _, v := range in {
+		if v != inside {
+			runs = binary.AppendUvarint(runs, uint64(n))
+			inside, n = v, 0
+		}
+		n++
+	}
+	runs = binary.AppendUvarint(runs, uint64(n))
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(runs)
+	w.Close()
+
+	body := buf.Bytes()
+	if ecc {
+		body = eccEncode(body, regionRedundancy)
+	}
+	prefix := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	if ecc {
+		prefix = rsEncode(prefix, regionParitySize)
+	}
+	return append(prefix, body...)
+}
+
+var errBadRegion = errors.New("region record damaged")
+
+// readRegion reads the region record of an image with the given number of
+// pixels at byte off of op, and returns the region and the size of the
+// record.
+func readRegion(op stream, off int, ecc bool, pixels int) ([]bool, int, error) {
+	size := regionLengthSize
+	if ecc {
+		size += regionParitySize
+	}
+	prefix, err := op.UnEmbed(size, off)
+	if err != nil {
+		return nil, 0, errBadRegion
+	}
+	if ecc {
+		if prefix, err = rsDecode(prefix, regionParitySize); err != nil {
+			return nil, 0, errBadRegion
+		}
+	}
+
+	n := int(binary.BigEndian.Uint32(prefix))
+	if n > op.Capacity()-off-size {
+		return nil, 0, errBadRegion
+	}
+	body, err := op.UnEmbed(n, off+size)
+	if err != nil {
+		return nil, 0, errBadRegion
+	}
+	if ecc {
+		if body, err = eccDecode(body); err != nil {
+			return nil, 0, errBadRegion
+		}
+	}
+	// No more runs than pixels follow the first, each one no longer than
+	// pixels itself.
+	limit := (pixels + 1) * len(binary.AppendUvarint(nil, uint64(pixels)))
+	runs, err := decompressLimit(body, limit)
+	if err != nil {
+		return nil, 0, errBadRegion
+	}
+
+	in := make([]bool, 0, pixels)
+	for inside := false; len(runs) > 0; inside = !inside {
+		v, k := binary.Uvarint(runs)
+		if k <= 0 || v > uint64(pixels-len(in)) {
+			return nil, 0, errBadRegion
+		}
+		for i := uint64(0); i < v; i++ {
+			in = append(in, inside)
+		}
+		runs = runs[k:]
+	}
+	if len(in) != pixels {
+		return nil, 0, errBadRegion
+	}
+	return in, size + n, nil
+}
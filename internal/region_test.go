@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func testMask() *image.Gray {
+	mask := image.NewGray(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			if (x-20)*(x-20)+(y-15)*(y-15) > 100 {
+				mask.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return mask
+}
+
+func TestRegion(t *testing.T) {
+	src := halfTextured(160, 120)
+	data := make([]byte, 400)
+	rand.Read(data)
+
+	for _, region := range []*Region{RectRegion(image.Rect(20, 30, 90, 100)), MaskRegion(testMask())} {
+		in := region.pixels(src.Bounds())
+		for _, opts := range []Options{
+			{},
+			{Traversal: TraversalScattered},
+			{Redundancy: RedundancyMedium, Traversal: TraversalScattered},
+			{Coding: HammingCoding(2)},
+			{Coding: CodingAdaptive, Traversal: TraversalScattered},
+			{Matching: true, Layout: Layout{Bits: 3, Channels: ChannelsRGBA}, Alpha: AlphaRaw},
+		} {
+			opts.Region = region
+			opts.Compression = CompressionNone
+			out, err := EmbedData(src, data, ".bin", 0, "secret1", opts)
+			if err != nil {
+				t.Fatalf("%+v: %v", opts, err)
+			}
+			got, ext, _, err := ExtractData(out, 0, "secret1", Options{})
+			if err != nil || !bytes.Equal(got, data) || ext != ".bin" {
+				t.Fatalf("%+v: round trip: %v", opts, err)
+			}
+			h, _, err := Inspect(out, 0, "secret1")
+			if err != nil || !h.Masked || h.Coding != opts.Coding {
+				t.Fatalf("%+v: Inspect: got %+v, %v", opts, h, err)
+			}
+
+			// Outside the region only the header and the record change.
+			r := CapacityFor(src, nil, opts)
+			if opts.Layout.Bits == 0 {
+				o := out.(*image.NRGBA)
+				outside := 0
+				for i := 0; i < len(o.Pix); i += 4 {
+					if !in[i/4] && !bytes.Equal(o.Pix[i:i+4], src.Pix[i:i+4]) {
+						outside++
+					}
+				}
+				if limit := ((r.Header+4)*8 + 6) / 6; outside > limit {
+					t.Fatalf("%+v: pixels changed outside: got %d, want at most %d", opts, outside, limit)
+				}
+			}
+
+			big := make([]byte, r.Payload+1)
+			rand.Read(big)
+			if opts.Coding != CodingAdaptive {
+				if _, err := EmbedData(src, big[:r.Payload], "", 0, "secret1", opts); err != nil {
+					t.Fatalf("%+v: Payload: %v", opts, err)
+				}
+			}
+			if opts.Traversal == TraversalSequential {
+				if _, err := EmbedData(src, big, "", 0, "secret1", opts); err != ErrImageTooSmall {
+					t.Fatalf("%+v: Payload+1: got %v, want %v", opts, err, ErrImageTooSmall)
+				}
+			}
+		}
+	}
+}
+
+func TestRegionModes(t *testing.T) {
+	src := halfTextured(160, 120)
+	data := make([]byte, 400)
+	rand.Read(data)
+	opts := Options{Region: RectRegion(image.Rect(20, 30, 90, 100))}
+
+	w, err := NewEmbedWriter(src, ".bin", "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(w.Carrier(), 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("stream: %v", err)
+	}
+
+	dn, err := EmbedDeniable(src, Payload{Data: data[:100], Password: "secret1"}, Payload{Data: []byte("x"), Password: "other1"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractData(dn, 0, "secret1", Options{}); err != nil || !bytes.Equal(got, data[:100]) {
+		t.Fatalf("deniable real: %v", err)
+	}
+	if got, _, _, err := ExtractData(dn, 0, "other1", Options{}); err != nil || string(got) != "x" {
+		t.Fatalf("deniable decoy: %v", err)
+	}
+
+	shards, err := EmbedShards([]Carrier{src, halfTextured(100, 100)}, data, "", "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractShards(shards, "secret1", Options{}); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("shards: %v", err)
+	}
+
+	empty := Options{Region: RectRegion(image.Rect(500, 500, 600, 600))}
+	if _, err := EmbedData(src, data, "", 0, "secret1", empty); err != ErrImageTooSmall {
+		t.Fatalf("empty region: got %v, want %v", err, ErrImageTooSmall)
+	}
+	pal := image.NewPaletted(image.Rect(0, 0, 50, 50), color.Palette{color.Black, color.White})
+	if _, err := EmbedData(pal, data, "", 0, "secret1", opts); err != ErrRegionNotSupported {
+		t.Fatalf("palette: got %v, want %v", err, ErrRegionNotSupported)
+	}
+}
+
+func TestRegionRecord(t *testing.T) {
+	b := image.Rect(0, 0, 160, 120)
+	for _, region := range []*Region{RectRegion(image.Rect(20, 30, 90, 100)), MaskRegion(testMask())} {
+		in := region.pixels(b)
+		for _, ecc := range []bool{false, true} {
+			rec := encodeRegion(in, ecc)
+			op := NewPixOperator(make([]byte, 8*len(rec)+64), Layout{Bits: 1, Channels: ChannelsRGBA})
+			if err := op.Embed(rec, 0); err != nil {
+				t.Fatal(err)
+			}
+			got, n, err := readRegion(op, 0, ecc, len(in))
+			if err != nil || n != len(rec) || !slices.Equal(got, in) {
+				t.Fatalf("ecc %v: got %d bytes, %v; want %d bytes", ecc, n, err, len(rec))
+			}
+			if _, _, err := readRegion(op, 0, ecc, len(in)+1); err != errBadRegion {
+				t.Fatalf("ecc %v: wrong size: got %v, want %v", ecc, err, errBadRegion)
+			}
+		}
+	}
+}
+
+func TestRegionBomb(t *testing.T) {
+	// A record inflating far beyond what any region of 100 pixels needs.
+	bomb, _ := compress(make([]byte, 1<<20))
+	if _, err := decompressLimit(bomb, 1000); err == nil {
+		t.Fatal("decompressLimit: got nil, want error")
+	}
+	rec := binary.BigEndian.AppendUint32(nil, uint32(len(bomb)))
+	rec = append(rec, bomb...)
+	op := NewPixOperator(make([]byte, 8*len(rec)+64), Layout{Bits: 1, Channels: ChannelsRGBA})
+	if err := op.Embed(rec, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readRegion(op, 0, false, 100); err != errBadRegion {
+		t.Fatalf("got %v, want %v", err, errBadRegion)
+	}
+}
+
+// A SubImage shares the rows of its parent, which must neither confuse the
+// region nor receive any of the body.
+func TestRegionSubImage(t *testing.T) {
+	parent := halfTextured(160, 120)
+	wide := format64(parent)
+	for _, sub := range []image.Image{
+		parent.SubImage(image.Rect(40, 20, 120, 80)),
+		wide.SubImage(image.Rect(40, 20, 120, 80)),
+		parent.SubImage(image.Rect(0, 60, 160, 120)),
+	} {
+		before := format(parent)
+		before64 := format64(wide)
+		opts := Options{Region: RectRegion(image.Rect(50, 30, 110, 70))}
+		n := Capacity(sub, opts) - 1
+		if n <= 0 {
+			t.Fatalf("%v: no capacity", sub.Bounds())
+		}
+		data := make([]byte, n)
+		rand.Read(data)
+		out, err := EmbedData(sub, data, "", 0, "secret1", opts)
+		if err != nil {
+			t.Fatalf("%v: %v", sub.Bounds(), err)
+		}
+		if out.(image.Image).Bounds() != sub.Bounds() {
+			t.Fatalf("bounds: got %v, want %v", out.(image.Image).Bounds(), sub.Bounds())
+		}
+		got, _, _, err := ExtractData(out, 0, "secret1", Options{})
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%v: round trip: %v", sub.Bounds(), err)
+		}
+		if !bytes.Equal(parent.Pix, before.Pix) || !bytes.Equal(wide.Pix, before64.Pix) {
+			t.Fatalf("%v: parent changed", sub.Bounds())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const scatterRounds = 6
+
+var scatterSalt = []byte("zuon/scatter/v1")
+
+// scatter is a keyed bijection over [0, n) built from a balanced Feistel
+// network with cycle walking, so the visiting order never has to be stored.
+type scatter struct {
+	n    uint64
+	half uint
+	mask uint64
+	keys [scatterRounds]uint64
+
+	// The stream code asks for the same position once per bit, so the last
+	// answer is kept around.
+	last, lastAt int
+}
+
+// scatterSeed stretches the key so a guessed password cannot be confirmed by
+// probing for the container magic more cheaply than by a real KDF run.
+func scatterSeed(key string) []byte {
+	return argon2.IDKey([]byte(key), scatterSalt, 1, 64*1024, 4, 8*scatterRounds)
+}
+
+func newScatter(seed []byte, n int) *scatter {
+	s := &scatter{n: uint64(n), last: -1}
+
+	width := uint(bits.Len64(uint64(max(n-1, 1))))
+	s.half = (width + 1) / 2
+	s.mask = 1<<s.half - 1
+
+	for i := range s.keys {
+		s.keys[i] = binary.BigEndian.Uint64(seed[i*8:])
+	}
+	return s
+}
+
+func (s *scatter) At(i int) int {
+	if i == s.last {
+		return s.lastAt
+	}
+
+	x := uint64(i)
+	for {
+		x = s.permute(x)
+		if x < s.n {
+			s.last, s.lastAt = i, int(x)
+			return s.lastAt
+		}
+	}
+}
+
+func (s *scatter) permute(x uint64) uint64 {
+	l, r := x>>s.half, x&s.mask
+	for _, k := range s.keys {
+		l, r = r, l^(mix64(r^k)&s.mask)
+	}
+	return l<<s.half | r
+}
+
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScatterPermutation(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 1000, 4099} {
+		s := newScatter(bytes.Repeat([]byte{7}, 48), n)
+		seen := make([]bool, n)
+		for i := 0; i < n; i++ {
+			v := s.At(i)
+			if v < 0 || v >= n || seen[v] {
+				t.Fatalf("n=%d: At(%d) = %d is not a permutation", n, i, v)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestScatterSeed(t *testing.T) {
+	a := newScatter(make([]byte, 48), 1000)
+	b := newScatter(bytes.Repeat([]byte{1}, 48), 1000)
+	same := 0
+	for i := 0; i < 1000; i++ {
+		if a.At(i) == b.At(i) {
+			same++
+		}
+	}
+	if same > 50 {
+		t.Fatalf("%d of 1000 positions agree across seeds", same)
+	}
+}
+
+func TestEmbedExtractScattered(t *testing.T) {
+	img := testImage(200, 150)
+	out, err := EmbedData(img, []byte("scattered"), "", 3, "secret1", Options{Traversal: TraversalScattered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, _, err := ExtractData(out, 3, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "scattered" {
+		t.Fatalf("got %q", data)
+	}
+	if _, _, _, err := ExtractData(out, 3, "secret2", Options{}); err == nil {
+		t.Fatal("scattered data opened with the wrong password")
+	}
+}
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// Every shard body starts with the payload ID, its index and the shard
+// count, followed by its slice of the ciphertext.
+const (
+	payloadIDSize   = 16
+	shardRecordSize = payloadIDSize + 2 + 2
+	maxShards       = 1<<16 - 1
+)
+
+// MissingShardsError lists the shards, numbered from 1, that a sharded
+// payload still needs. It matches ErrMissingShards.
+type MissingShardsError struct {
+	Total   int
+	Missing []int
+}
+
+func (e *MissingShardsError) Error() string {
+	return ErrMissingShards.Error()
+}
+
+func (e *MissingShardsError) Is(target error) bool {
+	return target == ErrMissingShards
+}
+
+type shard struct {
+	header *Header
+	id     []byte
+	index  int
+	total  int
+	data   []byte
+}
+
+func parseShard(header *Header, body []byte) (*shard, error) {
+	if len(body) < shardRecordSize {
+		return nil, ErrDataNotFound
+	}
+
+	s := &shard{
+		header: header,
+		id:     body[:payloadIDSize],
+		index:  int(binary.BigEndian.Uint16(body[payloadIDSize:])),
+		total:  int(binary.BigEndian.Uint16(body[payloadIDSize+2:])),
+		data:   body[shardRecordSize:],
+	}
+	if s.total == 0 || s.index >= s.total {
+		return nil, ErrDataNotFound
+	}
+	return s, nil
+}
+
+// ShardCapacity is how many payload bytes EmbedShards can spread across srcs.
+func ShardCapacity(srcs []Carrier, opts Options) int {
+	kdf := opts.kdf()
+
+	total := -kdf.overhead()
+	for _, src := range srcs {
+		dst, err := carrierView(src)
+		if err != nil {
+			continue
+		}
+		if c := bodyCapacity(dst, opts, kdf) - shardRecordSize; c > 0 {
+			total += c
+		}
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+// EmbedShards encrypts the payload once and spreads the ciphertext over all
+// of srcs, in proportion to their capacity. The images can later be given to
+// ExtractShards in any order.
+func EmbedShards(srcs []Carrier, data []byte, extension string, password string, opts Options) ([]Carrier, error) {
+	if len(srcs) == 0 {
+		return nil, ErrNoCarrier
+	}
+	if len(srcs) > maxShards {
+		return nil, ErrInternal
+	}
+
+	kdf, err := opts.checkKDF()
+	if err != nil {
+		return nil, err
+	}
+
+	carriers := make([]*carrier, len(srcs))
+	sizes := make([]int, len(srcs))
+	available := 0
+	for i, src := range srcs {
+		if carriers[i], err = prepareCarrier(src, 0, password, kdf, kindOf(extension), opts); err != nil {
+			return nil, err
+		}
+		if sizes[i] = carriers[i].capacity() - shardRecordSize; sizes[i] <= 0 {
+			return nil, ErrImageNotSupported
+		}
+		available += sizes[i]
+	}
+
+	plaintext, flags, err := pack(data, extension, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(plaintext)+kdf.overhead() > available {
+		return nil, ErrImageTooSmall
+	}
+
+	// The ciphertext authenticates the header of every shard, in order.
+	var ad []byte
+	for _, c := range carriers {
+		c.header.Flags |= flags | FlagSharded
+		ad = append(ad, c.header.associatedData()...)
+	}
+	ciphertext, err := encryptPayload(kdf, password, plaintext, ad, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, payloadIDSize)
+	if _, err = io.ReadFull(rand.Reader, id); err != nil {
+		return nil, ErrInternal
+	}
+
+	splitShards(sizes, available, len(ciphertext))
+
+	out := make([]Carrier, len(srcs))
+	rest := ciphertext
+	for i, c := range carriers {
+		body := make([]byte, shardRecordSize, shardRecordSize+sizes[i])
+		copy(body, id)
+		binary.BigEndian.PutUint16(body[payloadIDSize:], uint16(i))
+		binary.BigEndian.PutUint16(body[payloadIDSize+2:], uint16(len(srcs)))
+		body = append(body, rest[:sizes[i]]...)
+		rest = rest[sizes[i]:]
+
+		if err = c.write(0, flags|FlagSharded, body); err != nil {
+			return nil, err
+		}
+		out[i] = c.dst.carrier()
+	}
+	return out, nil
+}
+
+// splitShards turns the capacities in sizes into shard sizes summing to n,
+// each proportional to its capacity and never above it.
+func splitShards(sizes []int, available, n int) {
+	capacities := append([]int(nil), sizes...)
+	left := n
+	for i, c := range capacities {
+		sizes[i] = int(int64(n) * int64(c) / int64(available))
+		left -= sizes[i]
+	}
+	for i, c := range capacities {
+		take := min(c-sizes[i], left)
+		sizes[i] += take
+		left -= take
+	}
+}
+
+// ExtractShards reassembles a payload written by EmbedShards from any
+// ordering of its images. Images without a container are skipped. If shards
+// are still missing, the error is a *MissingShardsError.
+func ExtractShards(srcs []Carrier, password string, opts Options) ([]byte, string, Verification, error) {
+	var shards []*shard
+	for _, src := range srcs {
+		dst, err := carrierView(src)
+		if err != nil {
+			return nil, "", Verification{}, err
+		}
+		header, body, err := findContainer(dst, 0, password, opts)
+		if err == errNoHeader {
+			continue
+		}
+		if err != nil {
+			return nil, "", Verification{}, err
+		}
+
+		if header.Flags&FlagSharded == 0 {
+			if len(srcs) > 1 {
+				return nil, "", Verification{}, ErrShardMismatch
+			}
+			return openContainer(header, body, password, opts)
+		}
+
+		data, err := readBody(header, body)
+		if err != nil {
+			return nil, "", Verification{}, err
+		}
+
+		s, err := parseShard(header, data)
+		if err != nil {
+			return nil, "", Verification{}, err
+		}
+		shards = append(shards, s)
+	}
+
+	if len(shards) == 0 {
+		return nil, "", Verification{}, ErrDataNotFound
+	}
+	return assemble(shards, password, opts)
+}
+
+func assemble(shards []*shard, password string, opts Options) ([]byte, string, Verification, error) {
+	first := shards[0]
+	byIndex := make(map[int]*shard, len(shards))
+	for _, s := range shards {
+		if !bytes.Equal(s.id, first.id) || s.total != first.total {
+			return nil, "", Verification{}, ErrShardMismatch
+		}
+		byIndex[s.index] = s
+	}
+
+	var missing []int
+	for i := 0; i < first.total; i++ {
+		if byIndex[i] == nil {
+			missing = append(missing, i+1)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, "", Verification{}, &MissingShardsError{Total: first.total, Missing: missing}
+	}
+
+	var ciphertext, ad []byte
+	for i := 0; i < first.total; i++ {
+		ciphertext = append(ciphertext, byIndex[i].data...)
+		ad = append(ad, byIndex[i].header.associatedData()...)
+	}
+
+	// Every shard records the same KDF and payload flags; the first one is
+	// used to open the whole.
+	header := byIndex[0].header
+	kdf, _ := header.kdf()
+	return openPayload(kdf, password, ciphertext, ad, header.Flags, opts)
+}
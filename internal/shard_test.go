@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func testCarriers() []Carrier {
+	return []Carrier{testImage(40, 40), testImage(30, 50), testImage(60, 20)}
+}
+
+func TestShards(t *testing.T) {
+	imgs := testCarriers()
+	opts := Options{Compression: CompressionNone, Traversal: TraversalScattered}
+	total := ShardCapacity(imgs, opts)
+	if total <= Capacity(imgs[0], opts) {
+		t.Fatalf("ShardCapacity %d is no more than one image holds", total)
+	}
+
+	// The extension takes a length byte and ".bin".
+	data := make([]byte, total-5)
+	rand.Read(data)
+	if _, err := EmbedShards(imgs, append(data, 1), ".bin", "secret1", opts); err != ErrImageTooSmall {
+		t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+	}
+	out, err := EmbedShards(imgs, data, ".bin", "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ext, _, err := ExtractShards([]Carrier{out[2], out[0], out[1]}, "secret1", Options{})
+	if err != nil || !bytes.Equal(got, data) || ext != ".bin" {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ExtractShards(out, "wrongpw", Options{}); err == nil {
+		t.Fatal("shards opened with the wrong password")
+	}
+}
+
+func TestShardsMissing(t *testing.T) {
+	imgs := testCarriers()
+	out, err := EmbedShards(imgs, bytes.Repeat([]byte("x"), 2000), "", "secret1", Options{Compression: CompressionNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Images without a container are skipped, and a duplicate does not
+	// stand in for a missing shard.
+	_, _, _, err = ExtractShards([]Carrier{out[2], imgs[0], out[2]}, "secret1", Options{})
+	var missing *MissingShardsError
+	if !errors.As(err, &missing) || missing.Total != 3 || len(missing.Missing) != 2 || missing.Missing[0] != 1 || missing.Missing[1] != 2 {
+		t.Fatalf("got %v", err)
+	}
+	if _, _, _, err := ExtractData(out[1], 0, "secret1", Options{}); !errors.Is(err, ErrMissingShards) {
+		t.Fatalf("one shard: got %v, want %v", err, ErrMissingShards)
+	}
+}
+
+func TestShardsDuplicate(t *testing.T) {
+	out, err := EmbedShards(testCarriers(), []byte("hi"), "", "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, _, err := ExtractShards([]Carrier{out[1], out[0], out[2], out[0]}, "secret1", Options{})
+	if err != nil || string(got) != "hi" {
+		t.Fatal(err)
+	}
+}
+
+func TestShardsMismatch(t *testing.T) {
+	imgs := testCarriers()
+	a, err := EmbedShards(imgs, []byte("first"), "", "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EmbedShards(imgs, []byte("second"), "", "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ExtractShards([]Carrier{a[0], b[1], a[2]}, "secret1", Options{}); err != ErrShardMismatch {
+		t.Fatalf("two sets: got %v, want %v", err, ErrShardMismatch)
+	}
+
+	single, err := EmbedData(imgs[0], []byte("one"), "", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := ExtractShards([]Carrier{single}, "secret1", Options{}); err != nil || string(got) != "one" {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ExtractShards([]Carrier{single, a[0]}, "secret1", Options{}); err != ErrShardMismatch {
+		t.Fatalf("unsharded with a shard: got %v, want %v", err, ErrShardMismatch)
+	}
+}
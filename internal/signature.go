@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"strings"
+)
+
+// Signing keys reuse the bech32 key strings of recipients and identities,
+// under their own prefixes.
+const (
+	signerHRP     = "zuonsig"
+	signingKeyHRP = "zuon-sign-key-"
+
+	signatureTrailerSize = ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+var signatureContext = []byte("zuon/ed25519/v1")
+
+// streamSignatureContext is used for streamed payloads, which are signed
+// through their SHA-512 digest since they are never held in memory whole.
+var streamSignatureContext = []byte("zuon/ed25519-stream/v1")
+
+type SignatureStatus uint8
+
+const (
+	// SignatureNone means the payload was not signed.
+	SignatureNone SignatureStatus = iota
+	SignatureVerified
+	// SignatureUnknownSigner is a valid signature by a key that is not
+	// among the trusted signers.
+	SignatureUnknownSigner
+	SignatureInvalid
+)
+
+type Verification struct {
+	Status SignatureStatus
+	Signer *Signer
+}
+
+// Signer is the public half of a SigningKey.
+type Signer struct {
+	key ed25519.PublicKey
+}
+
+type SigningKey struct {
+	key ed25519.PrivateKey
+}
+
+func GenerateSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{key: key}, nil
+}
+
+func ParseSigner(s string) (*Signer, error) {
+	hrp, data, err := bech32Decode(strings.TrimSpace(s))
+	if err != nil || hrp != signerHRP || len(data) != ed25519.PublicKeySize {
+		return nil, ErrInvalidSigner
+	}
+	return &Signer{key: ed25519.PublicKey(data)}, nil
+}
+
+// ParseSigners reads one signer per line, ignoring blank lines and lines
+// starting with '#'.
+func ParseSigners(text string) ([]*Signer, error) {
+	var out []*Signer
+	for _, line := range keyLines(strings.NewReader(text)) {
+		s, err := ParseSigner(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func ParseSigningKey(s string) (*SigningKey, error) {
+	hrp, data, err := bech32Decode(strings.TrimSpace(s))
+	if err != nil || hrp != signingKeyHRP || len(data) != ed25519.SeedSize {
+		return nil, ErrInvalidSigningKey
+	}
+	return &SigningKey{key: ed25519.NewKeyFromSeed(data)}, nil
+}
+
+// ParseSigningKeyFile reads the first signing key from a key file.
+func ParseSigningKeyFile(r io.Reader) (*SigningKey, error) {
+	lines := keyLines(r)
+	if len(lines) == 0 {
+		return nil, ErrInvalidSigningKey
+	}
+	return ParseSigningKey(lines[0])
+}
+
+func (s *Signer) String() string {
+	out, _ := bech32Encode(signerHRP, s.key)
+	return out
+}
+
+func (s *Signer) Equal(other *Signer) bool {
+	return other != nil && s.key.Equal(other.key)
+}
+
+func (k *SigningKey) Signer() *Signer {
+	return &Signer{key: k.key.Public().(ed25519.PublicKey)}
+}
+
+func (k *SigningKey) String() string {
+	s, _ := bech32Encode(signingKeyHRP, k.key.Seed())
+	return strings.ToUpper(s)
+}
+
+// sign appends the signer public key and a signature over msg.
+func sign(key *SigningKey, msg []byte) []byte {
+	sig := ed25519.Sign(key.key, signedMessage(msg))
+	out := append(msg, key.Signer().key...)
+	return append(out, sig...)
+}
+
+// verify splits what sign produced and checks the signature against the
+// trusted signers.
+func verify(signed []byte, trusted []*Signer) ([]byte, Verification) {
+	if len(signed) < signatureTrailerSize {
+		return nil, Verification{Status: SignatureInvalid}
+	}
+
+	msg := signed[:len(signed)-signatureTrailerSize]
+	return msg, checkSignature(signedMessage(msg), signed[len(msg):], trusted)
+}
+
+func signedMessage(msg []byte) []byte {
+	return append(bytes.Clone(signatureContext), msg...)
+}
+
+// signDigest returns the signer public key and a signature over the SHA-512
+// digest of a streamed payload.
+func signDigest(key *SigningKey, digest []byte) []byte {
+	sig := ed25519.Sign(key.key, append(bytes.Clone(streamSignatureContext), digest...))
+	return append(bytes.Clone(key.Signer().key), sig...)
+}
+
+func verifyDigest(digest, trailer []byte, trusted []*Signer) Verification {
+	return checkSignature(append(bytes.Clone(streamSignatureContext), digest...), trailer, trusted)
+}
+
+func checkSignature(msg, trailer []byte, trusted []*Signer) Verification {
+	signer := &Signer{key: bytes.Clone(trailer[:ed25519.PublicKeySize])}
+	if !ed25519.Verify(signer.key, msg, trailer[ed25519.PublicKeySize:]) {
+		return Verification{Status: SignatureInvalid, Signer: signer}
+	}
+
+	for _, t := range trusted {
+		if t.Equal(signer) {
+			return Verification{Status: SignatureVerified, Signer: signer}
+		}
+	}
+	return Verification{Status: SignatureUnknownSigner, Signer: signer}
+}
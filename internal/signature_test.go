@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSigningKeyStrings(t *testing.T) {
+	k, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ParseSigningKey(k.String()); err != nil || !p.Signer().Equal(k.Signer()) {
+		t.Fatal("signing key:", err)
+	}
+	if s, err := ParseSigner(k.Signer().String()); err != nil || !s.Equal(k.Signer()) {
+		t.Fatal("signer:", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	k, _ := GenerateSigningKey()
+	other, _ := GenerateSigningKey()
+	signed := sign(k, []byte("\x00hello"))
+
+	msg, v := verify(signed, []*Signer{k.Signer()})
+	if v.Status != SignatureVerified || !bytes.Equal(msg, []byte("\x00hello")) {
+		t.Fatalf("trusted: %+v %q", v, msg)
+	}
+	if _, v := verify(signed, []*Signer{other.Signer()}); v.Status != SignatureUnknownSigner || !v.Signer.Equal(k.Signer()) {
+		t.Fatalf("untrusted: %+v", v)
+	}
+
+	signed[len(signed)-1] ^= 1
+	if _, v := verify(signed, nil); v.Status != SignatureInvalid {
+		t.Fatalf("tampered: %+v", v)
+	}
+}
+
+func TestEmbedSigned(t *testing.T) {
+	k, _ := GenerateSigningKey()
+	other, _ := GenerateSigningKey()
+	img := testImage(80, 80)
+	text := []byte("signed signed signed signed")
+
+	for _, c := range []Compression{CompressionAuto, CompressionNone} {
+		out, err := EmbedData(img, text, ".txt", 0, "secret1", Options{SigningKey: k, Compression: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, ext, v, err := ExtractData(out, 0, "secret1", Options{TrustedSigners: []*Signer{k.Signer()}})
+		if err != nil || !bytes.Equal(data, text) || ext != ".txt" || v.Status != SignatureVerified {
+			t.Fatal(err, v)
+		}
+		_, _, v, _ = ExtractData(out, 0, "secret1", Options{TrustedSigners: []*Signer{other.Signer()}})
+		if v.Status != SignatureUnknownSigner || !v.Signer.Equal(k.Signer()) {
+			t.Fatalf("untrusted: %+v", v)
+		}
+	}
+
+	out, err := EmbedData(img, []byte("x"), "", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, v, err := ExtractData(out, 0, "secret1", Options{}); err != nil || v.Status != SignatureNone {
+		t.Fatal(err, v)
+	}
+}
@@ -4,102 +4,800 @@ import (
 	"bytes"
 	"encoding/binary"
 	"image"
+	"io"
 )
 
-const Overhead = 8 + 12 + 16 + 4
+// legacyLayout is the fixed layout used by v1.3 and earlier.
+var legacyLayout = Layout{Bits: 2, Channels: ChannelsRGBA}
 
-func Capacity(src image.Image) int {
-	bounds := src.Bounds()
-	totalPixels := bounds.Dx() * bounds.Dy()
+type Traversal uint8
+
+const (
+	// TraversalSequential fills pixels row by row from the top-left corner.
+	TraversalSequential Traversal = iota
+	// TraversalScattered visits pixels in a key-derived pseudo-random order.
+	TraversalScattered
+)
+
+type AlphaPolicy uint8
+
+const (
+	// AlphaPreserve never writes alpha on opaque images and skips pixels
+	// with alpha 0.
+	AlphaPreserve AlphaPolicy = iota
+	// AlphaRaw writes every selected channel of every pixel, like v1.3.
+	AlphaRaw
+)
+
+type Options struct {
+	Traversal Traversal
+	
+	// Layout of the payload body. The zero value selects DefaultLayout.
+	Layout      Layout
+	Alpha       AlphaPolicy
+	Compression Compression
+	
+	// Redundancy adds Reed–Solomon parity around the body, so a few damaged
+	// pixels can be repaired on extraction.
+	Redundancy Redundancy
+	
+	// Coding of the body on pixel images; other carriers are always plain.
+	// CodingAdaptive holds half a bit per sample of the selected channels,
+	// whatever Layout.Bits asks for. Hamming codings hold k/(2^k-1) of what
+	// Layout selects.
+	Coding Coding
+	
+	// Matching writes pixel images with LSB matching rather than replacing
+	// their low bits; extraction is the same either way.
+	Matching bool
+	
+	// Region, when set, keeps the body of pixel images inside it. Other
+	// carriers cannot use one.
+	Region *Region
+	
+	// KDF derives the encryption key. The zero value selects DefaultKDF.
+	KDF KDF
+	
+	// ScatterKey seeds the scattered order. The password is used when empty.
+	ScatterKey string
+	
+	// Recipients, when set, replace the password: the payload can then only
+	// be opened with one of the matching Identities.
+	Recipients []*Recipient
+	Identities []*Identity
+	
+	// SigningKey, when set, signs the payload inside the encryption.
+	// TrustedSigners decides which valid signatures count as verified.
+	SigningKey     *SigningKey
+	TrustedSigners []*Signer
+}
+
+// layoutFor returns the body layout actually used on dst, which drops alpha
+// from opaque images under AlphaPreserve and doubles the bits of 16-bit ones.
+// Adaptive coding always uses the lowest bit. Invalid layouts come back as
+// the zero Layout.
+func (o Options) layoutFor(dst pixImage) Layout {
+	layout := o.Layout
+	if layout == (Layout{}) {
+		layout = DefaultLayout
+	}
+	if o.Coding == CodingAdaptive {
+		layout.Bits = 1
+	}
+	if o.Alpha == AlphaPreserve && layout.Channels&ChannelA != 0 && dst.Opaque() {
+		layout.Channels &^= ChannelA
+	}
+	if !layout.Valid() {
+		return Layout{}
+	}
+	if _, wide := dst.samples(); wide && o.Coding == CodingPlain {
+		layout = layout.wide()
+	}
+	return layout
+}
+
+func (o Options) kdf() KDF {
+	if len(o.Recipients) > 0 {
+		if len(o.Recipients) > maxRecipients {
+			return KDF{ID: KDFX25519}
+		}
+		return KDF{ID: KDFX25519, Recipients: uint8(len(o.Recipients))}
+	}
+	if o.KDF == (KDF{}) {
+		return DefaultKDF
+	}
+	return o.KDF
+}
+
+func (o Options) scatterKey(password string) string {
+	if o.ScatterKey != "" {
+		return o.ScatterKey
+	}
+	return password
+}
+
+// pixImage is an *image.NRGBA or an *image.NRGBA64.
+type pixImage interface {
+	image.Image
+	Opaque() bool
+	samples() ([]uint8, bool)
+	stride() int
 	
-	capacity := totalPixels - Overhead
+	// image returns the wrapped image, copy a copy of it sharing no memory.
+	image() image.Image
+	copy() pixImage
+}
+
+type nrgba struct{ *image.NRGBA }
+
+func (n nrgba) samples() ([]uint8, bool) {
+	return n.Pix, false
+}
+
+func (n nrgba) stride() int {
+	return n.Stride
+}
+
+func (n nrgba) image() image.Image {
+	return n.NRGBA
+}
+
+func (n nrgba) copy() pixImage {
+	return nrgba{format(n.NRGBA)}
+}
+
+type nrgba64 struct{ *image.NRGBA64 }
+
+func (n nrgba64) samples() ([]uint8, bool) {
+	return n.Pix, true
+}
+
+func (n nrgba64) stride() int {
+	return n.Stride
+}
+
+func (n nrgba64) image() image.Image {
+	return n.NRGBA64
+}
+
+func (n nrgba64) copy() pixImage {
+	return nrgba64{format64(n.NRGBA64)}
+}
+
+func newOperator(dst pixImage, t Traversal, key string) *PixOperator {
+	op := newHeaderOperator(dst.samples())
+	if t == TraversalScattered {
+		op.Scatter(scatterSeed(key))
+	}
+	return op
+}
+
+func Capacity(src Carrier, opts Options) int {
+	kdf := opts.kdf()
+	dst, err := carrierView(src)
+	if err != nil {
+		return 0
+	}
+	capacity := bodyCapacity(dst, opts, kdf) - kdf.overhead()
 	if capacity < 0 {
 		return 0
 	}
 	return capacity
 }
 
-func EmbedData(src image.Image, data []byte, extension string, off int, password string) (*image.NRGBA, error) {
-	dst := format(src)
-	op := PixOperator(dst.Pix)
+// bodyCapacity is what dst holds after the header, before any encryption
+// overhead.
+func bodyCapacity(dst medium, opts Options, kdf KDF) int {
+	_, body := carrierRoom(dst, opts, kdf)
+	return eccCapacity(body, opts.Redundancy)
+}
+
+// carrierRoom returns the size of the header dst would get and what the
+// stream behind it holds, before error correction. Carriers that cannot take
+// the Region of opts hold nothing.
+func carrierRoom(dst medium, opts Options, kdf KDF) (int, int) {
+	if opts.Region != nil && !dst.regions() {
+		return 0, 0
+	}
+	return dst.room(opts, kdf)
+}
+
+// pixMedium embeds in the low bits of the samples of a pixImage.
+type pixMedium struct{ pixImage }
+
+func (p pixMedium) carrier() Carrier {
+	return p.image()
+}
+
+func (p pixMedium) clone() medium {
+	return pixMedium{p.copy()}
+}
+
+func (p pixMedium) room(opts Options, kdf KDF) (int, int) {
+	layout := opts.layoutFor(p)
+	if layout == (Layout{}) {
+		return 0, 0
+	}
+	
+	header := newHeader(layout, kdf, KindFile, 0)
+	if opts.Redundancy != RedundancyNone {
+		header.Flags |= FlagECC
+	}
+	if opts.Coding != CodingPlain || opts.Region != nil {
+		header.Flags |= FlagCoded
+	}
+	in, record := opts.regionOf(p)
 	
-	maxCapacity := op.Capacity() - off
-	if maxCapacity <= 0 {
+	// Which pixels the header takes from a region depends on the scattered
+	// order, which only the key gives.
+	op := newHeaderOperator(p.samples())
+	if in != nil && opts.Traversal == TraversalScattered && opts.ScatterKey != "" {
+		op = newOperator(p, TraversalScattered, opts.ScatterKey)
+	}
+	body := op.After(header.Size()+len(record), layout)
+	body.SkipTransparent = opts.Alpha == AlphaPreserve
+	body.region = in
+	if opts.estimated() {
+		body.assumeWorstOrder()
+	}
+	return header.Size() + len(record), codedStream(p, body, opts.Coding, 0).Capacity()
+}
+
+// estimated reports whether carrierRoom can only give a lower bound under o:
+// with a Region in scattered order and no ScatterKey.
+func (o Options) estimated() bool {
+	return o.Region != nil && o.Traversal == TraversalScattered && o.ScatterKey == ""
+}
+
+// regionOf returns the pixels of dst that opts.Region selects and the record
+// of them behind the header, or nothing without a region.
+func (o Options) regionOf(dst pixImage) ([]bool, []byte) {
+	if o.Region == nil {
+		return nil, nil
+	}
+	in := o.Region.pixels(dst.Bounds())
+	return in, encodeRegion(in, o.Redundancy != RedundancyNone)
+}
+
+// EmbedData hides data in src. JPEG carriers read with DecodeJPEG are written
+// into their DCT coefficients and come back as a *JPEG, palette images into
+// their palette indices and come back as an *image.Paletted, and a *Picture
+// comes back as a *Picture of the same Format; every other image comes back as
+// an *image.NRGBA. Audio is written into the low bits of its samples and
+// comes back as an *Audio.
+func EmbedData(src Carrier, data []byte, extension string, off int, password string, opts Options) (Carrier, error) {
+	if off < 0 {
 		return nil, ErrImageNotSupported
 	}
 	
-	extBytes := []byte(extension)
-	if len(extBytes) > 255 {
-		return nil, ErrExtensionTooLong
+	kdf, err := opts.checkKDF()
+	if err != nil {
+		return nil, err
 	}
 	
-	payload := new(bytes.Buffer)
-	payload.WriteByte(uint8(len(extBytes)))
-	payload.Write(extBytes)
-	payload.Write(data)
+	c, err := prepareCarrier(src, off, password, kdf, kindOf(extension), opts)
+	if err != nil {
+		return nil, err
+	}
 	
-	plaintext := payload.Bytes()
-	requiredSize := len(plaintext) + Overhead
+	plaintext, flags, err := pack(data, extension, opts)
+	if err != nil {
+		return nil, err
+	}
+	
+	requiredSize := len(plaintext) + kdf.overhead()
 	
-	if requiredSize > maxCapacity {
+	if requiredSize > c.capacity() {
 		return nil, ErrImageTooSmall
 	}
 	
-	ciphertext, err := Encrypt(password, plaintext)
+	c.header.Flags |= flags
+	ciphertext, err := encryptPayload(kdf, password, plaintext, c.header.associatedData(), opts)
 	if err != nil {
-		return nil, ErrInternal
+		return nil, err
 	}
 	
-	length := uint32(len(ciphertext))
-	header := make([]byte, 4)
-	binary.BigEndian.PutUint32(header, length)
-	
-	if err = op.Embed(header, off); err != nil {
+	if err = c.write(off, flags, ciphertext); err != nil {
+		return nil, err
+	}
+	return c.dst.carrier(), nil
+}
+
+// checkKDF returns the KDF the options select, or why it cannot be used.
+func (o Options) checkKDF() (KDF, error) {
+	kdf := o.kdf()
+	if kdf.ID == KDFX25519 && !kdf.Valid() {
+		return KDF{}, ErrInvalidRecipient
+	}
+	if !kdf.Valid() {
+		return KDF{}, ErrInternal
+	}
+	return kdf, nil
+}
+
+func kindOf(extension string) PayloadKind {
+	switch extension {
+	case "":
+		return KindText
+	case ArchiveExtension:
+		return KindArchive
+	}
+	return KindFile
+}
+
+func encryptPayload(kdf KDF, password string, plaintext, ad []byte, opts Options) ([]byte, error) {
+	var ciphertext []byte
+	var err error
+	if kdf.ID == KDFX25519 {
+		ciphertext, err = EncryptTo(opts.Recipients, plaintext, ad)
+	} else {
+		ciphertext, err = Encrypt(kdf, password, plaintext, ad)
+	}
+	if err != nil {
 		return nil, ErrInternal
 	}
+	return ciphertext, nil
+}
+
+// stream is a run of hidden bytes in a carrier, addressed from its start.
+type stream interface {
+	Capacity() int
+	Embed(data []byte, off int) error
+	UnEmbed(n int, off int) ([]byte, error)
+	Writer(off int) io.Writer
+	Reader(off int) io.Reader
+}
+
+// offsetStream reads and writes a stream through Embed and UnEmbed.
+type offsetStream struct {
+	s   stream
+	off int
+}
+
+func (o *offsetStream) Write(b []byte) (int, error) {
+	n := max(min(len(b), o.s.Capacity()-o.off), 0)
+	if err := o.s.Embed(b[:n], o.off); err != nil {
+		return 0, err
+	}
+	o.off += n
+	if n < len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (o *offsetStream) Read(b []byte) (int, error) {
+	n := max(min(len(b), o.s.Capacity()-o.off), 0)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	data, err := o.s.UnEmbed(n, o.off)
+	if err != nil {
+		return 0, err
+	}
+	o.off += copy(b, data)
+	return n, nil
+}
+
+// carrier is an image or audio prepared to receive one container header and
+// body.
+type carrier struct {
+	dst        medium
+	op         stream
+	body       stream
+	header     *Header
+	redundancy Redundancy
 	
-	if err = op.Embed(ciphertext, off+4); err != nil {
+	// region is the record written behind the header, see readRegion.
+	region []byte
+}
+
+// prepareCarrier sets up a copy of src for embedding.
+func prepareCarrier(src Carrier, off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	dst, err := carrierCopy(src)
+	if err != nil {
+		return nil, err
+	}
+	return newCarrier(dst, off, password, kdf, kind, opts)
+}
+
+// newCarrier sets up dst, as returned by carrierView or carrierCopy, for
+// embedding in place.
+func newCarrier(dst medium, off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	if !opts.Redundancy.valid() || !opts.Coding.valid() {
 		return nil, ErrInternal
 	}
+	if opts.Region != nil && !dst.regions() {
+		return nil, ErrRegionNotSupported
+	}
+	return dst.prepare(off, password, kdf, kind, opts)
+}
+
+func (p pixMedium) prepare(off int, password string, kdf KDF, kind PayloadKind, opts Options) (*carrier, error) {
+	layout := opts.layoutFor(p)
+	if layout == (Layout{}) {
+		return nil, ErrInvalidLayout
+	}
+	
+	op := newOperator(p, opts.Traversal, opts.scatterKey(password))
+	op.Matching = opts.Matching
+	
+	h := newHeader(layout, kdf, kind, 0)
+	if opts.Traversal == TraversalScattered {
+		h.Flags |= FlagScattered
+	}
+	if opts.Alpha == AlphaPreserve {
+		h.Flags |= FlagSkipTransparent
+	}
+	if opts.Redundancy != RedundancyNone {
+		h.Flags |= FlagECC
+	}
+	if opts.Coding != CodingPlain {
+		h.Flags |= FlagCoded
+		h.Coding = opts.Coding
+	}
+	if opts.Region != nil {
+		h.Flags |= FlagCoded
+		h.Masked = true
+	}
+	
+	in, record := opts.regionOf(p)
+	body := op.After(off+h.Size()+len(record), layout)
+	body.SkipTransparent = opts.Alpha == AlphaPreserve
+	body.region = in
+	
+	c := &carrier{dst: p, op: op, body: codedStream(p, body, h.Coding, 0), header: h, redundancy: opts.Redundancy, region: record}
+	if c.capacity() <= 0 {
+		if opts.Region != nil {
+			return nil, ErrImageTooSmall
+		}
+		return nil, ErrImageNotSupported
+	}
+	return c, nil
+}
+
+func (p pixMedium) flags() HeaderFlags {
+	return 0
+}
+
+func (p pixMedium) regions() bool {
+	return true
+}
+
+func (p pixMedium) encode(w io.Writer) error {
+	return EncodeImage(w, p.image())
+}
+
+func (p pixMedium) extension() string {
+	return ".png"
+}
+
+// capacity is how many bytes write accepts, after error correction.
+func (c *carrier) capacity() int {
+	return eccCapacity(c.body.Capacity(), c.redundancy)
+}
+
+func (c *carrier) write(off int, flags HeaderFlags, body []byte) error {
+	if c.redundancy != RedundancyNone {
+		body = eccEncode(body, c.redundancy)
+	}
+	
+	if err := c.body.Embed(body, 0); err != nil {
+		return ErrInternal
+	}
+	return c.writeHeader(off, flags, len(body))
+}
+
+// writeHeader records a body of length bytes that is already in place.
+func (c *carrier) writeHeader(off int, flags HeaderFlags, length int) error {
+	c.header.Flags |= flags
+	c.header.Length = uint32(length)
+	header, err := c.header.MarshalBinary()
+	if err != nil {
+		return ErrInternal
+	}
+	
+	if err = c.op.Embed(append(header, c.region...), off); err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// ExtractData looks for a container header in the requested traversal first
+// and then in the other one, before falling back to the legacy layout.
+func ExtractData(src Carrier, off int, password string, opts Options) ([]byte, string, Verification, error) {
+	dst, err := carrierView(src)
+	if err != nil {
+		return nil, "", Verification{}, err
+	}
+	
+	header, body, err := findContainer(dst, off, password, opts)
+	if err == errNoHeader {
+		return extractLegacy(dst, off, password)
+	}
+	if err != nil {
+		return nil, "", Verification{}, err
+	}
+	return openContainer(header, body, password, opts)
+}
+
+// openContainer decrypts the body of a container found by findContainer.
+func openContainer(header *Header, body stream, password string, opts Options) ([]byte, string, Verification, error) {
+	if header.Cipher == CipherAES256GCMStream {
+		var buf bytes.Buffer
+		extension, verification, err := openStream(func(string) io.Writer { return &buf }, header, body, password, opts)
+		if err != nil {
+			return nil, "", verification, err
+		}
+		return buf.Bytes(), extension, verification, nil
+	}
+	
+	data, err := readBody(header, body)
+	if err != nil {
+		return nil, "", Verification{}, err
+	}
+	
+	if header.Flags&FlagSharded != 0 {
+		s, err := parseShard(header, data)
+		if err != nil {
+			return nil, "", Verification{}, err
+		}
+		return assemble([]*shard{s}, password, opts)
+	}
+	
+	kdf, _ := header.kdf()
+	if kdf.ID == KDFX25519 {
+		return openPayload(kdf, password, data, header.associatedData(), header.Flags, opts)
+	}
+	
+	// The key may open a hidden payload instead, see EmbedDeniable.
+	block, err := passwordBlock(kdf, password, data)
+	if err != nil {
+		return nil, "", Verification{}, ErrDecryptionFailed
+	}
+	plaintext, err := open(block, data[kdf.saltSize():], header.associatedData())
+	if err != nil {
+		return openHidden(header, body, block, opts)
+	}
+	return unpack(plaintext, header.Flags, opts)
+}
+
+// readBody reads the whole body behind header, undoing error correction.
+func readBody(header *Header, body stream) ([]byte, error) {
+	data, err := body.UnEmbed(int(header.Length), 0)
+	if err != nil {
+		return nil, ErrDataNotFound
+	}
 	
-	return dst, nil
+	if header.Flags&FlagECC != 0 {
+		if data, err = eccDecode(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
 }
 
-func ExtractData(src image.Image, off int, password string) ([]byte, string, error) {
-	dst := format(src)
-	op := PixOperator(dst.Pix)
+// findContainer returns the validated header of dst and the stream holding
+// its body, or errNoHeader when neither traversal has one.
+func findContainer(dst medium, off int, password string, opts Options) (*Header, stream, error) {
+	traversals := []Traversal{opts.Traversal, TraversalScattered}
+	if opts.Traversal == TraversalScattered {
+		traversals[1] = TraversalSequential
+	}
+	
+	for _, t := range traversals {
+		op, after := dst.streams(t, opts.scatterKey(password))
+		
+		header, err := readHeader(op, off)
+		if err == errNoHeader {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		
+		if err = header.validate(); err != nil {
+			return nil, nil, err
+		}
+		
+		if (header.Flags&FlagScattered != 0) != (t == TraversalScattered) {
+			return nil, nil, ErrDataNotFound
+		}
+		if header.Flags&FlagDCT != dst.flags()&FlagDCT {
+			return nil, nil, ErrDataNotFound
+		}
+		
+		body := after(off+header.Size(), header)
+		if header.Length == 0 || int(header.Length) > body.Capacity() {
+			return nil, nil, ErrDataNotFound
+		}
+		return header, body, nil
+	}
+	
+	return nil, nil, errNoHeader
+}
+
+func (p pixMedium) streams(t Traversal, key string) (stream, func(n int, h *Header) stream) {
+	op := newOperator(p, t, key)
+	return op, func(n int, h *Header) stream {
+		var in []bool
+		if h.Masked {
+			region, size, err := readRegion(op, n, h.Flags&FlagECC != 0, op.pixels())
+			if err != nil {
+				// An empty region holds no body, which reads as no data.
+				region = make([]bool, op.pixels())
+			}
+			in, n = region, n+size
+		}
+		body := op.After(n, h.Layout)
+		body.SkipTransparent = h.Flags&FlagSkipTransparent != 0
+		body.region = in
+		return codedStream(p, body, h.Coding, int(h.Length))
+	}
+}
+
+// codedStream returns the stream holding a body of length bytes, when known,
+// in the given coding of body.
+func codedStream(dst pixImage, body *PixOperator, coding Coding, length int) stream {
+	if coding == CodingAdaptive {
+		return newSTCStream(dst, body, length)
+	}
+	if k := coding.Hamming(); k != 0 {
+		return newHammingStream(body, k)
+	}
+	return body
+}
+
+// Inspect reads the container header without decrypting anything. A
+// scattered header is only found with the key that seeded its order.
+func Inspect(src Carrier, off int, scatterKey string) (*Header, KDF, error) {
+	dst, err := carrierView(src)
+	if err != nil {
+		return nil, KDF{}, err
+	}
+	
+	for _, t := range []Traversal{TraversalSequential, TraversalScattered} {
+		op, _ := dst.streams(t, scatterKey)
+		header, err := readHeader(op, off)
+		if err == errNoHeader {
+			continue
+		}
+		if err != nil {
+			return nil, KDF{}, err
+		}
+		
+		if err = header.validate(); err != nil {
+			return nil, KDF{}, err
+		}
+		kdf, _ := header.kdf()
+		return header, kdf, nil
+	}
+	
+	return nil, KDF{}, ErrDataNotFound
+}
+
+// extractLegacy reads images written before the container header existed:
+// a 4-byte big-endian length followed by the ciphertext.
+func extractLegacy(dst medium, off int, password string) ([]byte, string, Verification, error) {
+	if p, ok := dst.(pictureMedium); ok {
+		dst = p.medium
+	}
+	pix, ok := dst.carrier().(*image.NRGBA)
+	if !ok {
+		return nil, "", Verification{}, ErrDataNotFound
+	}
+	op := NewPixOperator(pix.Pix, legacyLayout)
 	
 	header, err := op.UnEmbed(4, off)
 	if err != nil {
-		return nil, "", ErrDataNotFound
+		return nil, "", Verification{}, ErrDataNotFound
 	}
 	
 	length := binary.BigEndian.Uint32(header)
 	if length == 0 || int(length) > op.Capacity() {
-		return nil, "", ErrDataNotFound
+		return nil, "", Verification{}, ErrDataNotFound
 	}
 	
 	ciphertext, err := op.UnEmbed(int(length), off+4)
 	if err != nil {
-		return nil, "", ErrDataNotFound
+		return nil, "", Verification{}, ErrDataNotFound
 	}
 	
-	plaintext, err := Decrypt(password, ciphertext)
+	return openPayload(LegacyKDF, password, ciphertext, nil, 0, Options{})
+}
+
+// PayloadSize returns how many bytes of Capacity the given data would use.
+func PayloadSize(data []byte, extension string, opts Options) int {
+	plaintext, _, err := pack(data, extension, opts)
+	if err != nil {
+		return 0
+	}
+	return len(plaintext)
+}
+
+// UncompressedPayloadSize is PayloadSize for size bytes of data that do not
+// compress, which is the most any such data uses.
+func UncompressedPayloadSize(size int, extension string, opts Options) int {
+	n := 1 + len(extension) + size
+	if opts.SigningKey != nil {
+		n += signatureTrailerSize
+	}
+	return n
+}
+
+// pack lays out the plaintext as len(extension) | extension | data, followed
+// by the signer key and signature when signing, and compresses it when the
+// options allow and it pays off.
+func pack(data []byte, extension string, opts Options) ([]byte, HeaderFlags, error) {
+	extBytes := []byte(extension)
+	if len(extBytes) > 255 {
+		return nil, 0, ErrExtensionTooLong
+	}
+	
+	payload := new(bytes.Buffer)
+	payload.WriteByte(uint8(len(extBytes)))
+	payload.Write(extBytes)
+	payload.Write(data)
+	
+	plaintext := payload.Bytes()
+	var flags HeaderFlags
+	if opts.SigningKey != nil {
+		plaintext = sign(opts.SigningKey, plaintext)
+		flags |= FlagSigned
+	}
+	
+	if opts.Compression == CompressionAuto {
+		if packed, ok := compress(plaintext); ok {
+			return packed, flags | FlagCompressed, nil
+		}
+	}
+	return plaintext, flags, nil
+}
+
+func openPayload(kdf KDF, password string, ciphertext, ad []byte, flags HeaderFlags, opts Options) ([]byte, string, Verification, error) {
+	var plaintext []byte
+	var err error
+	if kdf.ID == KDFX25519 {
+		if len(opts.Identities) == 0 {
+			return nil, "", Verification{}, ErrIdentityRequired
+		}
+		plaintext, err = DecryptWith(kdf, opts.Identities, ciphertext, ad)
+	} else {
+		plaintext, err = Decrypt(kdf, password, ciphertext, ad)
+	}
 	if err != nil {
-		return nil, "", ErrDecryptionFailed
+		return nil, "", Verification{}, ErrDecryptionFailed
+	}
+	return unpack(plaintext, flags, opts)
+}
+
+// unpack undoes pack.
+func unpack(plaintext []byte, flags HeaderFlags, opts Options) ([]byte, string, Verification, error) {
+	var err error
+	if flags&FlagCompressed != 0 {
+		if plaintext, err = decompress(plaintext); err != nil {
+			return nil, "", Verification{}, ErrInternal
+		}
+	}
+	
+	var verification Verification
+	if flags&FlagSigned != 0 {
+		if plaintext, verification = verify(plaintext, opts.TrustedSigners); plaintext == nil {
+			return nil, "", verification, ErrInternal
+		}
 	}
 	
 	if len(plaintext) < 1 {
-		return nil, "", ErrInternal
+		return nil, "", Verification{}, ErrInternal
 	}
 	
 	extLen := int(plaintext[0])
 	if len(plaintext) < 1+extLen {
-		return nil, "", ErrInternal
+		return nil, "", Verification{}, ErrInternal
 	}
 	
 	extension := string(plaintext[1 : 1+extLen])
 	data := plaintext[1+extLen:]
 	
-	return data, extension, nil
+	return data, extension, verification, nil
 }
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// Keep key derivation cheap; the KDF parameters have their own tests.
+func init() {
+	DefaultKDF = KDF{ID: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+}
+
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	r := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(r.Intn(256)), uint8(x), uint8(y), 255})
+		}
+	}
+	return img
+}
+
+func TestEmbedExtract(t *testing.T) {
+	img := testImage(200, 150)
+	out, err := EmbedData(img, []byte("hello world"), ".txt", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ext, _, err := ExtractData(out, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" || ext != ".txt" {
+		t.Fatalf("got %q %q", data, ext)
+	}
+}
+
+func TestExtractWrongPassword(t *testing.T) {
+	img := testImage(200, 150)
+	out, err := EmbedData(img, []byte("hello world"), ".txt", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := ExtractData(out, 0, "wrongpw", Options{}); err != ErrDecryptionFailed {
+		t.Fatalf("wrong password: got %v, want %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestExtractNoData(t *testing.T) {
+	if _, _, _, err := ExtractData(testImage(200, 150), 0, "secret1", Options{}); err == nil {
+		t.Fatal("extracted data from an untouched image")
+	}
+}
+
+func TestExtractLegacy(t *testing.T) {
+	img := testImage(200, 150)
+	ct, err := Encrypt(LegacyKDF, "secret1", append([]byte{4}, ".pdfDATA"...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// v1.3 wrote a big-endian ciphertext length and then the ciphertext.
+	op := NewPixOperator(img.Pix, legacyLayout)
+	if err := op.Embed(binary.BigEndian.AppendUint32(nil, uint32(len(ct))), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Embed(ct, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	data, ext, _, err := ExtractData(img, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("DATA")) || ext != ".pdf" {
+		t.Fatalf("got %q %q", data, ext)
+	}
+	if _, _, _, err := ExtractData(img, 0, "wrongpw", Options{}); err == nil {
+		t.Fatal("legacy data opened with the wrong password")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	img := testImage(200, 150)
+	opts := Options{Traversal: TraversalScattered, KDF: LegacyKDF, Layout: Layout{Bits: 2, Channels: ChannelsRGB}}
+	out, err := EmbedData(img, []byte("hello world"), ".txt", 0, "secret1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, kdf, err := Inspect(out, 0, "secret1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Kind != KindFile || header.Layout != opts.Layout || kdf != LegacyKDF {
+		t.Fatalf("got %+v %+v", header, kdf)
+	}
+
+	if _, _, err := Inspect(out, 0, "wrongpw"); err != ErrDataNotFound {
+		t.Fatalf("wrong scatter key: got %v, want %v", err, ErrDataNotFound)
+	}
+	if _, _, err := Inspect(img, 0, ""); err != ErrDataNotFound {
+		t.Fatalf("untouched image: got %v, want %v", err, ErrDataNotFound)
+	}
+}
@@ -0,0 +1,499 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// Streamed bodies use the STREAM construction: the plaintext is cut into
+// streamChunkSize chunks, each sealed with AES-256-GCM under
+// prefix | chunk counter | last-chunk flag, so chunks can be neither
+// reordered nor cut off at the end. The body is
+//
+//	salt or recipient stanzas | nonce prefix | sealed chunks
+const (
+	streamChunkSize  = 64 * 1024
+	streamPrefixSize = 7
+	streamTagSize    = 16
+)
+
+type streamCipher struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+
+	// ad is authenticated with every chunk.
+	ad []byte
+}
+
+func (s *streamCipher) nonce(last bool) ([]byte, error) {
+	if s.counter == 1<<32-1 {
+		return nil, errors.New("stream too long")
+	}
+	nonce := make([]byte, 12)
+	copy(nonce, s.prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], s.counter)
+	if last {
+		nonce[11] = 1
+	}
+	s.counter++
+	return nonce, nil
+}
+
+func (s *streamCipher) seal(chunk []byte, last bool) ([]byte, error) {
+	nonce, err := s.nonce(last)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nil, nonce, chunk, s.ad), nil
+}
+
+func (s *streamCipher) open(chunk []byte, last bool) ([]byte, error) {
+	nonce, err := s.nonce(last)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Open(nil, nonce, chunk, s.ad)
+}
+
+// newStreamCipher returns the cipher for a new stream and the bytes stored in
+// front of its chunks.
+func newStreamCipher(kdf KDF, password string, recipients []*Recipient, ad []byte) (*streamCipher, []byte, error) {
+	var block cipher.Block
+	var head []byte
+	if kdf.ID == KDFX25519 {
+		fileKey := make([]byte, fileKeySize)
+		if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+			return nil, nil, err
+		}
+
+		stanzas, err := wrapFileKey(recipients, fileKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if block, err = aes.NewCipher(fileKey); err != nil {
+			return nil, nil, err
+		}
+		head = stanzas
+	} else {
+		if err := ValidatePassword(password); err != nil {
+			return nil, nil, err
+		}
+
+		salt := make([]byte, kdf.saltSize())
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, nil, err
+		}
+
+		var err error
+		if block, err = newCipherBlock(kdf, password, salt); err != nil {
+			return nil, nil, err
+		}
+		head = salt
+	}
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &streamCipher{aead: aead, prefix: prefix, ad: ad}, append(head, prefix...), nil
+}
+
+func openStreamCipher(kdf KDF, password string, identities []*Identity, head, ad []byte) (*streamCipher, error) {
+	salt, prefix := head[:kdf.saltSize()], head[kdf.saltSize():]
+
+	var block cipher.Block
+	var err error
+	if kdf.ID == KDFX25519 {
+		var fileKey []byte
+		if fileKey, err = unwrapFileKey(identities, salt); err != nil {
+			return nil, err
+		}
+		block, err = aes.NewCipher(fileKey)
+	} else {
+		if err = ValidatePassword(password); err != nil {
+			return nil, err
+		}
+		block, err = newCipherBlock(kdf, password, salt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &streamCipher{aead: aead, prefix: prefix, ad: ad}, nil
+}
+
+// StreamPayloadSize returns how many bytes of Capacity a payload of size bytes
+// uses when written through an EmbedWriter.
+func StreamPayloadSize(size int64, extension string, opts Options) int64 {
+	plaintext := 1 + int64(len(extension)) + size
+	if opts.SigningKey != nil {
+		plaintext += signatureTrailerSize
+	}
+	chunks := max((plaintext+streamChunkSize-1)/streamChunkSize, 1)
+
+	// Capacity has already set kdf.overhead() aside.
+	kdf := opts.kdf()
+	return plaintext + int64(kdf.saltSize()+streamPrefixSize) + chunks*streamTagSize - int64(kdf.overhead())
+}
+
+// EmbedWriter hides everything written to it in a carrier, encrypting
+// it chunk by chunk, so neither the payload nor its ciphertext is ever held
+// in memory whole. The header is written by Close.
+//
+// Streams are never compressed, since whether that pays off is only known at
+// the end, and carry no error correction or adaptive coding.
+type EmbedWriter struct {
+	c      *carrier
+	body   io.Writer
+	cipher *streamCipher
+
+	chunk   []byte
+	written int
+
+	key    *SigningKey
+	digest hash.Hash
+
+	err error
+}
+
+// NewEmbedWriter prepares a copy of src for a streamed payload, which Carrier
+// returns once Close succeeds.
+func NewEmbedWriter(src Carrier, extension string, password string, opts Options) (*EmbedWriter, error) {
+	extBytes := []byte(extension)
+	if len(extBytes) > 255 {
+		return nil, ErrExtensionTooLong
+	}
+
+	kdf, err := opts.checkKDF()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Redundancy = RedundancyNone
+	if opts.Coding == CodingAdaptive {
+		opts.Coding = CodingPlain
+	}
+	dst, err := carrierCopy(src)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newCarrier(dst, 0, password, kdf, kindOf(extension), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything the header records but the length is known up front.
+	c.header.Cipher = CipherAES256GCMStream
+	if opts.SigningKey != nil {
+		c.header.Flags |= FlagSigned
+	}
+	sc, head, err := newStreamCipher(kdf, password, opts.Recipients, c.header.associatedData())
+	if err != nil {
+		return nil, ErrInternal
+	}
+
+	w := &EmbedWriter{
+		c:      c,
+		body:   c.body.Writer(0),
+		cipher: sc,
+		chunk:  make([]byte, 0, streamChunkSize),
+		key:    opts.SigningKey,
+	}
+	if w.key != nil {
+		w.digest = sha512.New()
+	}
+
+	w.emit(head)
+	w.Write(append([]byte{uint8(len(extBytes))}, extBytes...))
+	return w, w.err
+}
+
+func (w *EmbedWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.digest != nil {
+		w.digest.Write(p)
+	}
+	w.buffer(p)
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// buffer adds p to the pending chunk. A full chunk is only sealed once more
+// data arrives, since the last one has to be sealed differently.
+func (w *EmbedWriter) buffer(p []byte) {
+	for len(p) > 0 && w.err == nil {
+		if len(w.chunk) == streamChunkSize {
+			w.sealChunk(false)
+		}
+		n := copy(w.chunk[len(w.chunk):streamChunkSize], p)
+		w.chunk = w.chunk[:len(w.chunk)+n]
+		p = p[n:]
+	}
+}
+
+func (w *EmbedWriter) sealChunk(last bool) {
+	sealed, err := w.cipher.seal(w.chunk, last)
+	if err != nil {
+		w.err = ErrInternal
+		return
+	}
+	w.chunk = w.chunk[:0]
+	w.emit(sealed)
+}
+
+func (w *EmbedWriter) emit(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.body.Write(p)
+	w.written += n
+	if err == io.ErrShortWrite {
+		w.err = ErrImageTooSmall
+	} else if err != nil {
+		w.err = ErrInternal
+	}
+}
+
+// Close seals the last chunk, appends the signature if any and writes the
+// container header. The carrier is only usable if Close returns nil.
+func (w *EmbedWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.key != nil {
+		w.buffer(signDigest(w.key, w.digest.Sum(nil)))
+	}
+	w.sealChunk(true)
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.c.writeHeader(0, 0, w.written); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = errors.New("embed writer closed")
+	return nil
+}
+
+// Carrier returns the carrier holding the payload, which is only complete
+// after Close.
+func (w *EmbedWriter) Carrier() Carrier {
+	return w.c.dst.carrier()
+}
+
+// StreamFileSize is StreamPayloadSize for a file written through a
+// FileWriter.
+func StreamFileSize(info FileInfo, opts Options) int64 {
+	head, _ := appendEntryHeader(appendArchiveHeader(nil, "", 1), info, info.Size)
+	return StreamPayloadSize(int64(len(head))+info.Size, ArchiveExtension, opts)
+}
+
+var errFileSize = errors.New("file size does not match its description")
+
+// FileWriter is an EmbedWriter for a single file, hidden together with its
+// name and metadata as ExtractTo expects. Exactly info.Size bytes have to be
+// written to it.
+type FileWriter struct {
+	*EmbedWriter
+	left int64
+}
+
+func NewFileWriter(src Carrier, info FileInfo, password string, opts Options) (*FileWriter, error) {
+	head, err := appendEntryHeader(appendArchiveHeader(nil, "", 1), info, info.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewEmbedWriter(src, ArchiveExtension, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(head); err != nil {
+		return nil, err
+	}
+	return &FileWriter{EmbedWriter: w, left: info.Size}, nil
+}
+
+func (w *FileWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.left {
+		return 0, errFileSize
+	}
+	n, err := w.EmbedWriter.Write(p)
+	w.left -= int64(n)
+	return n, err
+}
+
+func (w *FileWriter) Close() error {
+	if w.left != 0 {
+		return errFileSize
+	}
+	return w.EmbedWriter.Close()
+}
+
+// ExtractTo writes the hidden file of src to w and describes it. Streamed
+// payloads are decrypted chunk by chunk, so a failure can leave w holding
+// part of the file; callers should discard it then. Images written by
+// EmbedData are extracted too, in memory. Payloads holding several files or
+// a note besides a file fail with ErrSeveralFiles, text gives an empty Name.
+func ExtractTo(w io.Writer, src Carrier, password string, opts Options) (FileInfo, Verification, error) {
+	dst, err := carrierView(src)
+	if err != nil {
+		return FileInfo{}, Verification{}, err
+	}
+
+	header, body, err := findContainer(dst, 0, password, opts)
+	if err == nil && header.Cipher == CipherAES256GCMStream {
+		var f *fileFilter
+		extension, verification, err := openStream(func(extension string) io.Writer {
+			f = &fileFilter{w: w, archive: extension == ArchiveExtension}
+			return f
+		}, header, body, password, opts)
+		if err != nil {
+			return FileInfo{}, verification, err
+		}
+		info, err := f.result(extension)
+		return info, verification, err
+	}
+
+	var data []byte
+	var extension string
+	var verification Verification
+	switch {
+	case err == errNoHeader:
+		data, extension, verification, err = extractLegacy(dst, 0, password)
+	case err == nil:
+		data, extension, verification, err = openContainer(header, body, password, opts)
+	}
+	if err != nil {
+		return FileInfo{}, verification, err
+	}
+
+	e, err := OpenFile(data, extension)
+	if err != nil {
+		return FileInfo{}, verification, err
+	}
+	if _, err = w.Write(e.Data); err != nil {
+		return FileInfo{}, verification, err
+	}
+	return e.FileInfo, verification, nil
+}
+
+// openStream decrypts a streamed body into the writer sink returns for its
+// extension.
+func openStream(sink func(extension string) io.Writer, header *Header, body stream, password string, opts Options) (string, Verification, error) {
+	kdf, _ := header.kdf()
+	if kdf.ID == KDFX25519 && len(opts.Identities) == 0 {
+		return "", Verification{}, ErrIdentityRequired
+	}
+
+	r := io.LimitReader(body.Reader(0), int64(header.Length))
+	head := make([]byte, kdf.saltSize()+streamPrefixSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", Verification{}, ErrDataNotFound
+	}
+
+	sc, err := openStreamCipher(kdf, password, opts.Identities, head, header.associatedData())
+	if err != nil {
+		return "", Verification{}, ErrDecryptionFailed
+	}
+
+	signed := header.Flags&FlagSigned != 0
+	var digest hash.Hash
+	hold := 0
+	if signed {
+		digest = sha512.New()
+		hold = signatureTrailerSize
+	}
+
+	left := int(header.Length) - len(head)
+	sealed := make([]byte, streamChunkSize+streamTagSize)
+
+	// pending holds plaintext that may still turn out to be the signature,
+	// and the extension until it is complete.
+	var pending []byte
+	extension, haveExtension := "", false
+	var w io.Writer
+
+	for {
+		n := min(left, len(sealed))
+		if _, err := io.ReadFull(r, sealed[:n]); err != nil {
+			return "", Verification{}, ErrDataNotFound
+		}
+		left -= n
+
+		chunk, err := sc.open(sealed[:n], left == 0)
+		if err != nil {
+			return "", Verification{}, ErrDecryptionFailed
+		}
+		pending = append(pending, chunk...)
+
+		if !haveExtension {
+			if len(pending) < 1 || len(pending) < 1+int(pending[0]) {
+				if left == 0 {
+					return "", Verification{}, ErrInternal
+				}
+				continue
+			}
+			extLen := int(pending[0])
+			if digest != nil {
+				digest.Write(pending[:1+extLen])
+			}
+			extension, haveExtension = string(pending[1:1+extLen]), true
+			w = sink(extension)
+			pending = pending[1+extLen:]
+		}
+
+		if left == 0 {
+			break
+		}
+		if out := len(pending) - hold; out > 0 {
+			if err := writeHashed(w, digest, pending[:out]); err != nil {
+				return "", Verification{}, err
+			}
+			pending = append(pending[:0], pending[out:]...)
+		}
+	}
+
+	if len(pending) < hold {
+		return "", Verification{}, ErrInternal
+	}
+	if err := writeHashed(w, digest, pending[:len(pending)-hold]); err != nil {
+		return "", Verification{}, err
+	}
+
+	var verification Verification
+	if signed {
+		verification = verifyDigest(digest.Sum(nil), pending[len(pending)-hold:], opts.TrustedSigners)
+	}
+	return extension, verification, nil
+}
+
+func writeHashed(w io.Writer, digest hash.Hash, p []byte) error {
+	if digest != nil {
+		digest.Write(p)
+	}
+	_, err := w.Write(p)
+	return err
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// streamEmbed writes data through an EmbedWriter in sizes that cross chunk
+// boundaries.
+func streamEmbed(img Carrier, data []byte, ext, password string, opts Options) (Carrier, error) {
+	w, err := NewEmbedWriter(img, ext, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		n := min(len(data), 12345)
+		if _, err := w.Write(data[:n]); err != nil {
+			return nil, err
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Carrier(), nil
+}
+
+func TestStream(t *testing.T) {
+	k, _ := GenerateSigningKey()
+	id, _ := GenerateIdentity()
+	extract := Options{TrustedSigners: []*Signer{k.Signer()}, Identities: []*Identity{id}}
+
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize*3 + 17, 400000} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		for _, opts := range []Options{{}, {Traversal: TraversalScattered, SigningKey: k}, {Recipients: []*Recipient{id.Recipient()}, SigningKey: k}} {
+			password := "secret1"
+			if opts.Recipients != nil {
+				password = ""
+			}
+			out, err := streamEmbed(testImage(800, 800), data, ".bin", password, opts)
+			if err != nil {
+				t.Fatalf("%d bytes: %v", size, err)
+			}
+
+			var got bytes.Buffer
+			info, v, err := ExtractTo(&got, out, password, extract)
+			if err != nil || info.Name != "payload.bin" || !bytes.Equal(got.Bytes(), data) {
+				t.Fatalf("%d bytes: %v %+v", size, err, info)
+			}
+			if opts.SigningKey != nil && v.Status != SignatureVerified {
+				t.Fatalf("%d bytes: %+v", size, v)
+			}
+			all, _, _, err := ExtractData(out, 0, password, extract)
+			if err != nil || !bytes.Equal(all, data) {
+				t.Fatalf("%d bytes: ExtractData: %v", size, err)
+			}
+
+			if opts.Recipients != nil {
+				if _, _, err := ExtractTo(io.Discard, out, "", Options{}); err != ErrIdentityRequired {
+					t.Fatalf("no identity: got %v, want %v", err, ErrIdentityRequired)
+				}
+			} else if _, _, err := ExtractTo(io.Discard, out, "wrongpw", extract); err == nil {
+				t.Fatal("opened with the wrong password")
+			}
+		}
+	}
+}
+
+func TestStreamDamaged(t *testing.T) {
+	data := bytes.Repeat([]byte("chunked "), streamChunkSize/8+100)
+	out, err := streamEmbed(testImage(400, 400), data, "", "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the end of the final chunk, after the first has been written
+	// out.
+	m, err := carrierView(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := findContainer(m, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int(StreamPayloadSize(int64(len(data)), "", Options{}))
+	if err := body.Embed(make([]byte, 16), size-16); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ExtractTo(io.Discard, out, "secret1", Options{}); err != ErrDecryptionFailed {
+		t.Fatalf("got %v, want %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestStreamFromEmbedData(t *testing.T) {
+	out, err := EmbedData(testImage(60, 60), []byte("plain"), ".txt", 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if info, _, err := ExtractTo(&got, out, "secret1", Options{}); err != nil || got.String() != "plain" || info.Name != "payload.txt" {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamJPEG(t *testing.T) {
+	img, err := DecodeImage(bytes.NewReader(jpegBytes(t, testImage(320, 240), 92)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("jpeg stream "), 300)
+	out, err := streamEmbed(img, data, ".txt", "secret1", Options{Traversal: TraversalScattered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeCarrier(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	back, err := DecodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if _, _, err := ExtractTo(&got, back, "secret1", Options{}); err != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Fatal(err)
+	}
+}
+
+// TestStreamCapacity fills an image to the byte by StreamPayloadSize.
+func TestStreamCapacity(t *testing.T) {
+	k, _ := GenerateSigningKey()
+	for _, opts := range []Options{{}, {SigningKey: k}} {
+		img := testImage(300, 300)
+		c := int64(Capacity(img, opts))
+		n := c - StreamPayloadSize(0, ".x", opts)
+		for StreamPayloadSize(n, ".x", opts) > c {
+			n--
+		}
+
+		if _, err := streamEmbed(img, make([]byte, n), ".x", "secret1", opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := streamEmbed(img, make([]byte, n+1), ".x", "secret1", opts); err != ErrImageTooSmall {
+			t.Fatalf("one byte over: got %v, want %v", err, ErrImageTooSmall)
+		}
+	}
+	if _, err := streamEmbed(testImage(40, 40), make([]byte, 100000), "", "secret1", Options{}); err != ErrImageTooSmall {
+		t.Fatalf("got %v, want %v", err, ErrImageTooSmall)
+	}
+}
+
+func TestUncompressedPayloadSize(t *testing.T) {
+	k, _ := GenerateSigningKey()
+	data := bytes.Repeat([]byte{1, 2, 3}, 1000)
+	for _, opts := range []Options{{Compression: CompressionNone}, {Compression: CompressionNone, SigningKey: k}} {
+		if got, want := UncompressedPayloadSize(len(data), ".bin", opts), PayloadSize(data, ".bin", opts); got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+}
+
+func TestFileWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("file stream "), 20000)
+	mt := time.Unix(1600000000, 5)
+	info := FileInfo{Name: "notes.txt", Size: int64(len(data)), Mode: 0o600, ModTime: mt, MIME: "text/plain"}
+
+	for _, opts := range []Options{{}, {Traversal: TraversalScattered}} {
+		w, err := NewFileWriter(testImage(800, 800), info, "secret1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var got bytes.Buffer
+		back, _, err := ExtractTo(&got, w.Carrier(), "secret1", Options{})
+		if err != nil || !bytes.Equal(got.Bytes(), data) {
+			t.Fatal(err)
+		}
+		if back.Name != info.Name || back.Size != info.Size || back.Mode != info.Mode || !back.ModTime.Equal(mt) || back.MIME != info.MIME {
+			t.Fatalf("got %+v", back)
+		}
+
+		all, ext, _, err := ExtractData(w.Carrier(), 0, "secret1", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, err := OpenFile(all, ext); err != nil || e.Name != info.Name || !bytes.Equal(e.Data, data) {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		if size := StreamFileSize(info, opts); size <= StreamPayloadSize(info.Size, ArchiveExtension, opts) {
+			t.Fatalf("StreamFileSize %d leaves out the file header", size)
+		}
+	}
+}
+
+func TestFileWriterSize(t *testing.T) {
+	info := FileInfo{Name: "a", Size: 100}
+
+	w, err := NewFileWriter(testImage(200, 200), info, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != errFileSize {
+		t.Fatalf("short: got %v, want %v", err, errFileSize)
+	}
+
+	w, err = NewFileWriter(testImage(200, 200), info, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 101)); err != errFileSize {
+		t.Fatalf("long: got %v, want %v", err, errFileSize)
+	}
+}
+
+func TestExtractToSeveralFiles(t *testing.T) {
+	a := &Archive{Note: "n", Entries: []Entry{NewEntry(FileInfo{Name: "a"}, []byte("x"))}}
+	payload, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := EmbedData(testImage(60, 60), payload, ArchiveExtension, 0, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ExtractTo(io.Discard, out, "secret1", Options{}); err != ErrSeveralFiles {
+		t.Fatalf("got %v, want %v", err, ErrSeveralFiles)
+	}
+
+	out, err = streamEmbed(testImage(200, 200), payload, ArchiveExtension, "secret1", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ExtractTo(io.Discard, out, "secret1", Options{}); err != ErrSeveralFiles {
+		t.Fatalf("stream: got %v, want %v", err, ErrSeveralFiles)
+	}
+}
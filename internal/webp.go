@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+)
+
+// The standard library and x/image only decode WebP, so lossless results are
+// written by this minimal VP8L encoder: the subtract-green transform, one
+// Average2(L, T) predictor for the whole image and a single set of prefix
+// codes over literal pixels, without backward references.
+
+const (
+	vp8lMaxSize        = 1 << 14
+	vp8lPredictorBits  = 9
+	vp8lPredictorMode  = 7
+	vp8lGreenAlphabet  = 256 + 24
+	vp8lDistanceCodes  = 40
+	vp8lMaxCodeLength  = 15
+	vp8lMaxCodeLength2 = 7
+)
+
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func encodeWebP(w io.Writer, img *image.NRGBA) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return errors.New("webp: unsupported image size")
+	}
+
+	pix := make([]byte, 0, 4*width*height)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		pix = append(pix, img.Pix[i:i+4*width]...)
+	}
+
+	// Subtract green, then predict every pixel from its neighbours.
+	for p := 0; p < len(pix); p += 4 {
+		pix[p+0] -= pix[p+1]
+		pix[p+2] -= pix[p+1]
+	}
+	res := make([]byte, len(pix))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := 4 * (y*width + x)
+			for c := 0; c < 4; c++ {
+				var pred byte
+				switch {
+				case x == 0 && y == 0:
+					if c == 3 {
+						pred = 0xff
+					}
+				case y == 0:
+					pred = pix[p-4+c]
+				case x == 0:
+					pred = pix[p-4*width+c]
+				default:
+					pred = byte((int(pix[p-4+c]) + int(pix[p-4*width+c])) / 2)
+				}
+				res[p+c] = pix[p+c] - pred
+			}
+		}
+	}
+
+	var bw lsbWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if img.Opaque() {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3)
+
+	// Transforms, in the order the decoder reads and undoes them backwards.
+	bw.write(1, 1)
+	bw.write(2, 2)
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	tiles := ((width + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits) * ((height + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits)
+	mode := make([]byte, 4*tiles)
+	for p := 0; p < len(mode); p += 4 {
+		mode[p+1] = vp8lPredictorMode
+	}
+	writeVP8LImage(&bw, mode, false)
+	bw.write(0, 1)
+
+	writeVP8LImage(&bw, res, true)
+
+	data := bw.bytes()
+	pad := len(data) & 1
+	head := make([]byte, 0, 20)
+	head = append(head, "RIFF"...)
+	head = binary.LittleEndian.AppendUint32(head, uint32(4+8+len(data)+pad))
+	head = append(head, "WEBPVP8L"...)
+	head = binary.LittleEndian.AppendUint32(head, uint32(len(data)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if pad != 0 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeVP8LImage writes RGBA pixels as literals under one set of prefix
+// codes. Only the main image may have meta prefix codes, which are left out.
+func writeVP8LImage(bw *lsbWriter, pix []byte, main bool) {
+	bw.write(0, 1)
+	if main {
+		bw.write(0, 1)
+	}
+
+	green := make([]int, vp8lGreenAlphabet)
+	red, blue, alpha := make([]int, 256), make([]int, 256), make([]int, 256)
+	for p := 0; p < len(pix); p += 4 {
+		red[pix[p]]++
+		green[pix[p+1]]++
+		blue[pix[p+2]]++
+		alpha[pix[p+3]]++
+	}
+
+	codes := make([]*prefixCode, 0, 5)
+	for _, h := range [][]int{green, red, blue, alpha, make([]int, vp8lDistanceCodes)} {
+		c := newPrefixCode(h, vp8lMaxCodeLength)
+		c.writeHeader(bw)
+		codes = append(codes, c)
+	}
+
+	for p := 0; p < len(pix); p += 4 {
+		codes[0].writeSymbol(bw, int(pix[p+1]))
+		codes[1].writeSymbol(bw, int(pix[p]))
+		codes[2].writeSymbol(bw, int(pix[p+2]))
+		codes[3].writeSymbol(bw, int(pix[p+3]))
+	}
+}
+
+// prefixCode is a canonical Huffman code. A code of a single symbol takes no
+// bits at all, as decoders read it.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+	single  bool
+}
+
+func newPrefixCode(hist []int, limit int) *prefixCode {
+	used := 0
+	for _, f := range hist {
+		if f > 0 {
+			used++
+		}
+	}
+	if used == 0 {
+		// Unused alphabets still need a valid code.
+		hist = append([]int{1}, hist[1:]...)
+		used = 1
+	}
+
+	c := &prefixCode{lengths: codeLengths(hist, limit), single: used == 1}
+	c.codes = make([]uint16, len(hist))
+
+	var count [vp8lMaxCodeLength + 1]int
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeLength + 1]int
+	code := 0
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range c.lengths {
+		if l == 0 {
+			continue
+		}
+		// Codes are read most significant bit first.
+		v := next[l]
+		next[l]++
+		var r uint16
+		for i := 0; i < int(l); i++ {
+			r = r<<1 | uint16(v>>i&1)
+		}
+		c.codes[s] = r
+	}
+	return c
+}
+
+// codeLengths builds Huffman code lengths no longer than limit, flattening
+// the histogram until they fit.
+func codeLengths(hist []int, limit int) []uint8 {
+	hist = append([]int(nil), hist...)
+	for {
+		type node struct {
+			freq, left, right, sym int
+		}
+		var nodes []node
+		for s, f := range hist {
+			if f > 0 {
+				nodes = append(nodes, node{freq: f, left: -1, right: -1, sym: s})
+			}
+		}
+		lengths := make([]uint8, len(hist))
+		if len(nodes) == 1 {
+			lengths[nodes[0].sym] = 1
+			return lengths
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].freq < nodes[j].freq })
+
+		// Leaves and merged nodes each come out in order of frequency, so
+		// the two smallest are always at the front of one of them.
+		leaves, i, j := len(nodes), 0, len(nodes)
+		smallest := func() int {
+			if i < leaves && (j >= len(nodes) || nodes[i].freq <= nodes[j].freq) {
+				i++
+				return i - 1
+			}
+			j++
+			return j - 1
+		}
+		for k := 1; k < leaves; k++ {
+			a, b := smallest(), smallest()
+			nodes = append(nodes, node{freq: nodes[a].freq + nodes[b].freq, left: a, right: b, sym: -1})
+		}
+
+		depth := make([]int, len(nodes))
+		deepest := 0
+		for k := len(nodes) - 1; k >= leaves; k-- {
+			depth[nodes[k].left] = depth[k] + 1
+			depth[nodes[k].right] = depth[k] + 1
+		}
+		for k := 0; k < leaves; k++ {
+			lengths[nodes[k].sym] = uint8(depth[k])
+			deepest = max(deepest, depth[k])
+		}
+		if deepest <= limit {
+			return lengths
+		}
+
+		for s, f := range hist {
+			if f > 0 {
+				hist[s] = max(f/2, 1)
+			}
+		}
+	}
+}
+
+// writeHeader writes the code lengths, themselves prefix coded.
+func (c *prefixCode) writeHeader(bw *lsbWriter) {
+	bw.write(0, 1)
+
+	hist := make([]int, 19)
+	for _, l := range c.lengths {
+		hist[l]++
+	}
+	lc := newPrefixCode(hist, vp8lMaxCodeLength2)
+
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && lc.lengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		bw.write(uint32(lc.lengths[s]), 3)
+	}
+
+	bw.write(0, 1)
+	for _, l := range c.lengths {
+		lc.writeSymbol(bw, int(l))
+	}
+}
+
+func (c *prefixCode) writeSymbol(bw *lsbWriter, s int) {
+	if !c.single {
+		bw.write(uint32(c.codes[s]), uint(c.lengths[s]))
+	}
+}
+
+// lsbWriter packs bits least significant first, unlike the bitWriter of JPEG
+// scans.
+type lsbWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (w *lsbWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.bits
+	w.bits += n
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+func (w *lsbWriter) bytes() []byte {
+	if w.bits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.bits = 0, 0
+	}
+	return w.buf
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	flat := image.NewNRGBA(image.Rect(0, 0, 600, 530))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.NRGBA{10, 200, 30, 255}), image.Point{}, draw.Src)
+
+	for _, img := range []*image.NRGBA{noise, flat, image.NewNRGBA(image.Rect(0, 0, 1, 1)), testImage(203, 151)} {
+		var buf bytes.Buffer
+		if err := encodeWebP(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		back, name, err := image.Decode(&buf)
+		if err != nil || name != "webp" {
+			t.Fatalf("%v: %v %s", img.Bounds(), err, name)
+		}
+		if got := format(back); got.Bounds() != img.Bounds() || !bytes.Equal(got.Pix, img.Pix) {
+			t.Fatalf("%v: pixels differ", img.Bounds())
+		}
+	}
+}